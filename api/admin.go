@@ -0,0 +1,459 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	audit "github.com/anmho/idempotent-rides/audit"
+	"github.com/anmho/idempotent-rides/emails"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/send"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultStuckKeysLimit is the number of keys listed by GET /admin/idempotency-keys/stuck
+	// when no limit is given.
+	DefaultStuckKeysLimit = 100
+	MaxStuckKeysLimit     = 1000
+	// DefaultUserEmailsLimit is the number of emails listed by GET /admin/users/{id}/emails
+	// when no limit is given.
+	DefaultUserEmailsLimit = 50
+	MaxUserEmailsLimit     = 500
+
+	auditResourceIdempotencyKey = "idempotency_key"
+	auditActionForceUnlocked    = "force_unlocked"
+	auditActionForceFinished    = "force_finished"
+)
+
+// AdminKeyResponse is an idempotency key as shown to operators.
+type AdminKeyResponse struct {
+	ID              int                       `json:"id"`
+	CreatedAt       time.Time                 `json:"created_at"`
+	Key             string                    `json:"idempotency_key"`
+	LastRunAt       time.Time                 `json:"last_run_at"`
+	LockedAt        *time.Time                `json:"locked_at"`
+	RequestMethod   string                    `json:"request_method"`
+	RequestParams   json.RawMessage           `json:"request_params"`
+	RequestPath     string                    `json:"request_path"`
+	ResponseCode    *int                      `json:"response_code"`
+	ResponseHeaders http.Header               `json:"response_headers,omitempty"`
+	ResponseBody    any                       `json:"response_body"`
+	RecoveryPoint   string                    `json:"recovery_point"`
+	UserID          int                       `json:"user_id"`
+	Attempts        int                       `json:"attempts"`
+	Transitions     []AdminTransitionResponse `json:"transitions,omitempty"`
+}
+
+// AdminTransitionResponse is an entry in the timeline of a key.
+type AdminTransitionResponse struct {
+	CreatedAt  time.Time `json:"created_at"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	DurationMs int64     `json:"duration_ms"`
+	Attempts   int       `json:"attempts"`
+	Error      *string   `json:"error"`
+}
+
+// AdminEmailResponse is an email from the outbox as shown to operators.
+type AdminEmailResponse struct {
+	ID                int        `json:"id"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	Recipient         string     `json:"recipient"`
+	Template          string     `json:"template"`
+	Locale            string     `json:"locale"`
+	Subject           string     `json:"subject"`
+	Status            string     `json:"status"`
+	ProviderMessageID *string    `json:"provider_message_id"`
+	Attempts          int        `json:"attempts"`
+	LastError         *string    `json:"last_error"`
+	SentAt            *time.Time `json:"sent_at"`
+}
+
+type AdminFinishKeyParams struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+	Reason string          `json:"reason"`
+}
+
+type AdminUnlockKeyParams struct {
+	Reason string `json:"reason"`
+}
+
+func newAdminKeyResponse(key *idempotency.Key, transitions []*idempotency.Transition) AdminKeyResponse {
+	resp := AdminKeyResponse{
+		ID:              key.ID,
+		CreatedAt:       key.CreatedAt,
+		Key:             key.Key,
+		LastRunAt:       key.LastRunAt,
+		RequestMethod:   key.RequestMethod.String(),
+		RequestParams:   key.RequestParams,
+		RequestPath:     key.RequestPath,
+		ResponseHeaders: http.Header(key.ResponseHeaders),
+		RecoveryPoint:   key.RecoveryPoint.String(),
+		UserID:          key.UserID,
+		Attempts:        key.Attempts,
+	}
+	if key.LockedAt.Valid {
+		resp.LockedAt = &key.LockedAt.V
+	}
+	if key.ResponseCode.Valid {
+		resp.ResponseCode = &key.ResponseCode.V
+	}
+	if key.ResponseBody.Valid {
+		// Stored bodies are usually JSON, but they are replayed as raw bytes, so
+		// anything else is shown as a string.
+		if json.Valid(key.ResponseBody.V) {
+			resp.ResponseBody = json.RawMessage(key.ResponseBody.V)
+		} else {
+			resp.ResponseBody = string(key.ResponseBody.V)
+		}
+	}
+	for _, t := range transitions {
+		transition := AdminTransitionResponse{
+			CreatedAt:  t.CreatedAt,
+			From:       t.From.String(),
+			To:         t.To.String(),
+			DurationMs: t.Duration.Milliseconds(),
+			Attempts:   t.Attempts,
+		}
+		if t.Error.Valid {
+			transition.Error = &t.Error.V
+		}
+		resp.Transitions = append(resp.Transitions, transition)
+	}
+	return resp
+}
+
+func newAdminEmailResponse(msg *emails.OutboxMessage) AdminEmailResponse {
+	resp := AdminEmailResponse{
+		ID:        msg.ID,
+		CreatedAt: msg.CreatedAt,
+		UpdatedAt: msg.UpdatedAt,
+		Recipient: msg.Recipient,
+		Template:  msg.Template,
+		Locale:    msg.Locale,
+		Subject:   msg.Subject,
+		Status:    string(msg.Status),
+		Attempts:  msg.Attempts,
+	}
+	if msg.ProviderMessageID.Valid {
+		resp.ProviderMessageID = &msg.ProviderMessageID.V
+	}
+	if msg.LastError.Valid {
+		resp.LastError = &msg.LastError.V
+	}
+	if msg.SentAt.Valid {
+		resp.SentAt = &msg.SentAt.V
+	}
+	return resp
+}
+
+// requireAdmin only lets requests through that carry token as a bearer token.
+func requireAdmin(token string, next RouteHandler) RouteHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			return send.HTTPError{
+				Message: "admin token required",
+				Status:  http.StatusUnauthorized,
+			}
+		}
+		return next(w, r)
+	}
+}
+
+func handleDebugVars() RouteHandler {
+	handler := expvar.Handler()
+	return func(w http.ResponseWriter, r *http.Request) error {
+		handler.ServeHTTP(w, r)
+		return nil
+	}
+}
+
+func handleAdminGetKey(keyStore idempotency.KeyStore) RouteHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		ctx := r.Context()
+		query := r.URL.Query()
+
+		keyVal := query.Get("key")
+		if keyVal == "" {
+			return send.HTTPError{
+				Message: "key is required",
+				Status:  http.StatusBadRequest,
+			}
+		}
+		var userID int
+		if s := query.Get("user_id"); s != "" {
+			var err error
+			if userID, err = strconv.Atoi(s); err != nil {
+				return send.HTTPError{
+					Cause:   err,
+					Message: "invalid user_id",
+					Status:  http.StatusBadRequest,
+				}
+			}
+		}
+
+		key, err := findKey(ctx, keyStore, userID, keyVal)
+		if errors.Is(err, sql.ErrNoRows) {
+			return send.HTTPError{
+				Cause:   err,
+				Message: "idempotency key not found",
+				Status:  http.StatusNotFound,
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		transitions, err := keyStore.FindTransitions(ctx, key.ID)
+		if err != nil {
+			return fmt.Errorf("finding transitions: %w", err)
+		}
+		return send.WriteJSON(w, http.StatusOK, newAdminKeyResponse(key, transitions))
+	}
+}
+
+func findKey(ctx context.Context, keyStore idempotency.KeyStore, userID int, keyVal string) (*idempotency.Key, error) {
+	tx, err := keyStore.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	key, err := keyStore.FindKey(ctx, tx, userID, keyVal)
+	if err != nil {
+		return nil, err
+	}
+	return key, tx.Commit()
+}
+
+func handleAdminListStuckKeys(keyStore idempotency.KeyStore) RouteHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		query := r.URL.Query()
+
+		recoveryPoint := idempotency.RecoveryPointEnum(query.Get("recovery_point"))
+		if recoveryPoint != "" && !recoveryPoint.IsValid() {
+			return send.HTTPError{
+				Message: "invalid recovery_point",
+				Status:  http.StatusBadRequest,
+			}
+		}
+		olderThan, err := queryInt(query.Get("older_than_minutes"), 0)
+		if err != nil || olderThan < 0 {
+			return send.HTTPError{
+				Cause:   err,
+				Message: "invalid older_than_minutes",
+				Status:  http.StatusBadRequest,
+			}
+		}
+		limit, err := queryInt(query.Get("limit"), DefaultStuckKeysLimit)
+		if err != nil || limit <= 0 || limit > MaxStuckKeysLimit {
+			return send.HTTPError{
+				Cause:   err,
+				Message: fmt.Sprintf("limit must be between 1 and %d", MaxStuckKeysLimit),
+				Status:  http.StatusBadRequest,
+			}
+		}
+
+		lastRunBefore := time.Now().Add(-time.Duration(olderThan) * time.Minute)
+		keys, err := keyStore.FindStuckKeys(r.Context(), recoveryPoint, lastRunBefore, limit)
+		if err != nil {
+			return fmt.Errorf("finding stuck keys: %w", err)
+		}
+
+		resp := make([]AdminKeyResponse, 0, len(keys))
+		for _, key := range keys {
+			resp = append(resp, newAdminKeyResponse(key, nil))
+		}
+		return send.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+func queryInt(s string, fallback int) (int, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func handleAdminListUserEmails(db *sql.DB, outboxService emails.OutboxService) RouteHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		userID, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			return send.HTTPError{
+				Cause:   err,
+				Message: "invalid user id",
+				Status:  http.StatusBadRequest,
+			}
+		}
+		limit, err := queryInt(r.URL.Query().Get("limit"), DefaultUserEmailsLimit)
+		if err != nil || limit <= 0 || limit > MaxUserEmailsLimit {
+			return send.HTTPError{
+				Cause:   err,
+				Message: fmt.Sprintf("limit must be between 1 and %d", MaxUserEmailsLimit),
+				Status:  http.StatusBadRequest,
+			}
+		}
+
+		msgs, err := outboxService.ListUserMessages(r.Context(), db, userID, limit)
+		if err != nil {
+			return fmt.Errorf("listing emails: %w", err)
+		}
+
+		resp := make([]AdminEmailResponse, 0, len(msgs))
+		for _, msg := range msgs {
+			resp = append(resp, newAdminEmailResponse(msg))
+		}
+		return send.WriteJSON(w, http.StatusOK, resp)
+	}
+}
+
+func handleAdminUnlockKey(keyStore idempotency.KeyStore, auditService audit.Service) RouteHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		// The body is optional when no reason is given.
+		params, err := send.Read[AdminUnlockKeyParams](r.Body)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return send.HTTPError{
+				Cause:   err,
+				Message: "bad request",
+				Status:  http.StatusBadRequest,
+			}
+		}
+
+		return repairKey(w, r, keyStore, auditService, auditActionForceUnlocked, params.Reason,
+			func(ctx context.Context, tx idempotency.Tx, keyID int) (*idempotency.Key, error) {
+				return idempotency.ForceUnlock(ctx, keyStore, tx, keyID)
+			})
+	}
+}
+
+func handleAdminFinishKey(keyStore idempotency.KeyStore, auditService audit.Service) RouteHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		params, err := send.Read[AdminFinishKeyParams](r.Body)
+		if err != nil {
+			return send.HTTPError{
+				Cause:   err,
+				Message: "bad request",
+				Status:  http.StatusBadRequest,
+			}
+		}
+		if http.StatusText(params.Status) == "" || len(params.Body) == 0 {
+			return send.HTTPError{
+				Message: "a valid status and a JSON body are required",
+				Status:  http.StatusBadRequest,
+			}
+		}
+
+		return repairKey(w, r, keyStore, auditService, auditActionForceFinished, params.Reason,
+			func(ctx context.Context, tx idempotency.Tx, keyID int) (*idempotency.Key, error) {
+				return idempotency.ForceFinish(ctx, keyStore, tx, keyID, idempotency.NewResponseResult(params.Status, params.Body))
+			})
+	}
+}
+
+type repairFunc func(ctx context.Context, tx idempotency.Tx, keyID int) (*idempotency.Key, error)
+
+// repairKey applies repair to the key named by the id path value and writes an audit
+// record of it in the same transaction.
+func repairKey(
+	w http.ResponseWriter,
+	r *http.Request,
+	keyStore idempotency.KeyStore,
+	auditService audit.Service,
+	action string,
+	reason string,
+	repair repairFunc) error {
+
+	ctx := r.Context()
+	keyID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return send.HTTPError{
+			Cause:   err,
+			Message: "invalid idempotency key id",
+			Status:  http.StatusBadRequest,
+		}
+	}
+
+	tx, err := keyStore.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// The repair is audited in the same transaction, which needs a store backed by
+	// Postgres.
+	if tx.SQL() == nil {
+		return fmt.Errorf("repairing key: %w", idempotency.ErrNoSQL)
+	}
+
+	previous, err := keyStore.FindKeyByID(ctx, tx, keyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return send.HTTPError{
+			Cause:   err,
+			Message: "idempotency key not found",
+			Status:  http.StatusNotFound,
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("finding key: %w", err)
+	}
+
+	key, err := repair(ctx, tx, keyID)
+	if errors.Is(err, idempotency.ErrKeyFinished) {
+		return send.HTTPError{
+			Cause:   err,
+			Code:    "idempotency_key_finished",
+			Message: "idempotency key is already finished",
+			Status:  http.StatusConflict,
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("repairing key: %w", err)
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"idempotency_key":         key.Key,
+		"reason":                  reason,
+		"previous_recovery_point": previous.RecoveryPoint,
+		"previous_locked_at":      previous.LockedAt.V,
+		"previously_locked":       previous.LockedAt.Valid,
+		"recovery_point":          key.RecoveryPoint,
+		"user_id":                 key.UserID,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = auditService.CreateRecord(ctx, tx.SQL(), audit.NewRecord(
+		action, data, originIP(r),
+		audit.Resource{ID: key.ID, Type: auditResourceIdempotencyKey},
+		0,
+	))
+	if err != nil {
+		return fmt.Errorf("creating audit record: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	return send.WriteJSON(w, http.StatusOK, newAdminKeyResponse(key, nil))
+}
+
+// originIP returns the IP address the request came from.
+func originIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
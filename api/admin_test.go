@@ -0,0 +1,229 @@
+package api_test
+
+import (
+	"github.com/anmho/idempotent-rides/api"
+	"github.com/anmho/idempotent-rides/send"
+	"github.com/anmho/idempotent-rides/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const testAdminToken = "testAdminToken"
+
+func TestServer_handleAdmin(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc   string
+		method string
+		path   string
+		body   string
+		token  string
+
+		expectedStatus        int
+		expectedRecoveryPoint string
+		expectedUnlocked      bool
+	}{
+		{
+			desc:   "error path: missing admin token. should return 401",
+			method: http.MethodGet,
+			path:   "/admin/idempotency-keys?user_id=123&key=testKeyStarted",
+			token:  "",
+
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			desc:   "error path: wrong admin token. should return 401",
+			method: http.MethodPost,
+			path:   "/admin/idempotency-keys/736/unlock",
+			token:  "wrongToken",
+
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			desc:   "error path: debug vars without admin token. should return 401",
+			method: http.MethodGet,
+			path:   "/debug/vars",
+			token:  "",
+
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			desc:   "happy path: debug vars with admin token",
+			method: http.MethodGet,
+			path:   "/debug/vars",
+			token:  testAdminToken,
+
+			expectedStatus: http.StatusOK,
+		},
+		{
+			desc:   "happy path: get key by user and key",
+			method: http.MethodGet,
+			path:   "/admin/idempotency-keys?user_id=123&key=testKeyRideCreated",
+			token:  testAdminToken,
+
+			expectedStatus:        http.StatusOK,
+			expectedRecoveryPoint: "ride_created",
+		},
+		{
+			desc:   "error path: get key that doesn't exist. should return 404",
+			method: http.MethodGet,
+			path:   "/admin/idempotency-keys?user_id=456&key=testKeyRideCreated",
+			token:  testAdminToken,
+
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			desc:   "happy path: force unlock key",
+			method: http.MethodPost,
+			path:   "/admin/idempotency-keys/736/unlock",
+			body:   `{"reason": "request crashed"}`,
+			token:  testAdminToken,
+
+			expectedStatus:        http.StatusOK,
+			expectedRecoveryPoint: "started",
+			expectedUnlocked:      true,
+		},
+		{
+			desc:   "happy path: force finish key",
+			method: http.MethodPost,
+			path:   "/admin/idempotency-keys/737/finish",
+			body:   `{"status": 500, "body": {"message": "refunded manually"}, "reason": "stripe outage"}`,
+			token:  testAdminToken,
+
+			expectedStatus:        http.StatusOK,
+			expectedRecoveryPoint: "finished",
+			expectedUnlocked:      true,
+		},
+		{
+			desc:   "error path: force finish finished key. should return 409",
+			method: http.MethodPost,
+			path:   "/admin/idempotency-keys/739/finish",
+			body:   `{"status": 500, "body": {}}`,
+			token:  testAdminToken,
+
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			desc:   "error path: force unlock key that doesn't exist. should return 404",
+			method: http.MethodPost,
+			path:   "/admin/idempotency-keys/1/unlock",
+			token:  testAdminToken,
+
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			desc:   "error path: invalid limit. should return 400",
+			method: http.MethodGet,
+			path:   "/admin/idempotency-keys/stuck?limit=0",
+			token:  testAdminToken,
+
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			srv := test.MakeTestServerWithConfig(t, api.Config{AdminToken: testAdminToken})
+
+			req := must(http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body)))
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			resp := must(srv.Client().Do(req))
+			require.NotNil(t, resp)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedRecoveryPoint == "" {
+				return
+			}
+
+			key, err := send.Read[api.AdminKeyResponse](resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedRecoveryPoint, key.RecoveryPoint)
+			if tc.expectedUnlocked {
+				assert.Nil(t, key.LockedAt)
+			}
+		})
+	}
+}
+
+func TestServer_handleAdminListStuckKeys(t *testing.T) {
+	t.Parallel()
+
+	srv := test.MakeTestServerWithConfig(t, api.Config{AdminToken: testAdminToken})
+
+	req := must(http.NewRequest(http.MethodGet, srv.URL+"/admin/idempotency-keys/stuck?recovery_point=charge_created", nil))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	resp := must(srv.Client().Do(req))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	keys, err := send.Read[[]api.AdminKeyResponse](resp.Body)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, 738, keys[0].ID)
+}
+
+func TestServer_handleAdminListUserEmails(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc string
+		path string
+
+		expectedStatus int
+		expectedIDs    []int
+	}{
+		{
+			desc: "happy path: list emails of user",
+			path: "/admin/users/123/emails",
+
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{5150},
+		},
+		{
+			desc: "happy path: user without emails",
+			path: "/admin/users/456/emails",
+
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []int{},
+		},
+		{
+			desc: "error path: invalid user id. should return 400",
+			path: "/admin/users/abc/emails",
+
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			desc: "error path: invalid limit. should return 400",
+			path: "/admin/users/123/emails?limit=1001",
+
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			srv := test.MakeTestServerWithConfig(t, api.Config{AdminToken: testAdminToken})
+
+			req := must(http.NewRequest(http.MethodGet, srv.URL+tc.path, nil))
+			req.Header.Set("Authorization", "Bearer "+testAdminToken)
+			resp := must(srv.Client().Do(req))
+			require.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			msgs, err := send.Read[[]api.AdminEmailResponse](resp.Body)
+			require.NoError(t, err)
+			ids := []int{}
+			for _, msg := range msgs {
+				ids = append(ids, msg.ID)
+				assert.Equal(t, "bounced", msg.Status)
+			}
+			assert.Equal(t, tc.expectedIDs, ids)
+		})
+	}
+}
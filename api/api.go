@@ -1,43 +1,102 @@
 package api
 
 import (
+	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
-	audit "github.com/anmho/idempotent-rides/audit"
 	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/payments"
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/anmho/idempotent-rides/quotes"
 	"github.com/anmho/idempotent-rides/rides"
 	"github.com/anmho/idempotent-rides/scope"
 	"github.com/anmho/idempotent-rides/send"
 	"github.com/anmho/idempotent-rides/users"
-	"github.com/stripe/stripe-go/v79"
-	"github.com/stripe/stripe-go/v79/customer"
-	"github.com/stripe/stripe-go/v79/paymentintent"
 	"log/slog"
 	"net/http"
 )
 
-func MakeServer(db *sql.DB) http.Handler {
+// Config holds the server settings that are not dependencies.
+type Config struct {
+	Idempotency idempotency.Config
+	// AdminToken is the bearer token required by the /admin routes. They are not
+	// served when it is empty.
+	AdminToken string
+	// Pricing sets the fares of rides.
+	Pricing pricing.Config
+	// Quotes configures POST /quotes, which isn't served when its signing key is empty.
+	Quotes quotes.Config
+}
+
+// MakeServer returns the API. Its routes use services, which should be shared with
+// MakeCompleter.
+func MakeServer(db *sql.DB, cfg Config, services *Services) http.Handler {
 	mux := http.NewServeMux()
-	rideService := rides.MakeService()
-	auditService := audit.MakeService()
-	userService := users.MakeService()
+	keyStore := idempotency.MakePostgresStore(db)
 
 	// register middlewares
-	registerRoutes(mux, db, rideService, auditService, userService)
+	registerRoutes(mux, db, keyStore, cfg, services)
 
 	return mux
 }
 
+// MakeCompleter returns a completer that can finish any idempotent request served by
+// MakeServer with the same services.
+func MakeCompleter(db *sql.DB, cfg Config, completerCfg idempotency.CompleterConfig, services *Services) *idempotency.Completer {
+	registry := idempotency.MakeRegistry()
+	registerIdempotentRoutes(registry, services)
+
+	completerCfg.Lock = cfg.Idempotency
+	return idempotency.MakeCompleter(idempotency.MakePostgresStore(db), completerCfg, registry)
+}
+
 func handleError(w http.ResponseWriter, err error) {
-	if errors.As(err, &send.HTTPError{}) {
-		send.Error(w, err.(send.HTTPError))
+	var httpErr send.HTTPError
+	if errors.As(err, &httpErr) {
+		send.Error(w, httpErr)
 	} else {
 		send.Error(w, send.NewErrInternal(err))
 	}
 }
 
+// classifyPaymentError turns a failed payment gateway call made in a phase into a
+// terminal error when retrying can't help, like a declined card, a request the
+// processor rejected or a conflicting idempotency key, and a retryable one otherwise.
+func classifyPaymentError(err error) error {
+	var cardErr *payments.CardError
+	if errors.As(err, &cardErr) {
+		return terminalError(send.HTTPError{
+			Cause:   err,
+			Code:    cardErr.Code,
+			Message: cardErr.Message,
+			Status:  http.StatusPaymentRequired,
+		})
+	}
+	var invalidRequestErr *payments.InvalidRequestError
+	if errors.As(err, &invalidRequestErr) {
+		// The request was built by us, so it's not the client's fault and the message
+		// of the processor, which may name internal IDs, isn't passed on.
+		return terminalError(send.HTTPError{
+			Cause:   err,
+			Code:    "payment_request_rejected",
+			Message: "the payment processor rejected the request",
+			Status:  http.StatusBadGateway,
+		})
+	}
+	if errors.Is(err, payments.ErrKeyReused) {
+		// The key sent to the processor is derived from ours, so a conflict is a bug
+		// on our side that every retry would run into again.
+		return terminalError(send.HTTPError{
+			Cause:   err,
+			Code:    "payment_idempotency_conflict",
+			Message: "the payment could not be processed",
+			Status:  http.StatusInternalServerError,
+		})
+	}
+	return idempotency.Retryable(err)
+}
+
 type RouteHandler = func(w http.ResponseWriter, r *http.Request) error
 
 func MakeHandlerFunc(f RouteHandler) http.HandlerFunc {
@@ -51,7 +110,6 @@ func MakeHandlerFunc(f RouteHandler) http.HandlerFunc {
 
 const (
 	MinIdempotencyKeyLength = 2
-	IdempotencyKeyLockTimeout
 )
 
 func validateIdempotencyKey(key string) bool {
@@ -62,58 +120,47 @@ type RegisterUserParams struct {
 	Email string
 }
 
-func handleRegisterUser(db *sql.DB, userService users.Service) RouteHandler {
-	return func(w http.ResponseWriter, r *http.Request) error {
-		ctx := r.Context()
-		params, err := send.Read[RegisterUserParams](r.Body)
-		if err != nil {
-			return send.HTTPError{
-				Cause:   err,
-				Message: "bad request - invalid params for register user",
-				Status:  http.StatusBadRequest,
-			}
-		}
-
-		customerParams := &stripe.CustomerParams{
-			Name:  stripe.String("test customer"),
-			Email: stripe.String(params.Email),
-		}
-
-		result, err := customer.New(customerParams)
-		if err != nil {
-			return err
-		}
-
-		tx, err := db.BeginTx(ctx, &sql.TxOptions{
-			Isolation: sql.LevelSerializable,
-		})
-		if err != nil {
-			return err
-		}
-		defer func(tx *sql.Tx) {
-			err := tx.Rollback()
-			scope.GetLogger().Error("error transaction", slog.Any("err", err))
-		}(tx)
-
-		user := users.New(params.Email, result.ID)
-		createdUser, err := userService.CreateUser(ctx, tx, user)
-		if err != nil {
-			return err
-		}
-
-		user = createdUser
+func handleRegisterUser(keyStore idempotency.KeyStore, cfg Config, services *Services) RouteHandler {
+	return MakeIdempotentHandler(keyStore, cfg.Idempotency, registerUserRoute(services))
+}
 
-		scope.GetLogger().Info(
-			"creating stripe customer",
-			slog.Any("stripeCustomerID", user.StripeCustomerID),
-		)
+func registerUserRoute(services *Services) IdempotentRoute[RegisterUserParams] {
+	return IdempotentRoute[RegisterUserParams]{
+		Workflow: func(params RegisterUserParams) *idempotency.Workflow {
+			return idempotency.MakeWorkflow("register_user").RequireSQL().
+				Phase(idempotency.StartedRecoveryPoint, func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+					result, err := services.Gateway.CreateCustomer(ctx, payments.CustomerParams{
+						IdempotencyKey: key.PhaseKey(idempotency.StartedRecoveryPoint),
+						Name:           "test customer",
+						Email:          params.Email,
+					})
+					if err != nil {
+						return nil, classifyPaymentError(err)
+					}
 
-		err = tx.Commit()
-		if err != nil {
-			return err
-		}
+					scope.GetLogger().Info(
+						"creating stripe customer",
+						slog.Any("stripeCustomerID", result.ID),
+					)
+
+					user, err := services.Users.CreateUser(ctx, tx, users.New(params.Email, result.ID))
+					if errors.Is(err, users.ErrEmailTaken) {
+						// Retrying with the same email can't succeed, so the conflict is
+						// stored as the response of the key.
+						return nil, terminalError(send.HTTPError{
+							Cause:   err,
+							Code:    "email_taken",
+							Message: "a user with this email is already registered",
+							Status:  http.StatusConflict,
+						})
+					}
+					if err != nil {
+						return nil, err
+					}
 
-		return send.WriteJSON[*users.User](w, http.StatusCreated, user)
+					return idempotency.NewResponseResult(http.StatusCreated, user), nil
+				}, idempotency.FinishedRecoveryPoint)
+		},
 	}
 }
 
@@ -121,10 +168,16 @@ type RideReservationParams struct {
 	UserID *int              `json:"user_id"`
 	Origin *rides.Coordinate `json:"origin"`
 	Target *rides.Coordinate `json:"target"`
+	// Locale is the language of the receipt, like es or pt-BR. Optional.
+	Locale string `json:"locale,omitempty"`
+	// QuoteID is a quote from POST /quotes for the same origin and target. The ride is
+	// charged its fare instead of the current one. Optional.
+	QuoteID string `json:"quote_id,omitempty"`
 }
 
 type RideReservationResponse struct {
-	RideID int `json:"ride_id"`
+	RideID int          `json:"ride_id"`
+	Fare   pricing.Fare `json:"fare"`
 }
 
 func validateReservationParams(params RideReservationParams) error {
@@ -136,186 +189,136 @@ func validateReservationParams(params RideReservationParams) error {
 		return errors.New("must provide valid origin")
 	}
 
-	if params.Target == nil || !params.Origin.IsValid() {
+	if params.Target == nil || !params.Target.IsValid() {
 		return errors.New("must provide valid target")
 	}
 	return nil
 }
 
-func handleRideReservation(db *sql.DB, rideService rides.Service, auditService audit.Service, userService users.Service) RouteHandler {
-	return func(w http.ResponseWriter, r *http.Request) error {
-		ctx := r.Context()
+func handleRideReservation(keyStore idempotency.KeyStore, cfg Config, services *Services) RouteHandler {
+	return MakeIdempotentHandler(keyStore, cfg.Idempotency, rideReservationRoute(services))
+}
 
-		keyVal := r.Header.Get(idempotency.HeaderKey)
-		scope.GetLogger().Info("handleRideReservation", slog.String("keyVal", keyVal))
-		if !validateIdempotencyKey(keyVal) {
-			return send.HTTPError{
-				Message: "idempotency key required",
-				Status:  http.StatusBadRequest,
+func rideReservationRoute(services *Services) IdempotentRoute[RideReservationParams] {
+	return IdempotentRoute[RideReservationParams]{
+		// Only the signature of the quote is checked up front. Whether it expired is
+		// checked when the ride is created, so that the completer can still finish
+		// reservations whose quote expired since.
+		Validate: func(params RideReservationParams) error {
+			if err := validateReservationParams(params); err != nil {
+				return err
 			}
-		}
-		params, err := send.Read[RideReservationParams](r.Body)
-		if err != nil {
-			return send.HTTPError{
-				Cause:   err,
-				Message: "bad request",
-				Status:  http.StatusBadRequest,
+			if params.QuoteID != "" {
+				if _, err := services.QuoteSigner.Verify(params.QuoteID); err != nil {
+					return err
+				}
 			}
-		}
+			return nil
+		},
+		UserID: func(params RideReservationParams) int {
+			return *params.UserID
+		},
+		Workflow: func(params RideReservationParams) *idempotency.Workflow {
+			userID := *params.UserID
+			// Phases may run in a later request or in the completer, so each one loads
+			// the ride created by the started phase instead of sharing a variable.
+			return idempotency.MakeWorkflow("ride_reservation").RequireSQL().
+				Phase(idempotency.StartedRecoveryPoint, func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+					// Checkpoint 2: ride_created
+					//	Create ride
+					origin := *params.Origin
+					target := *params.Target
+					// The fare is stored on the ride so that the charge, the receipt
+					// and the response agree even if prices change in between.
+					fare := services.Calculator.Fare(origin.Point(), target.Point())
+					var quoteID sql.Null[int]
+					if params.QuoteID != "" {
+						quote, err := getBookableQuote(ctx, tx, services.QuoteSigner, services.Quotes, params.QuoteID, origin, target)
+						if err != nil {
+							return nil, err
+						}
+						fare = quote.Fare
+						quoteID = sql.Null[int]{V: quote.ID, Valid: true}
+					}
 
-		if err = validateReservationParams(params); err != nil {
-			scope.GetLogger().Error("invalid request params")
-			return send.HTTPError{
-				Cause:   err,
-				Message: "invalid request params",
-				Status:  http.StatusBadRequest,
-			}
-		}
+					ride, err := rides.New(key.ID, origin, target, fare, userID)
+					if err != nil {
+						return nil, terminalError(send.HTTPError{
+							Cause:   err,
+							Message: "bad request for ride",
+							Status:  http.StatusBadRequest,
+						})
+					}
+					ride.QuoteID = quoteID
+
+					_, err = services.Rides.CreateRide(ctx, tx, ride)
+					if errors.Is(err, rides.ErrQuoteUsed) {
+						return nil, terminalError(send.HTTPError{
+							Cause:   err,
+							Code:    "quote_used",
+							Message: "the quote was already used to book another ride",
+							Status:  http.StatusConflict,
+						})
+					}
+					if err != nil {
+						return nil, err
+					}
 
-		// if there's an idempotency key we should retrieve it and check the status.
-		// Each atomic phase will be wrapped in a transaction.
-		userID := *params.UserID
-		var key *idempotency.Key
-		// Checkpoint 1: Started
-		_, err = idempotency.AtomicPhase(ctx, key, db,
-			func(tx *sql.Tx) (idempotency.AtomicPhaseResult, error) {
-				//	Create or get key if they supplied the header
-				key, err = idempotency.FindKey(ctx, tx, userID, keyVal)
-				if err != nil {
-					if !errors.Is(err, sql.ErrNoRows) {
-						return nil, fmt.Errorf("error finding key: %w", err)
+					// Create ride audit record
+					return idempotency.NewRecoveryPointResult(idempotency.RideCreatedRecoveryPoint), nil
+				}, idempotency.RideCreatedRecoveryPoint).
+				Phase(idempotency.RideCreatedRecoveryPoint, func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+					// Checkpoint 3:
+					//	Charge user via Stripe
+					//	Create ride payment charged audit record
+					ride, err := services.Rides.GetRideByIdempotencyKey(ctx, tx, userID, key.ID)
+					if err != nil {
+						return nil, fmt.Errorf("loading ride: %w", err)
 					}
 
-					// need to marshal into binary
-					bytes, err := json.Marshal(params)
+					user, err := services.Users.GetUser(ctx, tx, userID)
 					if err != nil {
-						return nil, fmt.Errorf("marshaling params: %w", err)
+						return nil, err
 					}
 
-					var newKey *idempotency.Key
-					newKey, err = idempotency.InsertKey(ctx, tx, idempotency.KeyParams{
-						Key:           keyVal,
-						RequestMethod: idempotency.RequestMethod(r.Method),
-						RequestParams: bytes,
-						RequestPath:   r.URL.Path,
-						UserID:        userID,
+					// 	Charge user via Stripe. The phase key makes sure a phase that is
+					//	re-run after the charge went through doesn't charge again.
+					paymentIntent, err := services.Gateway.CreatePaymentIntent(ctx, payments.PaymentIntentParams{
+						IdempotencyKey: key.PhaseKey(idempotency.RideCreatedRecoveryPoint),
+						Amount:         ride.Fare.Amount,
+						Currency:       ride.Fare.Currency,
+						CustomerID:     user.StripeCustomerID,
 					})
-
 					if err != nil {
-						return nil, fmt.Errorf("failed to add new key: %w", err)
+						return nil, classifyPaymentError(err)
 					}
-					key = newKey
-				}
-
-				result := idempotency.NewRecoveryPointResult(idempotency.StartedRecoveryPoint)
-				return result, nil
-			},
-		)
-		if err != nil {
-			return fmt.Errorf("failed to upsert idempotency key: %w", err)
-		}
-
-		var ride *rides.Ride
-		var updatedKey *idempotency.Key
-		user, err := userService.GetUser(ctx, db, userID)
-		if err != nil {
-			return err
-		}
-
-		// Once we have the key, we'll continue the work and verify it is not completed.
-		for {
-			if key == nil {
-				return errors.New("nil key when executing phases")
-			}
-			switch key.RecoveryPoint {
-			case idempotency.StartedRecoveryPoint:
-				scope.GetLogger().Info("StartedRecoveryPoint")
-				updatedKey, err = idempotency.AtomicPhase(ctx, key, db,
-					func(tx *sql.Tx) (idempotency.AtomicPhaseResult, error) {
-						// Checkpoint 2: ride_created
-						//	Create ride
-						origin := *params.Origin
-						target := *params.Target
-						ride, err = rides.New(key.ID, origin, target, userID)
-						if err != nil {
-							return nil, send.HTTPError{
-								Cause:   nil,
-								Message: "bad request for ride",
-								Status:  http.StatusBadRequest,
-							}
-						}
-
-						ride, err = rideService.CreateRide(ctx, tx, ride)
-						if err != nil {
-							return nil, err
-						}
 
-						// Create ride audit record
-						return idempotency.NewRecoveryPointResult(idempotency.RideCreatedRecoveryPoint), nil
-					},
-				)
-			case idempotency.RideCreatedRecoveryPoint:
-				scope.GetLogger().Info("RideCreatedRecoveryPoint")
-				updatedKey, err = idempotency.AtomicPhase(ctx, key, db,
-					func(tx *sql.Tx) (idempotency.AtomicPhaseResult, error) {
-						// Checkpoint 3:
-						//	Charge user via Stripe
-						//	Create ride payment charged audit record
-
-						// 	Charge user via Stripe
-						paymentParams := &stripe.PaymentIntentParams{
-							Amount:       stripe.Int64(500),
-							Currency:     stripe.String(string(stripe.CurrencyUSD)),
-							Customer:     stripe.String(user.StripeCustomerID),
-							ReceiptEmail: stripe.String(user.Email),
-						}
-						paymentIntent, err := paymentintent.New(paymentParams)
-						if err != nil {
-							return nil, err
-						}
-
-						ride.StripeChargeID = sql.Null[string]{
-							V:     paymentIntent.ID,
-							Valid: true,
-						}
-						//	Update ride
-						updatedRide, err := rideService.UpdateRide(ctx, tx, ride)
-						if err != nil {
-							return nil, err
-						}
-						ride = updatedRide
-						return idempotency.NewRecoveryPointResult(idempotency.ChargeCreatedRecoveryPoint), nil
-					},
-				)
-			case idempotency.ChargeCreatedRecoveryPoint:
-				scope.GetLogger().Info("ChargeCreatedRecoveryPoint")
-				updatedKey, err = idempotency.AtomicPhase(ctx, key, db,
-					func(tx *sql.Tx) (idempotency.AtomicPhaseResult, error) {
-						// Checkpoint 4:
-						//	Stage send receipt job
-						// need to get the ride id
-						return idempotency.NewResponseResult(http.StatusCreated, map[string]any{"ride_id": ride.ID}), nil
-					},
-				)
-			case idempotency.FinishedRecoveryPoint:
-				scope.GetLogger().Info("FinishedRecoveryPoint")
-				goto loop
-			default:
-				return errors.New("unknown recovery point" + key.RecoveryPoint.String())
-			}
-
-			// should switch right here
-			if err != nil {
-				return err
-			}
-			key = updatedKey
-		}
-	loop:
-		var response RideReservationResponse
-		err = json.Unmarshal(key.ResponseBody.V, &response)
-		if err != nil {
-			return err
-		}
-		return send.WriteJSON(w, key.ResponseCode.V, response)
+					ride.StripeChargeID = sql.Null[string]{
+						V:     paymentIntent.ID,
+						Valid: true,
+					}
+					//	Update ride
+					_, err = services.Rides.UpdateRide(ctx, tx, ride)
+					if err != nil {
+						return nil, err
+					}
+					return idempotency.NewRecoveryPointResult(idempotency.ChargeCreatedRecoveryPoint), nil
+				}, idempotency.ChargeCreatedRecoveryPoint).
+				Phase(idempotency.ChargeCreatedRecoveryPoint, func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+					// Checkpoint 4:
+					//	Stage send receipt job
+					ride, err := services.Rides.GetRideByIdempotencyKey(ctx, tx, userID, key.ID)
+					if err != nil {
+						return nil, fmt.Errorf("loading ride: %w", err)
+					}
+					// The job commits with the key moving to finished, so it is staged
+					// exactly once per ride.
+					_, err = services.Jobs.StageJob(ctx, tx, SendRideReceiptJob, SendRideReceiptArgs{RideID: ride.ID, Locale: params.Locale})
+					if err != nil {
+						return nil, fmt.Errorf("staging receipt: %w", err)
+					}
+					return idempotency.NewResponseResult(http.StatusCreated, RideReservationResponse{RideID: ride.ID, Fare: ride.Fare}), nil
+				}, idempotency.FinishedRecoveryPoint)
+		},
 	}
 }
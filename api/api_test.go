@@ -4,13 +4,14 @@ import (
 	"encoding/json"
 	"github.com/anmho/idempotent-rides/api"
 	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/payments"
+	"github.com/anmho/idempotent-rides/pricing"
 	"github.com/anmho/idempotent-rides/rides"
 	"github.com/anmho/idempotent-rides/send"
 	"github.com/anmho/idempotent-rides/test"
 	"github.com/anmho/idempotent-rides/users"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/stripe/stripe-go/v79/customer"
 	"net/http"
 	"strings"
 	"testing"
@@ -20,6 +21,8 @@ const (
 	emptyIdempotencyKey = ""
 	dbIdempotencyKey    = "testKey"
 	newIdempotencyKey   = "newKey"
+	// reusedIdempotencyKey was first used with empty params by users.TestUser1
+	reusedIdempotencyKey = "testKeyFinished"
 )
 
 var (
@@ -81,6 +84,18 @@ func TestServer_handleRideReservation(t *testing.T) {
 
 			expectedStatus: http.StatusCreated,
 		},
+		{
+			desc:           "POST /rides: idempotency key reused with different params. should return 422",
+			idempotencyKey: reusedIdempotencyKey,
+			method:         http.MethodPost,
+			params: api.RideReservationParams{
+				UserID: users.TestUser1ID,
+				Origin: &rides.Coordinate{Lat: 1, Long: 2},
+				Target: &rides.Coordinate{Lat: 3, Long: 4},
+			},
+
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.desc, func(t *testing.T) {
@@ -100,14 +115,24 @@ func TestServer_handleRideReservation(t *testing.T) {
 func TestServer_handleRegisterUser(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
-		desc   string
-		params api.RegisterUserParams
+		desc           string
+		idempotencyKey string
+		params         api.RegisterUserParams
 
 		expectedStatus int
 		expectedUser   *users.User
 	}{
 		{
-			desc: "happy path: registering valid user with available email",
+			desc:           "POST /users: idempotency key is empty. should return 400 bad request",
+			idempotencyKey: emptyIdempotencyKey,
+			params: api.RegisterUserParams{
+				Email: "testuser@uiuc.edu",
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			desc:           "happy path: registering valid user with available email",
+			idempotencyKey: newIdempotencyKey,
 			params: api.RegisterUserParams{
 				Email: "testuser@uiuc.edu",
 			},
@@ -117,6 +142,14 @@ func TestServer_handleRegisterUser(t *testing.T) {
 				Email: "testuser@uiuc.edu",
 			},
 		},
+		{
+			desc:           "error path: email of another user. should return 409",
+			idempotencyKey: newIdempotencyKey,
+			params: api.RegisterUserParams{
+				Email: "awesome-user@email.com",
+			},
+			expectedStatus: http.StatusConflict,
+		},
 	}
 
 	for _, tc := range tests {
@@ -126,22 +159,104 @@ func TestServer_handleRegisterUser(t *testing.T) {
 			body := strings.NewReader(string(must(json.Marshal(tc.params))))
 			client := srv.Client()
 			req := must(http.NewRequest(http.MethodPost, srv.URL+"/users", body))
+			req.Header.Set(idempotency.HeaderKey, tc.idempotencyKey)
 
 			resp := must(client.Do(req))
 			require.NotNil(t, resp)
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedUser == nil {
+				return
+			}
 			user, err := send.Read[*users.User](resp.Body)
 			require.NoError(t, err)
 			assert.NotNil(t, user)
 
 			assert.Equal(t, tc.expectedUser.Email, user.Email)
+			assert.Equal(t, "cus_fake_1", user.StripeCustomerID)
+		})
+	}
+}
+
+func TestServer_handleRideReservation_payment(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc   string
+		script func(g *payments.FakeGateway)
+		// retries is how many times the request is retried with the same key.
+		retries int
+
+		expectedStatus  int
+		expectedIntents int
+	}{
+		{
+			desc: "happy path: ride is charged once",
+
+			expectedStatus:  http.StatusCreated,
+			expectedIntents: 1,
+		},
+		{
+			desc: "error path: declined card. should return 402",
+			script: func(g *payments.FakeGateway) {
+				g.DeclineNext("card_declined")
+			},
+
+			expectedStatus:  http.StatusPaymentRequired,
+			expectedIntents: 0,
+		},
+		{
+			desc: "happy path: charge whose response was lost isn't made again on retry",
+			script: func(g *payments.FakeGateway) {
+				g.TimeoutNext(payments.OpCreatePaymentIntent)
+			},
+			retries: 1,
+
+			expectedStatus:  http.StatusCreated,
+			expectedIntents: 1,
+		},
+		{
+			desc: "error path: gateway timeout. should return 503",
+			script: func(g *payments.FakeGateway) {
+				g.FailNext(payments.OpCreatePaymentIntent, payments.ErrTimeout)
+			},
+
+			expectedStatus:  http.StatusServiceUnavailable,
+			expectedIntents: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			gateway := payments.MakeFakeGateway()
+			if tc.script != nil {
+				tc.script(gateway)
+			}
+			srv := test.MakeTestServerWithGateway(t, api.Config{}, gateway)
+
+			origin := rides.Coordinate{Lat: 37.7749, Long: -122.4194}
+			target := rides.Coordinate{Lat: 37.8044, Long: -122.2712}
+			params := api.RideReservationParams{
+				UserID: &JoshTestUser.ID,
+				Origin: &origin,
+				Target: &target,
+			}
+			fare := must(pricing.MakeCalculator(pricing.Config{})).Fare(origin.Point(), target.Point())
+			var resp *http.Response
+			for range tc.retries + 1 {
+				body := strings.NewReader(string(must(json.Marshal(params))))
+				req := must(http.NewRequest(http.MethodPost, srv.URL+"/rides", body))
+				req.Header.Set(idempotency.HeaderKey, newIdempotencyKey)
+				resp = must(srv.Client().Do(req))
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
 
-			t.Cleanup(func() {
-				_, err := customer.Del(
-					user.StripeCustomerID, nil,
-				)
-				assert.NoError(t, err)
-			})
+			intents := gateway.PaymentIntents()
+			require.Len(t, intents, tc.expectedIntents)
+			for _, intent := range intents {
+				assert.Equal(t, JoshTestUser.StripeCustomerID, intent.CustomerID)
+				assert.Equal(t, fare.Amount, intent.Amount)
+				assert.Equal(t, fare.Currency, intent.Currency)
+			}
 		})
 	}
 }
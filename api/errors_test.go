@@ -0,0 +1,82 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/payments"
+	"github.com/anmho/idempotent-rides/send"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+)
+
+func Test_classifyPaymentError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc string
+		err  error
+
+		expectedTerminal bool
+		expectedStatus   int
+	}{
+		{
+			desc: "card declined is terminal and replayed as 402",
+			err: &payments.CardError{
+				Code:    "card_declined",
+				Message: "Your card was declined.",
+			},
+
+			expectedTerminal: true,
+			expectedStatus:   http.StatusPaymentRequired,
+		},
+		{
+			desc: "invalid request is terminal and replayed as 502",
+			err: &payments.InvalidRequestError{
+				Code:    "resource_missing",
+				Message: "No such customer: 'cus_123'",
+			},
+
+			expectedTerminal: true,
+			expectedStatus:   http.StatusBadGateway,
+		},
+		{
+			desc: "idempotency key conflict is terminal and replayed as 500",
+			err:  fmt.Errorf("%w: Keys for idempotent requests can only be used with the same parameters they were first used with.", payments.ErrKeyReused),
+
+			expectedTerminal: true,
+			expectedStatus:   http.StatusInternalServerError,
+		},
+		{
+			desc: "gateway timeout is retryable",
+			err:  payments.ErrTimeout,
+		},
+		{
+			desc: "network timeout is retryable",
+			err:  errors.New("net/http: request canceled (Client.Timeout exceeded while awaiting headers)"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := classifyPaymentError(tc.err)
+			assert.ErrorIs(t, err, tc.err)
+
+			var terminalErr *idempotency.TerminalError
+			if tc.expectedTerminal {
+				require.ErrorAs(t, err, &terminalErr)
+				assert.Equal(t, tc.expectedStatus, terminalErr.Status)
+
+				body, ok := terminalErr.Body.(send.HTTPError)
+				require.True(t, ok)
+				assert.Nil(t, body.Cause, "cause must not be stored in the response")
+			} else {
+				assert.False(t, errors.As(err, &terminalErr))
+				var retryableErr *idempotency.RetryableError
+				assert.ErrorAs(t, err, &retryableErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/scope"
+	"github.com/anmho/idempotent-rides/send"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// IdempotentRoute describes a mutating route whose work is split into atomic phases
+// and guarded by the Idempotency-Key header.
+type IdempotentRoute[T any] struct {
+	// FromPath copies the path values of the request, like the ID of a ride, into
+	// params. They are stored with the rest of the params, so the completer sees
+	// them too. Optional.
+	FromPath func(r *http.Request, params *T) error
+	// Validate rejects bad params before a key is created. Optional.
+	Validate func(params T) error
+	// UserID scopes the key to the user making the request. Routes that are not made
+	// on behalf of a user, like registration, may leave it nil.
+	UserID func(params T) int
+	// Workflow returns the atomic phases that complete the request for params.
+	Workflow func(params T) *idempotency.Workflow
+}
+
+// WorkflowFromParams rebuilds the workflow for params stored on a key. It lets the
+// completer resume a request exactly as a client retry would.
+func (route IdempotentRoute[T]) WorkflowFromParams(bytes []byte) (*idempotency.Workflow, error) {
+	var params T
+	if err := json.Unmarshal(bytes, &params); err != nil {
+		return nil, fmt.Errorf("decoding params: %w", err)
+	}
+
+	if route.Validate != nil {
+		if err := route.Validate(params); err != nil {
+			return nil, fmt.Errorf("invalid params: %w", err)
+		}
+	}
+	return route.Workflow(params), nil
+}
+
+// MakeIdempotentHandler wraps route in the idempotency key lifecycle: it validates the
+// header, upserts and locks the key, runs the rest of the workflow and writes the stored
+// response byte-for-byte. Responses of keys that were already finished carry the
+// Idempotent-Replayed header.
+func MakeIdempotentHandler[T any](keyStore idempotency.KeyStore, cfg idempotency.Config, route IdempotentRoute[T]) RouteHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		ctx := r.Context()
+
+		keyVal := r.Header.Get(idempotency.HeaderKey)
+		scope.GetLogger().Info("idempotent request",
+			slog.String("path", r.URL.Path),
+			slog.String("keyVal", keyVal),
+		)
+		if !validateIdempotencyKey(keyVal) {
+			return send.HTTPError{
+				Message: "idempotency key required",
+				Status:  http.StatusBadRequest,
+			}
+		}
+
+		params, err := send.Read[T](r.Body)
+		if err != nil {
+			return send.HTTPError{
+				Cause:   err,
+				Message: "bad request",
+				Status:  http.StatusBadRequest,
+			}
+		}
+
+		if route.FromPath != nil {
+			if err = route.FromPath(r, &params); err != nil {
+				return send.HTTPError{
+					Cause:   err,
+					Message: "bad request path",
+					Status:  http.StatusBadRequest,
+				}
+			}
+		}
+
+		if route.Validate != nil {
+			if err = route.Validate(params); err != nil {
+				return send.HTTPError{
+					Cause:   err,
+					Message: "invalid request params",
+					Status:  http.StatusBadRequest,
+				}
+			}
+		}
+
+		bytes, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("marshaling params: %w", err)
+		}
+
+		var userID int
+		if route.UserID != nil {
+			userID = route.UserID(params)
+		}
+
+		key, replayed, err := idempotency.Handle(ctx, keyStore, cfg, idempotency.KeyParams{
+			Key:           keyVal,
+			RequestMethod: idempotency.RequestMethod(r.Method),
+			RequestParams: bytes,
+			RequestPath:   r.URL.Path,
+			UserID:        userID,
+		}, route.Workflow(params))
+		if errors.Is(err, idempotency.ErrKeyReused) {
+			return send.HTTPError{
+				Cause:   err,
+				Code:    "idempotency_key_reused",
+				Message: "keys for idempotent requests can only be used with the same method, path and parameters they were first used with",
+				Status:  http.StatusUnprocessableEntity,
+			}
+		}
+		var lockedErr *idempotency.LockedError
+		if errors.As(err, &lockedErr) {
+			retryAfter := int(math.Ceil(lockedErr.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			return send.HTTPError{
+				Cause:   err,
+				Code:    "idempotency_key_locked",
+				Message: "a request with the same idempotency key is already in progress",
+				Status:  http.StatusConflict,
+			}
+		}
+		if errors.Is(err, idempotency.ErrLockLost) {
+			return send.HTTPError{
+				Cause:   err,
+				Code:    "idempotency_key_locked",
+				Message: "a request with the same idempotency key is already in progress",
+				Status:  http.StatusConflict,
+			}
+		}
+		if errors.Is(err, idempotency.ErrMaxAttempts) {
+			return send.HTTPError{
+				Cause:   err,
+				Code:    "idempotency_key_max_attempts",
+				Message: "the request has been retried too many times with this idempotency key and won't be retried again",
+				Status:  http.StatusUnprocessableEntity,
+			}
+		}
+		var retryableErr *idempotency.RetryableError
+		if errors.As(err, &retryableErr) {
+			return send.HTTPError{
+				Cause:   err,
+				Code:    "retryable_error",
+				Message: "the request failed temporarily and can be retried with the same idempotency key",
+				Status:  http.StatusServiceUnavailable,
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if replayed {
+			w.Header().Set(idempotency.HeaderReplayed, "true")
+		}
+		return send.WriteRaw(w, key.ResponseCode.V, http.Header(key.ResponseHeaders), key.ResponseBody.V)
+	}
+}
+
+// terminalError fails a phase with httpErr as the stored response of its key, which is
+// replayed on every retry. The cause is logged but not stored.
+func terminalError(httpErr send.HTTPError) *idempotency.TerminalError {
+	cause := httpErr.Cause
+	httpErr.Cause = nil
+	if httpErr.Status == 0 {
+		httpErr.Status = http.StatusBadRequest
+	}
+	return idempotency.NewTerminalError(httpErr.Status, httpErr, cause)
+}
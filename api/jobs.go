@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/anmho/idempotent-rides/emails"
+	"github.com/anmho/idempotent-rides/jobs"
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/anmho/idempotent-rides/rides"
+	"github.com/anmho/idempotent-rides/users"
+)
+
+const (
+	SendRideReceiptJob = "send_ride_receipt"
+)
+
+const (
+	// RideReceiptTemplate is the email template of ride receipts. Its data is a
+	// RideReceiptData.
+	RideReceiptTemplate = "ride_receipt"
+)
+
+type SendRideReceiptArgs struct {
+	RideID int `json:"ride_id"`
+	// Locale picks the language of the receipt. Empty means emails.DefaultLocale.
+	Locale string `json:"locale,omitempty"`
+}
+
+// RideReceiptData is what the ride receipt template is rendered with.
+type RideReceiptData struct {
+	RideID int
+	Origin rides.Coordinate
+	Target rides.Coordinate
+	// Amount is the formatted fare of the ride, like "5.00 USD".
+	Amount string
+}
+
+// MakeJobRegistry returns the handlers of every job staged by MakeServer.
+func MakeJobRegistry(db *sql.DB, mailer *emails.Mailer) *jobs.Registry {
+	registry := jobs.MakeRegistry()
+	jobs.Register(registry, SendRideReceiptJob, handleSendRideReceipt(db, rides.MakeService(), users.MakeService(), mailer))
+	return registry
+}
+
+// handleSendRideReceipt emails the receipt of a ride to the user who took it. The
+// receipt is deduped by ride, so it isn't sent again when the job is retried after it
+// went out.
+func handleSendRideReceipt(db *sql.DB, rideService rides.Service, userService users.Service, mailer *emails.Mailer) func(ctx context.Context, args SendRideReceiptArgs) error {
+	return func(ctx context.Context, args SendRideReceiptArgs) error {
+		ride, err := getRide(ctx, db, rideService, args.RideID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return jobs.Permanent(fmt.Errorf("ride %d not found: %w", args.RideID, err))
+		}
+		if err != nil {
+			return fmt.Errorf("loading ride: %w", err)
+		}
+
+		user, err := userService.GetUser(ctx, db, ride.UserID)
+		if err != nil {
+			return fmt.Errorf("loading user: %w", err)
+		}
+
+		_, err = mailer.Send(ctx, emails.SendParams{
+			UserID:   user.ID,
+			To:       user.Email,
+			Template: RideReceiptTemplate,
+			Locale:   args.Locale,
+			Data: RideReceiptData{
+				RideID: ride.ID,
+				Origin: ride.Origin,
+				Target: ride.Target,
+				Amount: pricing.FormatAmount(ride.Fare.Amount, ride.Fare.Currency),
+			},
+			DedupeKey: fmt.Sprintf("%s:%d", RideReceiptTemplate, ride.ID),
+		})
+		if emails.IsPermanent(err) {
+			return jobs.Permanent(err)
+		}
+		return err
+	}
+}
+
+func getRide(ctx context.Context, db *sql.DB, rideService rides.Service, rideID int) (*rides.Ride, error) {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ride, err := rideService.GetRide(ctx, tx, rideID)
+	if err != nil {
+		return nil, err
+	}
+	return ride, tx.Commit()
+}
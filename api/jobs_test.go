@@ -0,0 +1,122 @@
+package api_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/anmho/idempotent-rides/api"
+	"github.com/anmho/idempotent-rides/emails"
+	"github.com/anmho/idempotent-rides/jobs"
+	"github.com/anmho/idempotent-rides/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+// recordingEmailService records the messages it is asked to send.
+type recordingEmailService struct {
+	mu       sync.Mutex
+	messages []*emails.Message
+}
+
+func (s *recordingEmailService) Send(ctx context.Context, msg *emails.Message) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	return fmt.Sprintf("<%d@test>", len(s.messages)), nil
+}
+
+func TestSendRideReceiptJob(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc   string
+		rideID int
+
+		expectedMessages int
+		expectedDead     bool
+	}{
+		{
+			desc: "happy path: receipt is sent to the user who took the ride",
+			// seeded ride 123 belongs to user 123
+			rideID: 123,
+
+			expectedMessages: 1,
+		},
+		{
+			desc:   "error path: ride that doesn't exist is dead-lettered",
+			rideID: 9999,
+
+			expectedMessages: 0,
+			expectedDead:     true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			db := test.MakePostgres(t)
+			ctx := context.Background()
+			emailService := &recordingEmailService{}
+			templates, err := emails.LoadTemplates()
+			require.NoError(t, err)
+			mailer := emails.MakeMailer(db, emailService, templates)
+
+			tx := test.MakeTx(t, ctx, db)
+			_, err = jobs.MakeService().StageJob(ctx, tx, api.SendRideReceiptJob, api.SendRideReceiptArgs{RideID: tc.rideID})
+			require.NoError(t, err)
+			require.NoError(t, tx.Commit())
+
+			_, err = jobs.MakeEnqueuer(db, jobs.EnqueuerConfig{}).EnqueueOnce(ctx)
+			require.NoError(t, err)
+			n, err := jobs.MakeWorker(db, api.MakeJobRegistry(db, mailer), jobs.WorkerConfig{}).WorkOnce(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+
+			require.Len(t, emailService.messages, tc.expectedMessages)
+			if tc.expectedMessages > 0 {
+				msg := emailService.messages[0]
+				assert.Equal(t, "awesome-user@email.com", msg.To)
+				assert.Contains(t, msg.Subject, "#123")
+				assert.Contains(t, msg.Text, "5.00 USD")
+			}
+
+			var sent int
+			require.NoError(t, db.QueryRow("SELECT count(*) FROM email_messages WHERE user_id = 123 AND status = 'sent'").Scan(&sent))
+			assert.Equal(t, tc.expectedMessages, sent)
+
+			var dead int
+			require.NoError(t, db.QueryRow("SELECT count(*) FROM dead_jobs").Scan(&dead))
+			assert.Equal(t, tc.expectedDead, dead == 1)
+		})
+	}
+}
+
+func TestSendRideReceiptJob_Dedupe(t *testing.T) {
+	t.Parallel()
+
+	db := test.MakePostgres(t)
+	ctx := context.Background()
+	emailService := &recordingEmailService{}
+	templates, err := emails.LoadTemplates()
+	require.NoError(t, err)
+	worker := jobs.MakeWorker(db, api.MakeJobRegistry(db, emails.MakeMailer(db, emailService, templates)), jobs.WorkerConfig{})
+
+	// The receipt of a ride is only sent once, even when the job runs again, like
+	// after a worker lost its lease.
+	for range 2 {
+		tx := test.MakeTx(t, ctx, db)
+		_, err = jobs.MakeService().StageJob(ctx, tx, api.SendRideReceiptJob, api.SendRideReceiptArgs{RideID: 123})
+		require.NoError(t, err)
+		require.NoError(t, tx.Commit())
+
+		_, err = jobs.MakeEnqueuer(db, jobs.EnqueuerConfig{}).EnqueueOnce(ctx)
+		require.NoError(t, err)
+		_, err = worker.WorkOnce(ctx)
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, emailService.messages, 1)
+	var attempts int
+	require.NoError(t, db.QueryRow("SELECT attempts FROM email_messages WHERE dedupe_key = 'ride_receipt:123'").Scan(&attempts))
+	assert.Equal(t, 1, attempts)
+}
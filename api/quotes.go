@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/anmho/idempotent-rides/quotes"
+	"github.com/anmho/idempotent-rides/rides"
+	"github.com/anmho/idempotent-rides/send"
+	"net/http"
+	"time"
+)
+
+type QuoteParams struct {
+	Origin *rides.Coordinate `json:"origin"`
+	Target *rides.Coordinate `json:"target"`
+}
+
+// QuoteResponse is a quote as shown to riders. Reserving a ride with its QuoteID
+// before ExpiresAt charges exactly its Fare.
+type QuoteResponse struct {
+	QuoteID   string           `json:"quote_id"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	Origin    rides.Coordinate `json:"origin"`
+	Target    rides.Coordinate `json:"target"`
+	Fare      pricing.Fare     `json:"fare"`
+}
+
+func validateQuoteParams(params QuoteParams) error {
+	if params.Origin == nil || !params.Origin.IsValid() {
+		return errors.New("must provide valid origin")
+	}
+
+	if params.Target == nil || !params.Target.IsValid() {
+		return errors.New("must provide valid target")
+	}
+	return nil
+}
+
+// handleCreateQuote prices a ride without booking it. Quotes cost nothing to make, so
+// the route isn't idempotent: a retry gets a new quote for the same fare.
+func handleCreateQuote(db *sql.DB, cfg Config, services *Services) RouteHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		params, err := send.Read[QuoteParams](r.Body)
+		if err != nil {
+			return send.HTTPError{
+				Cause:   err,
+				Message: "bad request",
+				Status:  http.StatusBadRequest,
+			}
+		}
+		if err = validateQuoteParams(params); err != nil {
+			return send.HTTPError{
+				Cause:   err,
+				Message: "invalid request params",
+				Status:  http.StatusBadRequest,
+			}
+		}
+
+		origin := *params.Origin
+		target := *params.Target
+		fare := services.Calculator.Fare(origin.Point(), target.Point())
+		quote, err := services.Quotes.CreateQuote(r.Context(), db, quotes.New(origin, target, fare, cfg.Quotes.TTL))
+		if err != nil {
+			return err
+		}
+
+		return send.WriteJSON(w, http.StatusCreated, QuoteResponse{
+			QuoteID:   services.QuoteSigner.Sign(quote.ID),
+			ExpiresAt: quote.ExpiresAt,
+			Origin:    quote.Origin,
+			Target:    quote.Target,
+			Fare:      quote.Fare,
+		})
+	}
+}
+
+// getBookableQuote returns the quote with the client ID quoteID if a ride from origin
+// to target can be booked with it. Otherwise it fails with a terminal error.
+func getBookableQuote(ctx context.Context, tx *sql.Tx, quoteSigner *quotes.Signer, quoteService quotes.Service, quoteID string, origin, target rides.Coordinate) (*quotes.Quote, error) {
+	id, err := quoteSigner.Verify(quoteID)
+	if err != nil {
+		return nil, terminalError(send.HTTPError{
+			Cause:   err,
+			Code:    "invalid_quote",
+			Message: "the quote doesn't exist",
+			Status:  http.StatusBadRequest,
+		})
+	}
+
+	quote, err := quoteService.GetQuote(ctx, tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, terminalError(send.HTTPError{
+			Cause:   err,
+			Code:    "invalid_quote",
+			Message: "the quote doesn't exist",
+			Status:  http.StatusBadRequest,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading quote: %w", err)
+	}
+
+	if quote.IsExpired(time.Now()) {
+		return nil, terminalError(send.HTTPError{
+			Code:    "quote_expired",
+			Message: "the quote has expired, ask for a new one",
+			Status:  http.StatusUnprocessableEntity,
+		})
+	}
+	if !quote.Matches(origin, target) {
+		return nil, terminalError(send.HTTPError{
+			Code:    "quote_mismatch",
+			Message: "the quote is for a ride between other coordinates",
+			Status:  http.StatusUnprocessableEntity,
+		})
+	}
+	return quote, nil
+}
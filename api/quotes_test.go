@@ -0,0 +1,202 @@
+package api_test
+
+import (
+	"encoding/json"
+	"github.com/anmho/idempotent-rides/api"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/payments"
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/anmho/idempotent-rides/quotes"
+	"github.com/anmho/idempotent-rides/rides"
+	"github.com/anmho/idempotent-rides/send"
+	"github.com/anmho/idempotent-rides/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testQuoteSigningKey = "test-quote-signing-key"
+	// bookableQuoteID and expiredQuoteID are seeded quotes from (0, 0) to (0, 1).
+	bookableQuoteID = 900
+	expiredQuoteID  = 901
+	// quotedFareAmount is the seeded fare of both quotes.
+	quotedFareAmount = 16929
+)
+
+var (
+	quoteOrigin = rides.Coordinate{Lat: 0, Long: 0}
+	quoteTarget = rides.Coordinate{Lat: 0, Long: 1}
+)
+
+func TestServer_handleCreateQuote(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc       string
+		signingKey string
+		params     api.QuoteParams
+
+		expectedStatus int
+	}{
+		{
+			desc:       "happy path: quote is created",
+			signingKey: testQuoteSigningKey,
+			params: api.QuoteParams{
+				Origin: &rides.Coordinate{Lat: 37.7749, Long: -122.4194},
+				Target: &rides.Coordinate{Lat: 37.8044, Long: -122.2712},
+			},
+
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			desc:       "error path: invalid target. should return 400",
+			signingKey: testQuoteSigningKey,
+			params: api.QuoteParams{
+				Origin: &rides.Coordinate{Lat: 37.7749, Long: -122.4194},
+				Target: &rides.Coordinate{Lat: 91, Long: 0},
+			},
+
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			desc: "error path: quotes are disabled without a signing key. should return 404",
+			params: api.QuoteParams{
+				Origin: &rides.Coordinate{Lat: 37.7749, Long: -122.4194},
+				Target: &rides.Coordinate{Lat: 37.8044, Long: -122.2712},
+			},
+
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			srv := test.MakeTestServerWithConfig(t, api.Config{
+				Quotes: quotes.Config{SigningKey: tc.signingKey},
+			})
+
+			body := strings.NewReader(string(must(json.Marshal(tc.params))))
+			resp := must(srv.Client().Post(srv.URL+"/quotes", "application/json", body))
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedStatus != http.StatusCreated {
+				return
+			}
+
+			quote, err := send.Read[api.QuoteResponse](resp.Body)
+			require.NoError(t, err)
+			expectedFare := must(pricing.MakeCalculator(pricing.Config{})).Fare(tc.params.Origin.Point(), tc.params.Target.Point())
+			assert.Equal(t, expectedFare, quote.Fare)
+			assert.WithinDuration(t, time.Now().Add(quotes.DefaultTTL), quote.ExpiresAt, time.Minute)
+			_, err = quotes.MakeSigner(testQuoteSigningKey).Verify(quote.QuoteID)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestServer_handleRideReservation_quote(t *testing.T) {
+	t.Parallel()
+
+	signer := quotes.MakeSigner(testQuoteSigningKey)
+	otherTarget := rides.Coordinate{Lat: 0, Long: 2}
+
+	tests := []struct {
+		desc    string
+		quoteID string
+		target  rides.Coordinate
+		// reservations is how many rides are booked with the quote, each with its own
+		// idempotency key. The last response is checked.
+		reservations int
+
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			desc:         "happy path: ride is charged the quoted fare",
+			quoteID:      signer.Sign(bookableQuoteID),
+			target:       quoteTarget,
+			reservations: 1,
+
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			desc:         "error path: quote has expired. should return 422",
+			quoteID:      signer.Sign(expiredQuoteID),
+			target:       quoteTarget,
+			reservations: 1,
+
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   "quote_expired",
+		},
+		{
+			desc:         "error path: coordinates don't match the quote. should return 422",
+			quoteID:      signer.Sign(bookableQuoteID),
+			target:       otherTarget,
+			reservations: 1,
+
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   "quote_mismatch",
+		},
+		{
+			desc:         "error path: quote was already used. should return 409",
+			quoteID:      signer.Sign(bookableQuoteID),
+			target:       quoteTarget,
+			reservations: 2,
+
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "quote_used",
+		},
+		{
+			desc:         "error path: quote id isn't signed. should return 400",
+			quoteID:      quotes.MakeSigner("other-key").Sign(bookableQuoteID),
+			target:       quoteTarget,
+			reservations: 1,
+
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			gateway := payments.MakeFakeGateway()
+			srv := test.MakeTestServerWithGateway(t, api.Config{
+				Quotes: quotes.Config{SigningKey: testQuoteSigningKey},
+			}, gateway)
+
+			params := api.RideReservationParams{
+				UserID:  &JoshTestUser.ID,
+				Origin:  &quoteOrigin,
+				Target:  &tc.target,
+				QuoteID: tc.quoteID,
+			}
+			var resp *http.Response
+			for i := range tc.reservations {
+				body := strings.NewReader(string(must(json.Marshal(params))))
+				req := must(http.NewRequest(http.MethodPost, srv.URL+"/rides", body))
+				req.Header.Set(idempotency.HeaderKey, strings.Repeat("k", i+2))
+				resp = must(srv.Client().Do(req))
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedCode != "" {
+				httpErr, err := send.Read[send.HTTPError](resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			}
+			if tc.expectedStatus != http.StatusCreated {
+				return
+			}
+
+			ride, err := send.Read[api.RideReservationResponse](resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, int64(quotedFareAmount), ride.Fare.Amount)
+
+			intents := gateway.PaymentIntents()
+			require.Len(t, intents, tc.reservations)
+			assert.Equal(t, int64(quotedFareAmount), intents[0].Amount)
+		})
+	}
+}
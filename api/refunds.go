@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	audit "github.com/anmho/idempotent-rides/audit"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/payments"
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/anmho/idempotent-rides/refunds"
+	"github.com/anmho/idempotent-rides/send"
+	"net/http"
+	"strconv"
+)
+
+const (
+	auditResourceRefund     = "refund"
+	auditActionRequested    = "requested"
+	auditActionRefunded     = "refunded"
+	auditActionRefundFailed = "failed"
+)
+
+// refundCreatedRecoveryPoint is reached once the refund was recorded, before it is
+// sent to the processor.
+const refundCreatedRecoveryPoint idempotency.RecoveryPointEnum = "refund_created"
+
+type RideRefundParams struct {
+	// RideID is taken from the path.
+	RideID int  `json:"ride_id"`
+	UserID *int `json:"user_id"`
+	// Amount is the part of the fare to refund, in its smallest currency unit. Zero
+	// refunds whatever wasn't refunded yet.
+	Amount int64 `json:"amount,omitempty"`
+	// Reason is duplicate, fraudulent or requested_by_customer. Optional.
+	Reason string `json:"reason,omitempty"`
+}
+
+type RideRefundResponse struct {
+	RefundID int    `json:"refund_id"`
+	RideID   int    `json:"ride_id"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+	Status   string `json:"status"`
+}
+
+func newRideRefundResponse(refund *refunds.Refund) RideRefundResponse {
+	return RideRefundResponse{
+		RefundID: refund.ID,
+		RideID:   refund.RideID,
+		Amount:   refund.Amount,
+		Currency: refund.Currency,
+		Status:   string(refund.Status),
+	}
+}
+
+func validateRefundParams(params RideRefundParams) error {
+	if params.UserID == nil {
+		return errors.New("must provide valid userID")
+	}
+
+	if params.Amount < 0 {
+		return errors.New("amount can't be negative")
+	}
+
+	if !refunds.IsValidReason(params.Reason) {
+		return errors.New("reason must be duplicate, fraudulent or requested_by_customer")
+	}
+	return nil
+}
+
+func handleRideRefund(keyStore idempotency.KeyStore, cfg Config, services *Services) RouteHandler {
+	handler := MakeIdempotentHandler(keyStore, cfg.Idempotency, rideRefundRoute(services))
+	return func(w http.ResponseWriter, r *http.Request) error {
+		// The phases write audit records, which need to know where the request
+		// came from.
+		return handler(w, r.WithContext(audit.WithOriginIP(r.Context(), originIP(r))))
+	}
+}
+
+func rideRefundRoute(services *Services) IdempotentRoute[RideRefundParams] {
+	return IdempotentRoute[RideRefundParams]{
+		FromPath: func(r *http.Request, params *RideRefundParams) error {
+			rideID, err := strconv.Atoi(r.PathValue("id"))
+			if err != nil {
+				return fmt.Errorf("invalid ride id: %w", err)
+			}
+			params.RideID = rideID
+			return nil
+		},
+		Validate: validateRefundParams,
+		UserID: func(params RideRefundParams) int {
+			return *params.UserID
+		},
+		Workflow: func(params RideRefundParams) *idempotency.Workflow {
+			userID := *params.UserID
+			return idempotency.MakeWorkflow("ride_refund").RequireSQL().
+				Phase(idempotency.StartedRecoveryPoint, func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+					// Checkpoint 2: refund_created
+					//	Create refund for what is left of the fare
+					//	Create refund requested audit record
+					ride, err := services.Rides.GetRide(ctx, tx, params.RideID)
+					if errors.Is(err, sql.ErrNoRows) || (err == nil && ride.UserID != userID) {
+						return nil, terminalError(send.HTTPError{
+							Cause:   err,
+							Code:    "ride_not_found",
+							Message: "ride not found",
+							Status:  http.StatusNotFound,
+						})
+					}
+					if err != nil {
+						return nil, fmt.Errorf("loading ride: %w", err)
+					}
+					if !ride.StripeChargeID.Valid {
+						return nil, terminalError(send.HTTPError{
+							Code:    "ride_not_charged",
+							Message: "the ride wasn't charged yet, so there is nothing to refund",
+							Status:  http.StatusConflict,
+						})
+					}
+
+					// The refunds of a ride are summed in the serializable transaction
+					// of the phase, so concurrent refunds can't exceed the fare together.
+					refunded, err := services.Refunds.GetRefundedAmount(ctx, tx, ride.ID)
+					if err != nil {
+						return nil, err
+					}
+					remaining := ride.Fare.Amount - refunded
+					amount := params.Amount
+					if amount == 0 {
+						amount = remaining
+					}
+					if amount <= 0 || amount > remaining {
+						return nil, terminalError(send.HTTPError{
+							Code:    "refund_exceeds_charge",
+							Message: fmt.Sprintf("only %s of the ride can still be refunded", pricing.FormatAmount(remaining, ride.Fare.Currency)),
+							Status:  http.StatusUnprocessableEntity,
+						})
+					}
+
+					refund, err := refunds.New(key.ID, ride.ID, userID, amount, ride.Fare.Currency, params.Reason)
+					if err != nil {
+						return nil, terminalError(send.HTTPError{
+							Cause:   err,
+							Message: "bad request for refund",
+							Status:  http.StatusBadRequest,
+						})
+					}
+					refund, err = services.Refunds.CreateRefund(ctx, tx, refund)
+					if err != nil {
+						return nil, err
+					}
+
+					if err = createRefundAuditRecord(ctx, tx, services.Audit, auditActionRequested, refund); err != nil {
+						return nil, err
+					}
+					return idempotency.NewRecoveryPointResult(refundCreatedRecoveryPoint), nil
+				}, refundCreatedRecoveryPoint).
+				Phase(refundCreatedRecoveryPoint, func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+					// Checkpoint 3:
+					//	Refund the charge via Stripe
+					//	Create refunded audit record
+					refund, err := services.Refunds.GetRefundByIdempotencyKey(ctx, tx, params.RideID, key.ID)
+					if err != nil {
+						return nil, fmt.Errorf("loading refund: %w", err)
+					}
+					ride, err := services.Rides.GetRide(ctx, tx, refund.RideID)
+					if err != nil {
+						return nil, fmt.Errorf("loading ride: %w", err)
+					}
+
+					// The phase key makes sure a phase that is re-run after the refund
+					// went through doesn't refund again.
+					result, err := services.Gateway.CreateRefund(ctx, payments.RefundParams{
+						IdempotencyKey:  key.PhaseKey(refundCreatedRecoveryPoint),
+						PaymentIntentID: ride.StripeChargeID.V,
+						Amount:          refund.Amount,
+						Reason:          refund.Reason.V,
+					})
+					// Refunds the processor refused are kept as failed rather than
+					// rolled back so that support can see them, and they no longer count
+					// as refunded. Only errors that may go away are retried.
+					var cardErr *payments.CardError
+					if errors.As(err, &cardErr) {
+						return failRefund(ctx, tx, services.Refunds, services.Audit, refund, send.HTTPError{
+							Code:    cardErr.Code,
+							Message: cardErr.Message,
+							Status:  http.StatusPaymentRequired,
+						})
+					}
+					var invalidRequestErr *payments.InvalidRequestError
+					if errors.As(err, &invalidRequestErr) {
+						return failRefund(ctx, tx, services.Refunds, services.Audit, refund, send.HTTPError{
+							Code:    "refund_rejected",
+							Message: "the payment processor rejected the refund",
+							Status:  http.StatusUnprocessableEntity,
+						})
+					}
+					if err != nil {
+						return nil, idempotency.Retryable(err)
+					}
+
+					refund.Status = refunds.StatusSucceeded
+					refund.StripeRefundID = sql.Null[string]{
+						V:     result.ID,
+						Valid: true,
+					}
+					refund, err = services.Refunds.UpdateRefund(ctx, tx, refund)
+					if err != nil {
+						return nil, err
+					}
+					if err = createRefundAuditRecord(ctx, tx, services.Audit, auditActionRefunded, refund); err != nil {
+						return nil, err
+					}
+					return idempotency.NewResponseResult(http.StatusCreated, newRideRefundResponse(refund)), nil
+				}, idempotency.FinishedRecoveryPoint)
+		},
+	}
+}
+
+// failRefund marks refund as failed and finishes the request with httpErr as its
+// response.
+func failRefund(ctx context.Context, tx *sql.Tx, refundService refunds.Service, auditService audit.Service, refund *refunds.Refund, httpErr send.HTTPError) (idempotency.AtomicPhaseResult, error) {
+	refund.Status = refunds.StatusFailed
+	refund, err := refundService.UpdateRefund(ctx, tx, refund)
+	if err != nil {
+		return nil, err
+	}
+	if err = createRefundAuditRecord(ctx, tx, auditService, auditActionRefundFailed, refund); err != nil {
+		return nil, err
+	}
+	return idempotency.NewResponseResult(httpErr.Status, httpErr), nil
+}
+
+func createRefundAuditRecord(ctx context.Context, tx *sql.Tx, auditService audit.Service, action string, refund *refunds.Refund) error {
+	data, err := json.Marshal(map[string]any{
+		"ride_id":          refund.RideID,
+		"amount":           refund.Amount,
+		"currency":         refund.Currency,
+		"reason":           refund.Reason.V,
+		"status":           refund.Status,
+		"stripe_refund_id": refund.StripeRefundID.V,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = auditService.CreateRecord(ctx, tx, audit.NewRecord(
+		action, data, audit.OriginIP(ctx),
+		audit.Resource{ID: refund.ID, Type: auditResourceRefund},
+		refund.UserID,
+	))
+	if err != nil {
+		return fmt.Errorf("creating audit record: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/anmho/idempotent-rides/api"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/payments"
+	"github.com/anmho/idempotent-rides/rides"
+	"github.com/anmho/idempotent-rides/send"
+	"github.com/anmho/idempotent-rides/test"
+	"github.com/anmho/idempotent-rides/users"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// refundRequest is a refund of the ride with amount, made with the idempotency key.
+type refundRequest struct {
+	key    string
+	amount int64
+	userID *int
+}
+
+func TestServer_handleRideRefund(t *testing.T) {
+	t.Parallel()
+
+	// Rides reserved by the tests cost the minimum fare of 500.
+	tests := []struct {
+		desc string
+		// script runs after the ride was reserved.
+		script func(g *payments.FakeGateway)
+		// requests are made in order. The last response is checked.
+		requests []refundRequest
+
+		expectedStatus  int
+		expectedCode    string
+		expectedRefunds []int64
+	}{
+		{
+			desc:     "happy path: full refund",
+			requests: []refundRequest{{key: "refund-1"}},
+
+			expectedStatus:  http.StatusCreated,
+			expectedRefunds: []int64{500},
+		},
+		{
+			desc:     "happy path: partial refunds up to the fare",
+			requests: []refundRequest{{key: "refund-1", amount: 200}, {key: "refund-2", amount: 300}},
+
+			expectedStatus:  http.StatusCreated,
+			expectedRefunds: []int64{200, 300},
+		},
+		{
+			desc:     "happy path: full refund after a partial one refunds the rest",
+			requests: []refundRequest{{key: "refund-1", amount: 200}, {key: "refund-2"}},
+
+			expectedStatus:  http.StatusCreated,
+			expectedRefunds: []int64{200, 300},
+		},
+		{
+			desc:     "happy path: retry with the same key doesn't refund again",
+			requests: []refundRequest{{key: "refund-1", amount: 200}, {key: "refund-1", amount: 200}},
+
+			expectedStatus:  http.StatusCreated,
+			expectedRefunds: []int64{200},
+		},
+		{
+			desc: "happy path: retry after a gateway timeout doesn't refund again",
+			script: func(g *payments.FakeGateway) {
+				g.TimeoutNext(payments.OpCreateRefund)
+			},
+			requests: []refundRequest{{key: "refund-1"}, {key: "refund-1"}},
+
+			expectedStatus:  http.StatusCreated,
+			expectedRefunds: []int64{500},
+		},
+		{
+			desc:     "error path: refund more than the fare. should return 422",
+			requests: []refundRequest{{key: "refund-1", amount: 501}},
+
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   "refund_exceeds_charge",
+		},
+		{
+			desc:     "error path: partial refunds add up to more than the fare. should return 422",
+			requests: []refundRequest{{key: "refund-1", amount: 300}, {key: "refund-2", amount: 300}},
+
+			expectedStatus:  http.StatusUnprocessableEntity,
+			expectedCode:    "refund_exceeds_charge",
+			expectedRefunds: []int64{300},
+		},
+		{
+			desc:     "error path: ride was already refunded in full. should return 422",
+			requests: []refundRequest{{key: "refund-1"}, {key: "refund-2"}},
+
+			expectedStatus:  http.StatusUnprocessableEntity,
+			expectedCode:    "refund_exceeds_charge",
+			expectedRefunds: []int64{500},
+		},
+		{
+			desc:     "error path: ride of another user. should return 404",
+			requests: []refundRequest{{key: "refund-1", userID: users.TestUser1ID}},
+
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "ride_not_found",
+		},
+		{
+			desc: "happy path: refund refused by the processor doesn't count as refunded",
+			script: func(g *payments.FakeGateway) {
+				g.FailNext(payments.OpCreateRefund, &payments.CardError{Code: "expired_or_canceled_card", Message: "The card was canceled."})
+			},
+			requests: []refundRequest{{key: "refund-1"}, {key: "refund-2"}},
+
+			expectedStatus:  http.StatusCreated,
+			expectedRefunds: []int64{500},
+		},
+		{
+			desc: "error path: refund refused by the processor. should return 402",
+			script: func(g *payments.FakeGateway) {
+				g.FailNext(payments.OpCreateRefund, &payments.CardError{Code: "expired_or_canceled_card", Message: "The card was canceled."})
+			},
+			requests: []refundRequest{{key: "refund-1"}},
+
+			expectedStatus: http.StatusPaymentRequired,
+			expectedCode:   "expired_or_canceled_card",
+		},
+		{
+			desc: "error path: refund rejected by the processor isn't retried. should return 422",
+			script: func(g *payments.FakeGateway) {
+				// Refunded out of band, like in the Stripe dashboard.
+				_, err := g.CreateRefund(context.Background(), payments.RefundParams{PaymentIntentID: g.PaymentIntents()[0].ID})
+				if err != nil {
+					panic(err)
+				}
+			},
+			requests: []refundRequest{{key: "refund-1"}, {key: "refund-1"}},
+
+			expectedStatus:  http.StatusUnprocessableEntity,
+			expectedCode:    "refund_rejected",
+			expectedRefunds: []int64{500},
+		},
+		{
+			desc: "happy path: refund rejected by the processor doesn't count as refunded",
+			script: func(g *payments.FakeGateway) {
+				g.FailNext(payments.OpCreateRefund, &payments.InvalidRequestError{Code: "charge_disputed", Message: "Charge is disputed."})
+			},
+			requests: []refundRequest{{key: "refund-1"}, {key: "refund-2"}},
+
+			expectedStatus:  http.StatusCreated,
+			expectedRefunds: []int64{500},
+		},
+		{
+			desc: "error path: gateway timeout. should return 503",
+			script: func(g *payments.FakeGateway) {
+				g.FailNext(payments.OpCreateRefund, payments.ErrTimeout)
+			},
+			requests: []refundRequest{{key: "refund-1"}},
+
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			gateway := payments.MakeFakeGateway()
+			srv := test.MakeTestServerWithGateway(t, api.Config{}, gateway)
+			rideID := reserveRide(t, srv)
+			if tc.script != nil {
+				tc.script(gateway)
+			}
+
+			var resp *http.Response
+			for _, request := range tc.requests {
+				userID := request.userID
+				if userID == nil {
+					userID = &JoshTestUser.ID
+				}
+				params := api.RideRefundParams{UserID: userID, Amount: request.amount}
+				resp = postJSON(t, srv, fmt.Sprintf("/rides/%d/refunds", rideID), request.key, params)
+			}
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			if tc.expectedCode != "" {
+				httpErr, err := send.Read[send.HTTPError](resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedCode, httpErr.Code)
+			}
+			if tc.expectedStatus == http.StatusCreated {
+				refund, err := send.Read[api.RideRefundResponse](resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, rideID, refund.RideID)
+				assert.Equal(t, "succeeded", refund.Status)
+			}
+
+			var amounts []int64
+			for _, refund := range gateway.Refunds() {
+				amounts = append(amounts, refund.Amount)
+			}
+			assert.Equal(t, tc.expectedRefunds, amounts)
+		})
+	}
+}
+
+func TestServer_handleRideRefund_path(t *testing.T) {
+	t.Parallel()
+	srv := test.MakeTestServer(t)
+
+	resp := postJSON(t, srv, "/rides/abc/refunds", "refund-1", api.RideRefundParams{UserID: &JoshTestUser.ID})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp = postJSON(t, srv, "/rides/7258/refunds", "refund-2", api.RideRefundParams{UserID: &JoshTestUser.ID})
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+// reserveRide reserves a ride for JoshTestUser and returns its ID.
+func reserveRide(t *testing.T, srv *httptest.Server) int {
+	resp := postJSON(t, srv, "/rides", "reservation", api.RideReservationParams{
+		UserID: &JoshTestUser.ID,
+		Origin: &rides.Coordinate{},
+		Target: &rides.Coordinate{},
+	})
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	ride, err := send.Read[api.RideReservationResponse](resp.Body)
+	require.NoError(t, err)
+	return ride.RideID
+}
+
+func postJSON(t *testing.T, srv *httptest.Server, path, idempotencyKey string, params any) *http.Response {
+	body, err := json.Marshal(params)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, srv.URL+path, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set(idempotency.HeaderKey, idempotencyKey)
+	resp, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	return resp
+}
@@ -2,20 +2,40 @@ package api
 
 import (
 	"database/sql"
-	audit "github.com/anmho/idempotent-rides/audit"
-	"github.com/anmho/idempotent-rides/rides"
-	"github.com/anmho/idempotent-rides/users"
+	"github.com/anmho/idempotent-rides/idempotency"
 	"net/http"
 )
 
-func registerRoutes(
-	mux *http.ServeMux,
-	db *sql.DB,
-	rideService rides.Service,
-	auditService audit.Service,
-	userService users.Service) {
+func registerRoutes(mux *http.ServeMux, db *sql.DB, keyStore idempotency.KeyStore, cfg Config, services *Services) {
+	mux.HandleFunc("POST /rides", MakeHandlerFunc(handleRideReservation(keyStore, cfg, services)))
+	mux.HandleFunc("POST /rides/{id}/refunds", MakeHandlerFunc(handleRideRefund(keyStore, cfg, services)))
+	mux.HandleFunc("POST /users", MakeHandlerFunc(handleRegisterUser(keyStore, cfg, services)))
 
-	mux.HandleFunc("POST /rides", MakeHandlerFunc(handleRideReservation(db, rideService, auditService, userService)))
-	mux.HandleFunc("POST /users", MakeHandlerFunc(handleRegisterUser(db, userService)))
+	// Quotes can only be handed out when they can be signed.
+	if cfg.Quotes.SigningKey != "" {
+		mux.HandleFunc("POST /quotes", MakeHandlerFunc(handleCreateQuote(db, cfg, services)))
+	}
 
+	// Admin routes are only served when an admin token is configured.
+	if cfg.AdminToken != "" {
+		admin := func(handler RouteHandler) http.HandlerFunc {
+			return MakeHandlerFunc(requireAdmin(cfg.AdminToken, handler))
+		}
+		mux.HandleFunc("GET /admin/idempotency-keys", admin(handleAdminGetKey(keyStore)))
+		mux.HandleFunc("GET /admin/idempotency-keys/stuck", admin(handleAdminListStuckKeys(keyStore)))
+		mux.HandleFunc("POST /admin/idempotency-keys/{id}/unlock", admin(handleAdminUnlockKey(keyStore, services.Audit)))
+		mux.HandleFunc("POST /admin/idempotency-keys/{id}/finish", admin(handleAdminFinishKey(keyStore, services.Audit)))
+		mux.HandleFunc("GET /admin/users/{id}/emails", admin(handleAdminListUserEmails(db, services.Outbox)))
+		// expvar publishes the metrics along with the command line and memory
+		// stats of the process, which are no business of clients.
+		mux.HandleFunc("GET /debug/vars", admin(handleDebugVars()))
+	}
+}
+
+// registerIdempotentRoutes registers the workflow of every idempotent route served by
+// registerRoutes so that abandoned keys can be completed in the background.
+func registerIdempotentRoutes(registry *idempotency.Registry, services *Services) {
+	registry.Register(http.MethodPost, "/rides", rideReservationRoute(services).WorkflowFromParams)
+	registry.Register(http.MethodPost, "/rides/{id}/refunds", rideRefundRoute(services).WorkflowFromParams)
+	registry.Register(http.MethodPost, "/users", registerUserRoute(services).WorkflowFromParams)
 }
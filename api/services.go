@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"github.com/anmho/idempotent-rides/audit"
+	"github.com/anmho/idempotent-rides/emails"
+	"github.com/anmho/idempotent-rides/jobs"
+	"github.com/anmho/idempotent-rides/payments"
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/anmho/idempotent-rides/quotes"
+	"github.com/anmho/idempotent-rides/refunds"
+	"github.com/anmho/idempotent-rides/rides"
+	"github.com/anmho/idempotent-rides/users"
+)
+
+// Services are the dependencies of the routes. The server and the completer share
+// them so that a workflow finished in the background runs exactly like it does when
+// it is served.
+type Services struct {
+	// Gateway is Stripe in production and a payments.FakeGateway in tests.
+	Gateway     payments.Gateway
+	Calculator  *pricing.Calculator
+	QuoteSigner *quotes.Signer
+
+	Audit   audit.Service
+	Jobs    jobs.Service
+	Outbox  emails.OutboxService
+	Quotes  quotes.Service
+	Refunds refunds.Service
+	Rides   rides.Service
+	Users   users.Service
+}
+
+func MakeServices(cfg Config, gateway payments.Gateway) (*Services, error) {
+	calculator, err := pricing.MakeCalculator(cfg.Pricing)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pricing: %w", err)
+	}
+	return &Services{
+		Gateway:     gateway,
+		Calculator:  calculator,
+		QuoteSigner: quotes.MakeSigner(cfg.Quotes.SigningKey),
+
+		Audit:   audit.MakeService(),
+		Jobs:    jobs.MakeService(),
+		Outbox:  emails.MakeOutboxService(),
+		Quotes:  quotes.MakeService(),
+		Refunds: refunds.MakeService(),
+		Rides:   rides.MakeService(),
+		Users:   users.MakeService(),
+	}, nil
+}
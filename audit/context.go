@@ -0,0 +1,23 @@
+package audit
+
+import "context"
+
+// UnknownOriginIP is the origin of records written outside of a request, like by the
+// completer finishing a request whose client went away.
+const UnknownOriginIP = "0.0.0.0"
+
+type originIPKey struct{}
+
+// WithOriginIP returns a copy of ctx that carries the IP address the request being
+// served came from, for records written deeper down, like in the phases of a workflow.
+func WithOriginIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, originIPKey{}, ip)
+}
+
+// OriginIP returns the IP address set on ctx by WithOriginIP, or UnknownOriginIP.
+func OriginIP(ctx context.Context) string {
+	if ip, ok := ctx.Value(originIPKey{}).(string); ok && ip != "" {
+		return ip
+	}
+	return UnknownOriginIP
+}
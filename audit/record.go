@@ -17,7 +17,8 @@ type Record struct {
 	OriginIP  string
 
 	Resource Resource
-	// UserID is the ID of the user that initiated this record
+	// UserID is the ID of the user that initiated this record. It is 0 for actions
+	// taken by operators through the admin API.
 	UserID int
 }
 
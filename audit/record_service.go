@@ -28,7 +28,7 @@ func (s *service) GetRecord(ctx context.Context, tx *sql.Tx, id int) (*Record, e
 	SELECT 
 	    id, created_at,
 	    action, data, origin_ip, 
-	    resource_id, resource_type, user_id
+	    resource_id, resource_type, COALESCE(user_id, 0)
 	FROM rocket_rides.public.audit_records
 	WHERE id = $1
 	;
@@ -59,12 +59,12 @@ func (s *service) CreateRecord(ctx context.Context, tx *sql.Tx, record *Record)
 	) VALUES (
 		$1, $2, $3,
 	    $4, $5,
-		$6
+		NULLIF($6::BIGINT, 0)
 	) RETURNING 
 	    id, created_at, origin_ip,  
 		action, data, 
 	    resource_id, resource_type, 
-	    user_id
+	    COALESCE(user_id, 0)
 	;
 	`
 
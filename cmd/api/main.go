@@ -5,14 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"github.com/anmho/idempotent-rides/api"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/payments"
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/anmho/idempotent-rides/quotes"
 	"github.com/caarlos0/env/v11"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
-	"github.com/stripe/stripe-go/v79"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 )
 
 const (
@@ -32,6 +36,15 @@ type config struct {
 	DBName string `env:"DB_NAME"`
 
 	StripeKey string `env:"STRIPE_KEY"`
+
+	IdempotencyKeyLockTimeout time.Duration `env:"IDEMPOTENCY_KEY_LOCK_TIMEOUT" envDefault:"90s"`
+	IdempotencyMaxRetries     int           `env:"IDEMPOTENCY_MAX_RETRIES" envDefault:"5"`
+	IdempotencyMaxAttempts    int           `env:"IDEMPOTENCY_MAX_ATTEMPTS" envDefault:"10"`
+
+	AdminToken string `env:"ADMIN_TOKEN"`
+
+	Pricing pricing.Config
+	Quotes  quotes.Config
 }
 
 func main() {
@@ -47,22 +60,34 @@ func main() {
 	if err != nil {
 		log.Fatalln("error parsing config")
 	}
-	fmt.Printf("%+v\n", cfg)
 	dbURL := MakeConnString(cfg.DBUser, cfg.DBPass, cfg.DBHost, cfg.DBPort, cfg.DBName)
 
-	stripe.Key = cfg.StripeKey
+	gateway := payments.MakeStripeGateway(cfg.StripeKey)
 	db, err := sql.Open("pgx", dbURL)
-	mux := api.MakeServer(db)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	apiCfg := api.Config{
+		Idempotency: idempotency.Config{
+			LockTimeout: cfg.IdempotencyKeyLockTimeout,
+			MaxRetries:  cfg.IdempotencyMaxRetries,
+			MaxAttempts: cfg.IdempotencyMaxAttempts,
+		},
+		AdminToken: cfg.AdminToken,
+		Pricing:    cfg.Pricing,
+		Quotes:     cfg.Quotes,
+	}
+	services, err := api.MakeServices(apiCfg, gateway)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	mux := api.MakeServer(db, apiCfg, services)
 
 	srv := http.Server{
 		Addr:    fmt.Sprintf(":%d", port),
 		Handler: mux,
 	}
 
-	if err != nil {
-		log.Fatalln(err)
-	}
-
 	slog.Info("server starting", slog.Int("port", port))
 	if err := srv.ListenAndServe(); err != nil {
 		if !errors.Is(err, http.ErrServerClosed) {
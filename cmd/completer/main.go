@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/anmho/idempotent-rides/api"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/payments"
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/anmho/idempotent-rides/quotes"
+	database "github.com/anmho/idempotent-rides/sql"
+	"github.com/caarlos0/env/v11"
+	"github.com/joho/godotenv"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type config struct {
+	database.Config
+
+	StripeKey string `env:"STRIPE_KEY"`
+
+	IdempotencyKeyLockTimeout time.Duration `env:"IDEMPOTENCY_KEY_LOCK_TIMEOUT" envDefault:"90s"`
+	IdempotencyMaxRetries     int           `env:"IDEMPOTENCY_MAX_RETRIES" envDefault:"5"`
+	IdempotencyMaxAttempts    int           `env:"IDEMPOTENCY_MAX_ATTEMPTS" envDefault:"10"`
+
+	Threshold time.Duration `env:"COMPLETER_THRESHOLD" envDefault:"5m"`
+	BatchSize int           `env:"COMPLETER_BATCH_SIZE" envDefault:"100"`
+	Interval  time.Duration `env:"COMPLETER_INTERVAL" envDefault:"1m"`
+
+	Pricing pricing.Config
+	Quotes  quotes.Config
+}
+
+func main() {
+	once := flag.Bool("once", false, "complete one batch of abandoned keys and exit")
+	flag.Parse()
+
+	if os.Getenv("STAGE") == "" || os.Getenv("STAGE") == "development" {
+		err := godotenv.Load()
+		if err != nil {
+			log.Fatalln("Error loading .env file", err)
+		}
+	}
+
+	var cfg config
+	err := env.Parse(&cfg)
+	if err != nil {
+		log.Fatalln("error parsing config")
+	}
+
+	gateway := payments.MakeStripeGateway(cfg.StripeKey)
+	db, err := database.Open(cfg.Config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer db.Close()
+
+	apiCfg := api.Config{
+		Idempotency: idempotency.Config{
+			LockTimeout: cfg.IdempotencyKeyLockTimeout,
+			MaxRetries:  cfg.IdempotencyMaxRetries,
+			MaxAttempts: cfg.IdempotencyMaxAttempts,
+		},
+		Pricing: cfg.Pricing,
+		Quotes:  cfg.Quotes,
+	}
+	services, err := api.MakeServices(apiCfg, gateway)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	completer := api.MakeCompleter(db, apiCfg, idempotency.CompleterConfig{
+		Threshold: cfg.Threshold,
+		BatchSize: cfg.BatchSize,
+		Interval:  cfg.Interval,
+	}, services)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		n, err := completer.CompleteOnce(ctx)
+		if err != nil {
+			log.Fatalln("error completing idempotency keys", err)
+		}
+		fmt.Printf("completed %d idempotency keys\n", n)
+		return
+	}
+
+	slog.Info("completer starting", slog.Duration("threshold", cfg.Threshold), slog.Duration("interval", cfg.Interval))
+	if err := completer.Run(ctx); err != nil {
+		slog.Error("completer stopped", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/anmho/idempotent-rides/emails"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+)
+
+// fixtures holds the data each template is previewed with, in fixtures/<name>.json.
+//
+//go:embed fixtures
+var fixtures embed.FS
+
+func main() {
+	name := flag.String("template", "", "template to render")
+	locale := flag.String("locale", emails.DefaultLocale, "locale to render the template in")
+	format := flag.String("format", "text", "body to print: text or html")
+	fixture := flag.String("fixture", "", "JSON file with the template data, instead of the built-in fixture")
+	list := flag.Bool("list", false, "list the templates and locales and exit")
+	flag.Parse()
+
+	templates, err := emails.LoadTemplates()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *list {
+		fmt.Printf("templates: %s\n", strings.Join(templates.Names(), ", "))
+		fmt.Printf("locales: %s\n", strings.Join(templates.Locales(), ", "))
+		return
+	}
+	if *name == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := loadFixture(*name, *fixture)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	msg, err := templates.Render(*name, *locale, data)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	switch *format {
+	case "text":
+		fmt.Printf("Subject: %s\n\n%s", msg.Subject, msg.Text)
+	case "html":
+		// The subject is left out so the output can be opened in a browser as is.
+		fmt.Print(msg.HTML)
+	default:
+		log.Fatalf("unknown format %q\n", *format)
+	}
+}
+
+func loadFixture(name, path string) (any, error) {
+	var b []byte
+	var err error
+	if path != "" {
+		b, err = os.ReadFile(path)
+	} else {
+		b, err = fixtures.ReadFile("fixtures/" + name + ".json")
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("no fixture for template %s, pass one with -fixture", name)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading fixture: %w", err)
+	}
+
+	var data any
+	if err = json.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("decoding fixture: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/anmho/idempotent-rides/jobs"
+	database "github.com/anmho/idempotent-rides/sql"
+	"github.com/caarlos0/env/v11"
+	"github.com/joho/godotenv"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type config struct {
+	database.Config
+
+	BatchSize int           `env:"ENQUEUER_BATCH_SIZE" envDefault:"1000"`
+	Interval  time.Duration `env:"ENQUEUER_INTERVAL" envDefault:"1s"`
+}
+
+func main() {
+	once := flag.Bool("once", false, "enqueue staged jobs once and exit")
+	flag.Parse()
+
+	if os.Getenv("STAGE") == "" || os.Getenv("STAGE") == "development" {
+		err := godotenv.Load()
+		if err != nil {
+			log.Fatalln("Error loading .env file", err)
+		}
+	}
+
+	var cfg config
+	err := env.Parse(&cfg)
+	if err != nil {
+		log.Fatalln("error parsing config")
+	}
+
+	db, err := database.Open(cfg.Config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer db.Close()
+
+	enqueuer := jobs.MakeEnqueuer(db, jobs.EnqueuerConfig{
+		BatchSize: cfg.BatchSize,
+		Interval:  cfg.Interval,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		n, err := enqueuer.EnqueueOnce(ctx)
+		if err != nil {
+			log.Fatalln("error enqueuing staged jobs", err)
+		}
+		fmt.Printf("enqueued %d staged jobs\n", n)
+		return
+	}
+
+	slog.Info("enqueuer starting", slog.Duration("interval", cfg.Interval))
+	if err := enqueuer.Run(ctx); err != nil {
+		slog.Error("enqueuer stopped", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/anmho/idempotent-rides/idempotency"
+	database "github.com/anmho/idempotent-rides/sql"
+	"github.com/caarlos0/env/v11"
+	"github.com/joho/godotenv"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type config struct {
+	database.Config
+
+	Retention time.Duration `env:"REAPER_RETENTION" envDefault:"72h"`
+	BatchSize int           `env:"REAPER_BATCH_SIZE" envDefault:"1000"`
+	Interval  time.Duration `env:"REAPER_INTERVAL" envDefault:"1m"`
+}
+
+func main() {
+	once := flag.Bool("once", false, "reap expired keys once and exit")
+	flag.Parse()
+
+	if os.Getenv("STAGE") == "" || os.Getenv("STAGE") == "development" {
+		err := godotenv.Load()
+		if err != nil {
+			log.Fatalln("Error loading .env file", err)
+		}
+	}
+
+	var cfg config
+	err := env.Parse(&cfg)
+	if err != nil {
+		log.Fatalln("error parsing config")
+	}
+
+	db, err := database.Open(cfg.Config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer db.Close()
+
+	reaper := idempotency.MakeReaper(idempotency.MakePostgresStore(db), idempotency.ReaperConfig{
+		Retention: cfg.Retention,
+		BatchSize: cfg.BatchSize,
+		Interval:  cfg.Interval,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		n, err := reaper.ReapOnce(ctx)
+		if err != nil {
+			log.Fatalln("error reaping idempotency keys", err)
+		}
+		fmt.Printf("reaped %d idempotency keys\n", n)
+		return
+	}
+
+	slog.Info("reaper starting", slog.Duration("retention", cfg.Retention), slog.Duration("interval", cfg.Interval))
+	if err := reaper.Run(ctx); err != nil {
+		slog.Error("reaper stopped", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/anmho/idempotent-rides/api"
+	"github.com/anmho/idempotent-rides/emails"
+	"github.com/anmho/idempotent-rides/jobs"
+	database "github.com/anmho/idempotent-rides/sql"
+	"github.com/caarlos0/env/v11"
+	"github.com/joho/godotenv"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type config struct {
+	database.Config
+	Email emails.Config
+
+	BatchSize     int           `env:"WORKER_BATCH_SIZE" envDefault:"10"`
+	Interval      time.Duration `env:"WORKER_INTERVAL" envDefault:"1s"`
+	LeaseDuration time.Duration `env:"WORKER_LEASE_DURATION" envDefault:"5m"`
+	MaxAttempts   int           `env:"WORKER_MAX_ATTEMPTS" envDefault:"10"`
+	BackoffBase   time.Duration `env:"WORKER_BACKOFF_BASE" envDefault:"10s"`
+	MaxBackoff    time.Duration `env:"WORKER_MAX_BACKOFF" envDefault:"1h"`
+}
+
+func main() {
+	once := flag.Bool("once", false, "work one batch of jobs and exit")
+	flag.Parse()
+
+	if os.Getenv("STAGE") == "" || os.Getenv("STAGE") == "development" {
+		err := godotenv.Load()
+		if err != nil {
+			log.Fatalln("Error loading .env file", err)
+		}
+	}
+
+	var cfg config
+	err := env.Parse(&cfg)
+	if err != nil {
+		log.Fatalln("error parsing config")
+	}
+
+	db, err := database.Open(cfg.Config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer db.Close()
+
+	emailService, err := emails.MakeService(cfg.Email)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	templates, err := emails.LoadTemplates()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	registry := api.MakeJobRegistry(db, emails.MakeMailer(db, emailService, templates))
+	worker := jobs.MakeWorker(db, registry, jobs.WorkerConfig{
+		BatchSize:     cfg.BatchSize,
+		Interval:      cfg.Interval,
+		LeaseDuration: cfg.LeaseDuration,
+		MaxAttempts:   cfg.MaxAttempts,
+		BackoffBase:   cfg.BackoffBase,
+		MaxBackoff:    cfg.MaxBackoff,
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		n, err := worker.WorkOnce(ctx)
+		if err != nil {
+			log.Fatalln("error working jobs", err)
+		}
+		fmt.Printf("worked %d jobs\n", n)
+		return
+	}
+
+	slog.Info("worker starting", slog.Duration("interval", cfg.Interval))
+	if err := worker.Run(ctx); err != nil {
+		slog.Error("worker stopped", slog.String("error", err.Error()))
+	}
+}
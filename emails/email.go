@@ -0,0 +1,38 @@
+package emails
+
+// Message is an email to a single recipient. At least one of Text and HTML must be
+// set; when both are, clients pick the one they can display.
+type Message struct {
+	To      string
+	Subject string
+	// Text is the plain-text body.
+	Text string
+	// HTML is the HTML body.
+	HTML string
+}
+
+const (
+	BackendSMTP = "smtp"
+	BackendFile = "file"
+)
+
+// Config selects the backend that delivers mail and the sender of every message. It
+// is read from the environment by the processes that send mail.
+type Config struct {
+	// Backend is BackendSMTP or BackendFile.
+	Backend string `env:"EMAIL_BACKEND" envDefault:"file"`
+	From    string `env:"EMAIL_FROM" envDefault:"Rocket Rides <receipts@rocketrides.io>"`
+	// ReplyTo is optional.
+	ReplyTo string `env:"EMAIL_REPLY_TO"`
+
+	SMTP SMTPConfig
+	// Dir is the maildir the file backend writes to.
+	Dir string `env:"EMAIL_DIR" envDefault:"./mail"`
+}
+
+type SMTPConfig struct {
+	Host     string `env:"SMTP_HOST"`
+	Port     string `env:"SMTP_PORT" envDefault:"587"`
+	Username string `env:"SMTP_USERNAME"`
+	Password string `env:"SMTP_PASSWORD"`
+}
@@ -1,8 +1,24 @@
 package emails
 
-type emailService struct {
-}
+import (
+	"context"
+	"fmt"
+)
 
-func MakeService() {
+type Service interface {
+	// Send delivers msg and returns the ID the provider assigned to it. Errors that
+	// retrying can't fix are *PermanentError.
+	Send(ctx context.Context, msg *Message) (string, error)
+}
 
+// MakeService returns the Service of the backend selected by cfg.
+func MakeService(cfg Config) (Service, error) {
+	switch cfg.Backend {
+	case BackendSMTP:
+		return MakeSMTPService(cfg), nil
+	case BackendFile, "":
+		return MakeFileService(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown email backend %q", cfg.Backend)
+	}
 }
@@ -0,0 +1,47 @@
+package emails
+
+import (
+	"errors"
+	"fmt"
+)
+
+// PermanentError is returned when sending a message again can't succeed, like when
+// the recipient's address is invalid or the server rejected it for good. Any other
+// error from Send is worth retrying.
+type PermanentError struct {
+	Cause error
+	// Bounced is set when the provider rejected the message, rather than the message
+	// being invalid.
+	Bounced bool
+}
+
+var _ error = (*PermanentError)(nil)
+
+func Permanent(cause error) *PermanentError {
+	return &PermanentError{Cause: cause}
+}
+
+// Bounce marks cause as the provider rejecting a message for good.
+func Bounce(cause error) *PermanentError {
+	return &PermanentError{Cause: cause, Bounced: true}
+}
+
+func (e *PermanentError) Error() string {
+	return fmt.Sprintf("permanent email error: %v", e.Cause)
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Cause
+}
+
+// IsPermanent reports whether err is a *PermanentError.
+func IsPermanent(err error) bool {
+	var permanentErr *PermanentError
+	return errors.As(err, &permanentErr)
+}
+
+// IsBounced reports whether err is a *PermanentError of a rejected message.
+func IsBounced(err error) bool {
+	var permanentErr *PermanentError
+	return errors.As(err, &permanentErr) && permanentErr.Bounced
+}
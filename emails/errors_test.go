@@ -0,0 +1,68 @@
+package emails
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/textproto"
+	"syscall"
+	"testing"
+)
+
+func Test_classifySMTPError(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc string
+		err  error
+
+		expectedBounced bool
+	}{
+		{
+			desc: "mailbox unavailable reply bounces",
+			err:  &textproto.Error{Code: 550, Msg: "5.1.1 No such user"},
+
+			expectedBounced: true,
+		},
+		{
+			desc: "wrapped message too big reply bounces",
+			err:  fmt.Errorf("finishing data: %w", &textproto.Error{Code: 552, Msg: "5.3.4 Message too big"}),
+
+			expectedBounced: true,
+		},
+		{
+			desc: "greylisting reply is retried",
+			err:  &textproto.Error{Code: 451, Msg: "4.7.1 Greylisted, try again later"},
+		},
+		{
+			desc: "insufficient storage reply is retried",
+			err:  &textproto.Error{Code: 452, Msg: "4.3.1 Insufficient system storage"},
+		},
+		{
+			desc: "authentication failure is retried",
+			err:  fmt.Errorf("authenticating: %w", &textproto.Error{Code: 535, Msg: "5.7.8 Authentication credentials invalid"}),
+		},
+		{
+			desc: "connection refused is retried",
+			err:  fmt.Errorf("dialing smtp server: %w", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}),
+		},
+		{
+			desc: "context cancellation is retried",
+			err:  fmt.Errorf("dialing smtp server: %w", context.Canceled),
+		},
+		{
+			desc: "context deadline is retried",
+			err:  fmt.Errorf("writing data: %w", context.DeadlineExceeded),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := classifySMTPError(tc.err)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.expectedBounced, IsBounced(err))
+			assert.Equal(t, tc.expectedBounced, IsPermanent(err))
+		})
+	}
+}
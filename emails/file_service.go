@@ -0,0 +1,74 @@
+package emails
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/anmho/idempotent-rides/scope"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ Service = (*fileService)(nil)
+
+// fileService delivers messages to a maildir, one file per message, which any mail
+// client can open. It is meant for development and tests.
+type fileService struct {
+	cfg Config
+}
+
+// MakeFileService returns a Service that writes messages to the maildir at cfg.Dir.
+func MakeFileService(cfg Config) Service {
+	return &fileService{cfg: cfg}
+}
+
+func (s *fileService) Send(ctx context.Context, msg *Message) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	env, err := buildEnvelope(s.cfg, msg)
+	if err != nil {
+		return "", err
+	}
+
+	// Like any maildir writer, write to tmp and move the finished file to new so
+	// readers never see a partial message.
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err = os.MkdirAll(filepath.Join(s.cfg.Dir, sub), 0o755); err != nil {
+			return "", fmt.Errorf("creating maildir: %w", err)
+		}
+	}
+	name, err := maildirName()
+	if err != nil {
+		return "", err
+	}
+	tmp := filepath.Join(s.cfg.Dir, "tmp", name)
+	if err = os.WriteFile(tmp, env.Data, 0o644); err != nil {
+		return "", fmt.Errorf("writing message: %w", err)
+	}
+	if err = os.Rename(tmp, filepath.Join(s.cfg.Dir, "new", name)); err != nil {
+		return "", fmt.Errorf("delivering message: %w", err)
+	}
+
+	scope.GetLogger().Info("wrote email",
+		slog.String("to", env.To),
+		slog.String("messageID", env.MessageID),
+		slog.String("file", name),
+	)
+	return env.MessageID, nil
+}
+
+func maildirName() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%d.%s.%s.eml", time.Now().UnixNano(), hex.EncodeToString(b), host), nil
+}
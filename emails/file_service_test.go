@@ -0,0 +1,146 @@
+package emails_test
+
+import (
+	"context"
+	"github.com/anmho/idempotent-rides/emails"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileService_Send(t *testing.T) {
+	tests := []struct {
+		desc string
+		msg  *emails.Message
+
+		expectedContentTypes []string
+		expectedPermanentErr bool
+	}{
+		{
+			desc: "happy path: text message",
+			msg: &emails.Message{
+				To:      "Andrew <andrew@example.com>",
+				Subject: "Your receipt",
+				Text:    "Thanks for riding!",
+			},
+
+			expectedContentTypes: []string{"text/plain"},
+		},
+		{
+			desc: "happy path: text and html message",
+			msg: &emails.Message{
+				To:      "andrew@example.com",
+				Subject: "Your receipt",
+				Text:    "Thanks for riding!",
+				HTML:    "<p>Thanks for riding!</p>",
+			},
+
+			expectedContentTypes: []string{"text/plain", "text/html"},
+		},
+		{
+			desc: "error path: invalid recipient",
+			msg: &emails.Message{
+				To:      "not an address",
+				Subject: "Your receipt",
+				Text:    "Thanks for riding!",
+			},
+
+			expectedPermanentErr: true,
+		},
+		{
+			desc: "error path: no body",
+			msg: &emails.Message{
+				To:      "andrew@example.com",
+				Subject: "Your receipt",
+			},
+
+			expectedPermanentErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			service := emails.MakeFileService(emails.Config{
+				From:    "Rocket Rides <receipts@rocketrides.io>",
+				ReplyTo: "support@rocketrides.io",
+				Dir:     dir,
+			})
+
+			messageID, err := service.Send(context.Background(), tc.msg)
+			if tc.expectedPermanentErr {
+				assert.True(t, emails.IsPermanent(err), "expected a permanent error, got %v", err)
+				return
+			}
+			require.NoError(t, err)
+
+			files, err := os.ReadDir(filepath.Join(dir, "new"))
+			require.NoError(t, err)
+			require.Len(t, files, 1)
+			f, err := os.Open(filepath.Join(dir, "new", files[0].Name()))
+			require.NoError(t, err)
+			defer f.Close()
+
+			m, err := mail.ReadMessage(f)
+			require.NoError(t, err)
+			assert.Equal(t, messageID, m.Header.Get("Message-ID"))
+			assert.Equal(t, `"Rocket Rides" <receipts@rocketrides.io>`, m.Header.Get("From"))
+			assert.Equal(t, "<support@rocketrides.io>", m.Header.Get("Reply-To"))
+			assert.Equal(t, tc.msg.Subject, m.Header.Get("Subject"))
+
+			mediaType, params, err := mime.ParseMediaType(m.Header.Get("Content-Type"))
+			require.NoError(t, err)
+			if !strings.HasPrefix(mediaType, "multipart/") {
+				assert.Equal(t, tc.expectedContentTypes, []string{mediaType})
+				return
+			}
+
+			var contentTypes []string
+			r := multipart.NewReader(m.Body, params["boundary"])
+			for {
+				part, err := r.NextPart()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				contentType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+				require.NoError(t, err)
+				contentTypes = append(contentTypes, contentType)
+			}
+			assert.Equal(t, tc.expectedContentTypes, contentTypes)
+		})
+	}
+}
+
+func TestMakeService(t *testing.T) {
+	tests := []struct {
+		desc    string
+		backend string
+
+		expectedErr bool
+	}{
+		{desc: "happy path: file backend", backend: emails.BackendFile},
+		{desc: "happy path: smtp backend", backend: emails.BackendSMTP},
+		{desc: "happy path: file backend by default", backend: ""},
+		{desc: "error path: unknown backend", backend: "carrier-pigeon", expectedErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			service, err := emails.MakeService(emails.Config{Backend: tc.backend})
+			if tc.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, service)
+		})
+	}
+}
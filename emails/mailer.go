@@ -0,0 +1,120 @@
+package emails
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/anmho/idempotent-rides/scope"
+	"log/slog"
+	"time"
+)
+
+// SendParams describes a templated email to send.
+type SendParams struct {
+	// UserID is the user the email is sent to. Zero when it isn't sent to a user.
+	UserID int
+	To     string
+	// Template is rendered in Locale with Data.
+	Template string
+	Locale   string
+	Data     any
+	// DedupeKey makes sure the email is sent at most once, no matter how often Send
+	// is called with it. Optional.
+	DedupeKey string
+}
+
+// Mailer renders templated emails, delivers them through a Service and records every
+// one of them in the email_messages outbox.
+type Mailer struct {
+	db        *sql.DB
+	service   Service
+	templates *Templates
+	outbox    OutboxService
+}
+
+func MakeMailer(db *sql.DB, service Service, templates *Templates) *Mailer {
+	return &Mailer{
+		db:        db,
+		service:   service,
+		templates: templates,
+		outbox:    MakeOutboxService(),
+	}
+}
+
+// Send renders and delivers the email described by params and returns its record.
+// Like Service.Send, errors that retrying can't fix are *PermanentError, and the
+// outcome of every attempt is recorded before Send returns. An email whose dedupe key
+// was already sent, failed or bounced is not sent again and its record is returned.
+//
+// A process that crashes between delivering an email and recording it sends the email
+// again on the next call.
+func (m *Mailer) Send(ctx context.Context, params SendParams) (*OutboxMessage, error) {
+	locale := params.Locale
+	if locale == "" {
+		locale = DefaultLocale
+	}
+	msg, renderErr := m.templates.Render(params.Template, locale, params.Data)
+	if renderErr != nil {
+		msg = &Message{}
+	}
+
+	record, err := m.outbox.QueueMessage(ctx, m.db, &OutboxMessage{
+		UserID:    sql.Null[int]{V: params.UserID, Valid: params.UserID != 0},
+		DedupeKey: sql.Null[string]{V: params.DedupeKey, Valid: params.DedupeKey != ""},
+		Recipient: params.To,
+		Template:  params.Template,
+		Locale:    locale,
+		Subject:   msg.Subject,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if record.Status.IsFinal() {
+		scope.GetLogger().Info("skipping email that was already handled",
+			slog.Int("emailID", record.ID),
+			slog.String("status", string(record.Status)),
+		)
+		return record, nil
+	}
+
+	var sendErr error
+	if renderErr != nil {
+		sendErr = Permanent(fmt.Errorf("rendering %s: %w", params.Template, renderErr))
+	} else {
+		msg.To = params.To
+		var providerMessageID string
+		providerMessageID, sendErr = m.service.Send(ctx, msg)
+		record.ProviderMessageID = sql.Null[string]{V: providerMessageID, Valid: providerMessageID != ""}
+	}
+
+	record.Attempts++
+	record.Status = statusOf(sendErr)
+	record.LastError = sql.Null[string]{}
+	if sendErr != nil {
+		record.LastError = sql.Null[string]{V: sendErr.Error(), Valid: true}
+	}
+	if record.Status == StatusSent {
+		record.SentAt = sql.Null[time.Time]{V: time.Now(), Valid: true}
+	}
+
+	// The outcome is recorded even when ctx was cancelled during delivery.
+	record, err = m.outbox.UpdateMessage(context.WithoutCancel(ctx), m.db, record)
+	if err != nil {
+		return nil, err
+	}
+	return record, sendErr
+}
+
+// statusOf returns the status of a message whose last delivery attempt ended with err.
+func statusOf(err error) Status {
+	switch {
+	case err == nil:
+		return StatusSent
+	case IsBounced(err):
+		return StatusBounced
+	case IsPermanent(err):
+		return StatusFailed
+	default:
+		return StatusQueued
+	}
+}
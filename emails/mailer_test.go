@@ -0,0 +1,106 @@
+package emails_test
+
+import (
+	"context"
+	"errors"
+	"github.com/anmho/idempotent-rides/emails"
+	"github.com/anmho/idempotent-rides/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// stubService fails with err, or accepts every message when err is nil.
+type stubService struct {
+	err error
+}
+
+func (s *stubService) Send(ctx context.Context, msg *emails.Message) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	return "<1@test>", nil
+}
+
+func TestMailer_Send(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc     string
+		template string
+		sendErr  error
+
+		expectedStatus    emails.Status
+		expectedPermanent bool
+		expectedErr       bool
+	}{
+		{
+			desc:     "happy path: sent email is recorded as sent",
+			template: "ride_receipt",
+
+			expectedStatus: emails.StatusSent,
+		},
+		{
+			desc:     "error path: rejected email is recorded as bounced",
+			template: "ride_receipt",
+			sendErr:  emails.Bounce(errors.New("550 mailbox unavailable")),
+
+			expectedStatus:    emails.StatusBounced,
+			expectedPermanent: true,
+			expectedErr:       true,
+		},
+		{
+			desc:     "error path: email that failed temporarily stays queued",
+			template: "ride_receipt",
+			sendErr:  errors.New("connection reset"),
+
+			expectedStatus: emails.StatusQueued,
+			expectedErr:    true,
+		},
+		{
+			desc:     "error path: unknown template is recorded as failed",
+			template: "does_not_exist",
+
+			expectedStatus:    emails.StatusFailed,
+			expectedPermanent: true,
+			expectedErr:       true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			db := test.MakePostgres(t)
+			templates, err := emails.LoadTemplates()
+			require.NoError(t, err)
+			mailer := emails.MakeMailer(db, &stubService{err: tc.sendErr}, templates)
+
+			record, err := mailer.Send(context.Background(), emails.SendParams{
+				UserID:   456,
+				To:       "cool-user@email.com",
+				Template: tc.template,
+				Data: map[string]any{
+					"RideID": 1442,
+					"Origin": map[string]any{"Lat": 72, "Long": 72},
+					"Target": map[string]any{"Lat": 72, "Long": 72},
+					"Amount": "5.00 USD",
+				},
+			})
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expectedPermanent, emails.IsPermanent(err))
+			require.NotNil(t, record)
+			assert.Equal(t, tc.expectedStatus, record.Status)
+			assert.Equal(t, 1, record.Attempts)
+			assert.Equal(t, tc.expectedStatus == emails.StatusSent, record.SentAt.Valid)
+			assert.Equal(t, tc.expectedErr, record.LastError.Valid)
+
+			msgs, err := emails.MakeOutboxService().ListUserMessages(context.Background(), db, 456, 10)
+			require.NoError(t, err)
+			require.Len(t, msgs, 1)
+			assert.Equal(t, record.ID, msgs[0].ID)
+		})
+	}
+}
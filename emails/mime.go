@@ -0,0 +1,148 @@
+package emails
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// envelope is a message ready to be delivered.
+type envelope struct {
+	From      string
+	To        string
+	MessageID string
+	Data      []byte
+}
+
+// buildEnvelope validates msg and encodes it as a MIME message from sender. Invalid
+// messages fail with a *PermanentError.
+func buildEnvelope(sender Config, msg *Message) (*envelope, error) {
+	if msg == nil {
+		return nil, Permanent(errors.New("nil message"))
+	}
+	from, err := mail.ParseAddress(sender.From)
+	if err != nil {
+		return nil, Permanent(fmt.Errorf("invalid from address %q: %w", sender.From, err))
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, Permanent(fmt.Errorf("invalid recipient %q: %w", msg.To, err))
+	}
+	if msg.Text == "" && msg.HTML == "" {
+		return nil, Permanent(errors.New("message has no body"))
+	}
+
+	messageID, err := newMessageID(from.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	header := []headerField{
+		{"From", from.String()},
+		{"To", to.String()},
+	}
+	if sender.ReplyTo != "" {
+		replyTo, err := mail.ParseAddress(sender.ReplyTo)
+		if err != nil {
+			return nil, Permanent(fmt.Errorf("invalid reply-to address %q: %w", sender.ReplyTo, err))
+		}
+		header = append(header, headerField{"Reply-To", replyTo.String()})
+	}
+	header = append(header,
+		headerField{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		headerField{"Date", time.Now().Format(time.RFC1123Z)},
+		headerField{"Message-ID", messageID},
+		headerField{"MIME-Version", "1.0"},
+	)
+
+	if msg.HTML == "" {
+		header = append(header,
+			headerField{"Content-Type", "text/plain; charset=utf-8"},
+			headerField{"Content-Transfer-Encoding", "quoted-printable"},
+		)
+		writeHeader(&buf, header)
+		if err = writeQuotedPrintable(&buf, msg.Text); err != nil {
+			return nil, err
+		}
+	} else {
+		mw := multipart.NewWriter(&buf)
+		header = append(header, headerField{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()})
+		writeHeader(&buf, header)
+
+		// Parts go from least to most preferred.
+		parts := []struct{ contentType, body string }{
+			{"text/plain; charset=utf-8", msg.Text},
+			{"text/html; charset=utf-8", msg.HTML},
+		}
+		for _, p := range parts {
+			if p.body == "" {
+				continue
+			}
+			w, err := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {p.contentType},
+				"Content-Transfer-Encoding": {"quoted-printable"},
+			})
+			if err != nil {
+				return nil, err
+			}
+			if err = writeQuotedPrintable(w, p.body); err != nil {
+				return nil, err
+			}
+		}
+		if err = mw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &envelope{
+		From:      from.Address,
+		To:        to.Address,
+		MessageID: messageID,
+		Data:      buf.Bytes(),
+	}, nil
+}
+
+// headerField is a header of the message. Headers are kept in a slice rather than a
+// textproto.MIMEHeader so they are written in order and with their usual casing.
+type headerField struct {
+	name, value string
+}
+
+func writeHeader(buf *bytes.Buffer, header []headerField) {
+	for _, f := range header {
+		fmt.Fprintf(buf, "%s: %s\r\n", f.name, f.value)
+	}
+	buf.WriteString("\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// newMessageID returns a unique Message-ID in the domain of the sender.
+func newMessageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain), nil
+}
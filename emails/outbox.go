@@ -0,0 +1,177 @@
+package emails
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	database "github.com/anmho/idempotent-rides/sql"
+	"time"
+)
+
+type Status string
+
+const (
+	// StatusQueued is a message that wasn't delivered yet, either because it is
+	// about to be or because the last attempt failed and will be retried.
+	StatusQueued Status = "queued"
+	// StatusSent is a message the provider accepted.
+	StatusSent Status = "sent"
+	// StatusFailed is a message that can't be sent, like one to an invalid address.
+	StatusFailed Status = "failed"
+	// StatusBounced is a message the provider rejected for good.
+	StatusBounced Status = "bounced"
+)
+
+// IsFinal reports whether a message with status s won't be sent again.
+func (s Status) IsFinal() bool {
+	return s == StatusSent || s == StatusFailed || s == StatusBounced
+}
+
+// OutboxMessage is an email as recorded in email_messages.
+type OutboxMessage struct {
+	ID        int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	UserID sql.Null[int]
+	// DedupeKey identifies a message that must be sent at most once.
+	DedupeKey sql.Null[string]
+
+	Recipient string
+	Template  string
+	Locale    string
+	Subject   string
+
+	Status            Status
+	ProviderMessageID sql.Null[string]
+	Attempts          int
+	LastError         sql.Null[string]
+	SentAt            sql.Null[time.Time]
+}
+
+type OutboxService interface {
+	// QueueMessage records msg as queued. When a message with the same dedupe key
+	// was already recorded, that message is returned unchanged instead.
+	QueueMessage(ctx context.Context, db database.DB, msg *OutboxMessage) (*OutboxMessage, error)
+	// UpdateMessage records the outcome of an attempt to deliver msg.
+	UpdateMessage(ctx context.Context, db database.DB, msg *OutboxMessage) (*OutboxMessage, error)
+	// ListUserMessages returns the latest messages sent to a user, newest first.
+	ListUserMessages(ctx context.Context, db *sql.DB, userID int, limit int) ([]*OutboxMessage, error)
+}
+
+func MakeOutboxService() OutboxService {
+	return &outboxService{}
+}
+
+var _ OutboxService = (*outboxService)(nil)
+
+type outboxService struct {
+}
+
+const outboxMessageColumns = `
+	id, created_at, updated_at,
+	user_id, dedupe_key,
+	recipient, template, locale, subject,
+	status, provider_message_id, attempts, last_error, sent_at`
+
+func scanOutboxMessage(row interface{ Scan(dest ...any) error }) (*OutboxMessage, error) {
+	var msg OutboxMessage
+	err := row.Scan(
+		&msg.ID, &msg.CreatedAt, &msg.UpdatedAt,
+		&msg.UserID, &msg.DedupeKey,
+		&msg.Recipient, &msg.Template, &msg.Locale, &msg.Subject,
+		&msg.Status, &msg.ProviderMessageID, &msg.Attempts, &msg.LastError, &msg.SentAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (s *outboxService) QueueMessage(ctx context.Context, db database.DB, msg *OutboxMessage) (*OutboxMessage, error) {
+	// The no-op update makes the conflicting row come back from RETURNING.
+	row := db.QueryRowContext(ctx,
+		`
+	INSERT INTO rocket_rides.public.email_messages (
+		user_id, dedupe_key,
+		recipient, template, locale, subject,
+		status
+	) VALUES (
+		$1, $2,
+		$3, $4, $5, $6,
+		$7
+	)
+	ON CONFLICT (dedupe_key) DO UPDATE
+	SET updated_at = email_messages.updated_at
+	RETURNING`+outboxMessageColumns+`
+	;
+	`,
+		msg.UserID, msg.DedupeKey,
+		msg.Recipient, msg.Template, msg.Locale, msg.Subject,
+		StatusQueued,
+	)
+
+	queued, err := scanOutboxMessage(row)
+	if err != nil {
+		return nil, fmt.Errorf("queueing email: %w", err)
+	}
+	return queued, nil
+}
+
+func (s *outboxService) UpdateMessage(ctx context.Context, db database.DB, msg *OutboxMessage) (*OutboxMessage, error) {
+	row := db.QueryRowContext(ctx,
+		`
+	UPDATE rocket_rides.public.email_messages
+	SET
+		updated_at = now(),
+		status = $2,
+		provider_message_id = $3,
+		attempts = $4,
+		last_error = $5,
+		sent_at = $6
+	WHERE id = $1
+	RETURNING`+outboxMessageColumns+`
+	;
+	`,
+		msg.ID,
+		msg.Status,
+		msg.ProviderMessageID,
+		msg.Attempts,
+		msg.LastError,
+		msg.SentAt,
+	)
+
+	updated, err := scanOutboxMessage(row)
+	if err != nil {
+		return nil, fmt.Errorf("updating email: %w", err)
+	}
+	return updated, nil
+}
+
+func (s *outboxService) ListUserMessages(ctx context.Context, db *sql.DB, userID int, limit int) ([]*OutboxMessage, error) {
+	rows, err := db.QueryContext(ctx,
+		`
+	SELECT`+outboxMessageColumns+`
+	FROM rocket_rides.public.email_messages
+	WHERE user_id = $1
+	ORDER BY created_at DESC, id DESC
+	LIMIT $2
+	;
+	`,
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []*OutboxMessage
+	for rows.Next() {
+		msg, err := scanOutboxMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
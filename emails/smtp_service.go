@@ -0,0 +1,124 @@
+package emails
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/anmho/idempotent-rides/scope"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+const (
+	// smtpTimeout bounds a whole delivery when ctx has no deadline.
+	smtpTimeout = 30 * time.Second
+)
+
+var _ Service = (*smtpService)(nil)
+
+// smtpService delivers messages through an SMTP relay. It upgrades the connection
+// with STARTTLS when the server supports it, and authenticates when a username is
+// configured.
+type smtpService struct {
+	cfg Config
+}
+
+// MakeSMTPService returns a Service that delivers messages through the relay in
+// cfg.SMTP.
+func MakeSMTPService(cfg Config) Service {
+	return &smtpService{cfg: cfg}
+}
+
+func (s *smtpService) Send(ctx context.Context, msg *Message) (string, error) {
+	env, err := buildEnvelope(s.cfg, msg)
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.deliver(ctx, env); err != nil {
+		return "", classifySMTPError(err)
+	}
+
+	scope.GetLogger().Info("sent email",
+		slog.String("to", env.To),
+		slog.String("messageID", env.MessageID),
+	)
+	return env.MessageID, nil
+}
+
+func (s *smtpService) deliver(ctx context.Context, env *envelope) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, smtpTimeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.cfg.SMTP.Host, s.cfg.SMTP.Port))
+	if err != nil {
+		return fmt.Errorf("dialing smtp server: %w", err)
+	}
+	deadline, _ := ctx.Deadline()
+	if err = conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, s.cfg.SMTP.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("greeting smtp server: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: s.cfg.SMTP.Host}); err != nil {
+			return fmt.Errorf("starting tls: %w", err)
+		}
+	}
+	if s.cfg.SMTP.Username != "" {
+		auth := smtp.PlainAuth("", s.cfg.SMTP.Username, s.cfg.SMTP.Password, s.cfg.SMTP.Host)
+		if err = client.Auth(auth); err != nil {
+			return fmt.Errorf("authenticating: %w", err)
+		}
+	}
+
+	if err = client.Mail(env.From); err != nil {
+		return fmt.Errorf("setting sender: %w", err)
+	}
+	if err = client.Rcpt(env.To); err != nil {
+		return fmt.Errorf("setting recipient: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("starting data: %w", err)
+	}
+	if _, err = w.Write(env.Data); err != nil {
+		return fmt.Errorf("writing data: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("finishing data: %w", err)
+	}
+	return client.Quit()
+}
+
+// classifySMTPError marks replies in the 5xx range, which the server will give again
+// for the same message, as bounces. Network errors and 4xx replies are transient,
+// and so are authentication failures, which are fixed by correcting the config rather
+// than the message.
+func classifySMTPError(err error) error {
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code < 500 || protoErr.Code >= 600 {
+		return err
+	}
+	switch protoErr.Code {
+	case 530, 534, 535, 538:
+		return err
+	default:
+		return Bounce(err)
+	}
+}
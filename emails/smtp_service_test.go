@@ -0,0 +1,192 @@
+package emails_test
+
+import (
+	"context"
+	"github.com/anmho/idempotent-rides/emails"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpReplies are the replies of a fake SMTP server to the commands of a delivery.
+// Empty replies accept the command.
+type smtpReplies struct {
+	// hangUp closes the connection instead of greeting the client.
+	hangUp bool
+	// stall never greets the client.
+	stall bool
+	rcpt  string
+	data  string
+}
+
+// serveSMTP runs a fake SMTP server that answers one delivery with replies and
+// returns its address.
+func serveSMTP(t *testing.T, replies smtpReplies) (host, port string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if replies.hangUp {
+			return
+		}
+		if replies.stall {
+			time.Sleep(time.Second)
+			return
+		}
+
+		c := textproto.NewConn(conn)
+		reply := func(custom, accepted string) {
+			if custom == "" {
+				custom = accepted
+			}
+			c.PrintfLine("%s", custom)
+		}
+		reply("", "220 localhost ESMTP")
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, _, _ := strings.Cut(strings.ToUpper(line), " ")
+			switch verb {
+			case "EHLO", "HELO", "MAIL":
+				reply("", "250 OK")
+			case "RCPT":
+				reply(replies.rcpt, "250 OK")
+			case "DATA":
+				reply("", "354 End data with <CR><LF>.<CR><LF>")
+				if _, err = c.ReadDotBytes(); err != nil {
+					return
+				}
+				reply(replies.data, "250 OK: queued")
+			case "QUIT":
+				reply("", "221 Bye")
+				return
+			default:
+				reply("", "502 Command not implemented")
+			}
+		}
+	}()
+
+	host, port, err = net.SplitHostPort(l.Addr().String())
+	require.NoError(t, err)
+	return host, port
+}
+
+func TestSMTPService_Send(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc    string
+		replies smtpReplies
+		// ctx returns the context of the delivery.
+		ctx func() (context.Context, context.CancelFunc)
+
+		expectedErr          bool
+		expectedBouncedErr   bool
+		expectedContextError error
+	}{
+		{
+			desc: "happy path: message is accepted",
+		},
+		{
+			desc:    "error path: recipient rejected with a 5xx reply. should bounce",
+			replies: smtpReplies{rcpt: "550 5.1.1 No such user"},
+
+			expectedErr:        true,
+			expectedBouncedErr: true,
+		},
+		{
+			desc:    "error path: message rejected with a 5xx reply. should bounce",
+			replies: smtpReplies{data: "552 5.3.4 Message too big"},
+
+			expectedErr:        true,
+			expectedBouncedErr: true,
+		},
+		{
+			desc:    "error path: recipient deferred with a 4xx reply. should be retried",
+			replies: smtpReplies{rcpt: "451 4.7.1 Greylisted, try again later"},
+
+			expectedErr: true,
+		},
+		{
+			desc:    "error path: message deferred with a 4xx reply. should be retried",
+			replies: smtpReplies{data: "452 4.3.1 Insufficient system storage"},
+
+			expectedErr: true,
+		},
+		{
+			desc:    "error path: server hangs up. should be retried",
+			replies: smtpReplies{hangUp: true},
+
+			expectedErr: true,
+		},
+		{
+			desc: "error path: context cancelled. should be retried",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+
+			expectedErr:          true,
+			expectedContextError: context.Canceled,
+		},
+		{
+			desc:    "error path: server never greets. should time out and be retried",
+			replies: smtpReplies{stall: true},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 100*time.Millisecond)
+			},
+
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			host, port := serveSMTP(t, tc.replies)
+			service := emails.MakeSMTPService(emails.Config{
+				From: "Rocket Rides <receipts@rocketrides.io>",
+				SMTP: emails.SMTPConfig{Host: host, Port: port},
+			})
+
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if tc.ctx != nil {
+				ctx, cancel = tc.ctx()
+			}
+			defer cancel()
+
+			messageID, err := service.Send(ctx, &emails.Message{
+				To:      "andrew@example.com",
+				Subject: "Your receipt",
+				Text:    "Thanks for riding!",
+			})
+			if !tc.expectedErr {
+				require.NoError(t, err)
+				assert.NotEmpty(t, messageID)
+				return
+			}
+
+			require.Error(t, err)
+			assert.Equal(t, tc.expectedBouncedErr, emails.IsBounced(err), "bounced: %v", err)
+			assert.Equal(t, tc.expectedBouncedErr, emails.IsPermanent(err), "permanent: %v", err)
+			if tc.expectedContextError != nil {
+				assert.ErrorIs(t, err, tc.expectedContextError)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package emails
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+)
+
+const (
+	// DefaultLocale is the locale every template must exist in. Templates that are
+	// missing from a locale are rendered in it instead.
+	DefaultLocale = "en"
+
+	subjectSuffix = ".subject.txt"
+	textSuffix    = ".txt"
+	htmlSuffix    = ".html"
+)
+
+// ErrTemplateNotFound is returned when rendering a template that doesn't exist in any
+// locale.
+var ErrTemplateNotFound = errors.New("email template not found")
+
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// Templates renders messages from named templates. A template lives in a directory per
+// locale as up to three files:
+//
+//	<locale>/<name>.subject.txt	the subject, required
+//	<locale>/<name>.txt		the plain-text body, rendered with text/template
+//	<locale>/<name>.html		the HTML body, rendered with html/template
+//
+// At least one of the bodies must exist.
+type Templates struct {
+	defaultLocale string
+	// byLocale maps each locale to its templates by name.
+	byLocale map[string]map[string]*emailTemplate
+}
+
+type emailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+// LoadTemplates returns the templates embedded in the binary.
+func LoadTemplates() (*Templates, error) {
+	fsys, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	return ParseTemplates(fsys, DefaultLocale)
+}
+
+// ParseTemplates parses the templates in fsys. Every template must exist in
+// defaultLocale, so that any locale can fall back to it.
+func ParseTemplates(fsys fs.FS, defaultLocale string) (*Templates, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("reading templates: %w", err)
+	}
+
+	t := &Templates{
+		defaultLocale: normalizeLocale(defaultLocale),
+		byLocale:      make(map[string]map[string]*emailTemplate),
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		templates, err := parseLocale(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		t.byLocale[normalizeLocale(entry.Name())] = templates
+	}
+
+	defaults, ok := t.byLocale[t.defaultLocale]
+	if !ok {
+		return nil, fmt.Errorf("no templates for default locale %s", t.defaultLocale)
+	}
+	for locale, templates := range t.byLocale {
+		for name := range templates {
+			if _, ok := defaults[name]; !ok {
+				return nil, fmt.Errorf("template %s of locale %s is missing from default locale %s", name, locale, t.defaultLocale)
+			}
+		}
+	}
+	return t, nil
+}
+
+func parseLocale(fsys fs.FS, locale string) (map[string]*emailTemplate, error) {
+	entries, err := fs.ReadDir(fsys, locale)
+	if err != nil {
+		return nil, fmt.Errorf("reading templates of locale %s: %w", locale, err)
+	}
+
+	templates := make(map[string]*emailTemplate)
+	get := func(name string) *emailTemplate {
+		if templates[name] == nil {
+			templates[name] = &emailTemplate{}
+		}
+		return templates[name]
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		file := entry.Name()
+		b, err := fs.ReadFile(fsys, path.Join(locale, file))
+		if err != nil {
+			return nil, err
+		}
+
+		// Subjects are checked first since they also end in .txt.
+		switch {
+		case strings.HasSuffix(file, subjectSuffix):
+			name := strings.TrimSuffix(file, subjectSuffix)
+			get(name).subject, err = texttemplate.New(file).Option("missingkey=error").Parse(string(b))
+		case strings.HasSuffix(file, textSuffix):
+			name := strings.TrimSuffix(file, textSuffix)
+			get(name).text, err = texttemplate.New(file).Option("missingkey=error").Parse(string(b))
+		case strings.HasSuffix(file, htmlSuffix):
+			name := strings.TrimSuffix(file, htmlSuffix)
+			get(name).html, err = htmltemplate.New(file).Option("missingkey=error").Parse(string(b))
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing template %s/%s: %w", locale, file, err)
+		}
+	}
+
+	for name, tmpl := range templates {
+		if tmpl.subject == nil {
+			return nil, fmt.Errorf("template %s/%s has no subject", locale, name)
+		}
+		if tmpl.text == nil && tmpl.html == nil {
+			return nil, fmt.Errorf("template %s/%s has no body", locale, name)
+		}
+	}
+	return templates, nil
+}
+
+// Render renders the template called name in locale with data. It falls back from a
+// regional locale like es-MX to its language, and from there to the default locale.
+// The message is returned without a recipient.
+func (t *Templates) Render(name, locale string, data any) (*Message, error) {
+	tmpl, ok := t.lookup(name, locale)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("rendering subject of %s: %w", name, err)
+	}
+	if tmpl.text != nil {
+		if err := tmpl.text.Execute(&text, data); err != nil {
+			return nil, fmt.Errorf("rendering text of %s: %w", name, err)
+		}
+	}
+	if tmpl.html != nil {
+		if err := tmpl.html.Execute(&html, data); err != nil {
+			return nil, fmt.Errorf("rendering html of %s: %w", name, err)
+		}
+	}
+
+	return &Message{
+		// Subject files usually end in a newline, which isn't part of the subject.
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+func (t *Templates) lookup(name, locale string) (*emailTemplate, bool) {
+	locale = normalizeLocale(locale)
+	candidates := []string{locale}
+	if lang, _, ok := strings.Cut(locale, "-"); ok {
+		candidates = append(candidates, lang)
+	}
+	candidates = append(candidates, t.defaultLocale)
+
+	for _, candidate := range candidates {
+		if tmpl, ok := t.byLocale[candidate][name]; ok {
+			return tmpl, true
+		}
+	}
+	return nil, false
+}
+
+// Names returns the names of the templates, sorted.
+func (t *Templates) Names() []string {
+	return sortedKeys(t.byLocale[t.defaultLocale])
+}
+
+// Locales returns the locales that have templates, sorted.
+func (t *Templates) Locales() []string {
+	return sortedKeys(t.byLocale)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// normalizeLocale turns tags like pt_BR and PT-br into pt-br.
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
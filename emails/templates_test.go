@@ -0,0 +1,136 @@
+package emails_test
+
+import (
+	"github.com/anmho/idempotent-rides/emails"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+)
+
+func TestTemplates_Render(t *testing.T) {
+	fsys := fstest.MapFS{
+		"en/welcome.subject.txt": {Data: []byte("Welcome, {{.Name}}\n")},
+		"en/welcome.txt":         {Data: []byte("Hi {{.Name}}")},
+		"en/welcome.html":        {Data: []byte("<p>Hi {{.Name}}</p>")},
+		"es/welcome.subject.txt": {Data: []byte("Bienvenido, {{.Name}}")},
+		"es/welcome.txt":         {Data: []byte("Hola {{.Name}}")},
+	}
+	templates, err := emails.ParseTemplates(fsys, "en")
+	require.NoError(t, err)
+
+	tests := []struct {
+		desc   string
+		name   string
+		locale string
+		data   any
+
+		expectedMsg *emails.Message
+		expectedErr error
+	}{
+		{
+			desc:   "happy path: default locale",
+			name:   "welcome",
+			locale: "en",
+			data:   map[string]any{"Name": "<Andrew>"},
+
+			expectedMsg: &emails.Message{
+				Subject: "Welcome, <Andrew>",
+				Text:    "Hi <Andrew>",
+				HTML:    "<p>Hi &lt;Andrew&gt;</p>",
+			},
+		},
+		{
+			desc:   "happy path: regional locale falls back to its language",
+			name:   "welcome",
+			locale: "es_MX",
+			data:   map[string]any{"Name": "Andrew"},
+
+			expectedMsg: &emails.Message{
+				Subject: "Bienvenido, Andrew",
+				Text:    "Hola Andrew",
+			},
+		},
+		{
+			desc:   "happy path: unknown locale falls back to the default locale",
+			name:   "welcome",
+			locale: "fr",
+			data:   map[string]any{"Name": "Andrew"},
+
+			expectedMsg: &emails.Message{
+				Subject: "Welcome, Andrew",
+				Text:    "Hi Andrew",
+				HTML:    "<p>Hi Andrew</p>",
+			},
+		},
+		{
+			desc:   "error path: unknown template",
+			name:   "goodbye",
+			locale: "en",
+
+			expectedErr: emails.ErrTemplateNotFound,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			msg, err := templates.Render(tc.name, tc.locale, tc.data)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedMsg, msg)
+		})
+	}
+}
+
+func TestParseTemplates(t *testing.T) {
+	tests := []struct {
+		desc string
+		fsys fstest.MapFS
+	}{
+		{
+			desc: "error path: template missing from the default locale",
+			fsys: fstest.MapFS{
+				"en/welcome.subject.txt": {Data: []byte("Welcome")},
+				"en/welcome.txt":         {Data: []byte("Hi")},
+				"es/goodbye.subject.txt": {Data: []byte("Adiós")},
+				"es/goodbye.txt":         {Data: []byte("Adiós")},
+			},
+		},
+		{
+			desc: "error path: template without a subject",
+			fsys: fstest.MapFS{
+				"en/welcome.txt": {Data: []byte("Hi")},
+			},
+		},
+		{
+			desc: "error path: template without a body",
+			fsys: fstest.MapFS{
+				"en/welcome.subject.txt": {Data: []byte("Welcome")},
+			},
+		},
+		{
+			desc: "error path: no default locale",
+			fsys: fstest.MapFS{
+				"es/welcome.subject.txt": {Data: []byte("Bienvenido")},
+				"es/welcome.txt":         {Data: []byte("Hola")},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			_, err := emails.ParseTemplates(tc.fsys, "en")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	templates, err := emails.LoadTemplates()
+	require.NoError(t, err)
+	assert.Contains(t, templates.Names(), "ride_receipt")
+	assert.Contains(t, templates.Locales(), emails.DefaultLocale)
+}
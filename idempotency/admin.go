@@ -0,0 +1,51 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrKeyFinished is returned when repairing a key that has already finished.
+var ErrKeyFinished = errors.New("idempotency key is already finished")
+
+// ForceUnlock releases the lock on the key with keyID in tx, no matter how recently
+// it was taken. It lets operators resume a key whose request is known to be dead
+// before the lock times out.
+func ForceUnlock(ctx context.Context, store KeyStore, tx Tx, keyID int) (*Key, error) {
+	key, err := store.FindKeyByID(ctx, tx, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("finding key: %w", err)
+	}
+
+	unlockedKey := new(Key)
+	*unlockedKey = *key
+	unlockedKey.LockedAt = sql.Null[time.Time]{}
+	return store.UpdateKey(ctx, tx, unlockedKey)
+}
+
+// ForceFinish finishes the unfinished key with keyID in tx with result as its stored
+// response, without running its remaining phases. The transition is recorded in the
+// key's timeline like any other.
+func ForceFinish(ctx context.Context, store KeyStore, tx Tx, keyID int, result *ResponseResult) (*Key, error) {
+	key, err := store.FindKeyByID(ctx, tx, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("finding key: %w", err)
+	}
+	if key.RecoveryPoint == FinishedRecoveryPoint {
+		return nil, ErrKeyFinished
+	}
+
+	finishedKey, err := result.UpdateKeyForNextPhase(ctx, store, tx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	transition := newTransition(key, finishedKey.RecoveryPoint, time.Now(), 1, nil)
+	if _, err = store.InsertTransition(ctx, tx, transition); err != nil {
+		return nil, err
+	}
+	return finishedKey, nil
+}
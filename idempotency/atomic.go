@@ -8,27 +8,27 @@ import (
 	"fmt"
 	"github.com/anmho/idempotent-rides/scope"
 	"log/slog"
+	"net/http"
 	"time"
 )
 
+// AtomicPhaseResult moves a key to its next recovery point. Results don't carry the
+// data a phase created: it is committed in the same transaction as the key, and
+// later phases load it again so they can resume in a fresh request or process.
 type AtomicPhaseResult interface {
-	UpdateKeyForNextPhase(ctx context.Context, tx *sql.Tx, key *Key) (*Key, error)
+	UpdateKeyForNextPhase(ctx context.Context, store KeyStore, tx Tx, key *Key) (*Key, error)
 }
 
 var _ AtomicPhaseResult = (*NoOpResult)(nil)
 
-// TODO Update these models to return the relevant data from the previous phase
-
 type NoOpResult struct{}
 
-func (r *NoOpResult) UpdateKeyForNextPhase(ctx context.Context, tx *sql.Tx, key *Key) (*Key, error) {
-	return nil, nil
+func (r *NoOpResult) UpdateKeyForNextPhase(ctx context.Context, store KeyStore, tx Tx, key *Key) (*Key, error) {
+	return key, nil
 }
 
 var _ AtomicPhaseResult = (*RecoveryPointResult)(nil)
 
-// TODO Update these models to return the relevant data from the previous phase
-
 // RecoveryPointResult represents an action to set a new recovery point. One possible option for a
 // return from a #atomic_phase block.
 type RecoveryPointResult struct {
@@ -41,7 +41,7 @@ func NewRecoveryPointResult(recoveryPoint RecoveryPointEnum) *RecoveryPointResul
 	}
 }
 
-func (r *RecoveryPointResult) UpdateKeyForNextPhase(ctx context.Context, tx *sql.Tx, key *Key) (*Key, error) {
+func (r *RecoveryPointResult) UpdateKeyForNextPhase(ctx context.Context, store KeyStore, tx Tx, key *Key) (*Key, error) {
 	if key == nil {
 		return nil, errors.New("nil key in update")
 	}
@@ -49,23 +49,25 @@ func (r *RecoveryPointResult) UpdateKeyForNextPhase(ctx context.Context, tx *sql
 	*newKey = *key
 	newKey.RecoveryPoint = r.RecoveryPoint
 
-	return newKey, nil
+	return store.UpdateKey(ctx, tx, newKey)
 }
 
 var _ AtomicPhaseResult = (*ResponseResult)(nil)
 
-// TODO Update these models to return the relevant data from the previous phase
-
+// ResponseResult finishes a key with a response that is replayed byte-for-byte on
+// every retry. Data is encoded as JSON; Headers are stored with it, and Content-Type
+// defaults to application/json.
 type ResponseResult struct {
-	Status int
-	Data   any
+	Status  int
+	Headers http.Header
+	Data    any
 }
 
 func NewResponseResult(status int, data any) *ResponseResult {
 	return &ResponseResult{Status: status, Data: data}
 }
 
-func (r *ResponseResult) UpdateKeyForNextPhase(ctx context.Context, tx *sql.Tx, key *Key) (*Key, error) {
+func (r *ResponseResult) UpdateKeyForNextPhase(ctx context.Context, store KeyStore, tx Tx, key *Key) (*Key, error) {
 	newKey := new(Key)
 	*newKey = *key
 	newKey.LockedAt = sql.Null[time.Time]{
@@ -76,6 +78,14 @@ func (r *ResponseResult) UpdateKeyForNextPhase(ctx context.Context, tx *sql.Tx,
 		V:     r.Status,
 		Valid: true,
 	}
+	headers := r.Headers.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	if headers.Get("Content-Type") == "" {
+		headers.Set("Content-Type", "application/json")
+	}
+	newKey.ResponseHeaders = ResponseHeaders(headers)
 	if r.Data != nil {
 		b, err := json.Marshal(r.Data)
 		if err != nil {
@@ -87,58 +97,134 @@ func (r *ResponseResult) UpdateKeyForNextPhase(ctx context.Context, tx *sql.Tx,
 		}
 	}
 
-	return UpdateKey(ctx, tx, newKey)
+	return store.UpdateKey(ctx, tx, newKey)
 }
 
 type BlockFunc func(tx *sql.Tx) (AtomicPhaseResult, error)
 
-func AtomicPhase(ctx context.Context, key *Key, db *sql.DB, block BlockFunc) (*Key, error) {
-	tx, err := db.BeginTx(ctx, &sql.TxOptions{
-		Isolation: sql.LevelSerializable,
-		ReadOnly:  false,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to start transaction: %w", err)
+// AtomicPhase runs block and moves key to the recovery point of its result in a
+// single serializable transaction, which also records the transition in the key's
+// timeline. Serialization failures and deadlocks are retried according to cfg. If
+// block fails with a *TerminalError the key is finished with the error as its
+// response. If it fails with any other error the failed transition is recorded and
+// the key is unlocked so that another request can resume it.
+func AtomicPhase(ctx context.Context, key *Key, store KeyStore, cfg Config, block BlockFunc) (*Key, error) {
+	if key == nil {
+		return nil, errors.New("nil idempotency key in atomic phase")
 	}
-	defer func(tx *sql.Tx) {
-		err := tx.Rollback()
-		if err != nil && !errors.Is(err, sql.ErrTxDone) {
-			scope.GetLogger().Error("failed to rollback", slog.Any("cause", err))
-		}
-	}(tx)
 
-	result, err := block(tx)
-	var updatedKey *Key
-	if err == nil {
-		switch result.(type) {
-		case *NoOpResult, *RecoveryPointResult, *ResponseResult:
-			updatedKey, err = result.UpdateKeyForNextPhase(ctx, tx, key)
-		default:
-			err = errors.New("invalid atomic result type")
-		}
-		err = tx.Commit()
+	started := time.Now()
+	var attempts int
+	updatedKey, err := withRetries(ctx, cfg, "atomic_phase", func() (*Key, error) {
+		attempts++
+		return atomicPhaseOnce(ctx, key, store, block, started, attempts, nil)
+	})
+
+	var terminalErr *TerminalError
+	if errors.As(err, &terminalErr) {
+		scope.GetLogger().Info("atomic phase failed with terminal error",
+			slog.Any("cause", terminalErr.Cause),
+			slog.Int("status", terminalErr.Status),
+		)
+		updatedKey, err = withRetries(ctx, cfg, "atomic_phase_terminal", func() (*Key, error) {
+			attempts++
+			return atomicPhaseOnce(ctx, key, store, func(tx *sql.Tx) (AtomicPhaseResult, error) {
+				return NewResponseResult(terminalErr.Status, terminalErr.Body), nil
+			}, started, attempts, terminalErr)
+		})
 	}
 
 	if err != nil {
 		scope.GetLogger().Error("atomic phase transaction", slog.Any("cause", err), slog.Any("idempotencyKey", key))
-		if updatedKey != nil {
-			// If we're leaving under an error condition, try to unlock the idempotency
-			// key right away so that another request can try again.
-			// release the idempotency key lock
-
-			_ = tx.Rollback()
-			updatedKey.LockedAt = sql.Null[time.Time]{}
-			_, err = UpdateKey(ctx, tx, updatedKey)
-			if err != nil {
-				return nil, err
-			}
-			// this needs to be committed
-			err = tx.Commit()
-			if err != nil {
-				scope.GetLogger().Error("atomic phase attempt to unlock", slog.Any("error", err))
+		recordFailedTransition(ctx, store, newTransition(key, key.RecoveryPoint, started, attempts, err))
+		// If we're leaving under an error condition, try to unlock the idempotency
+		// key right away so that another request can try again. The unlock must
+		// happen even when the request was canceled, which is a common reason to
+		// leave.
+		if key.LockedAt.Valid {
+			if unlockErr := store.UnlockKey(context.WithoutCancel(ctx), key.ID, key.LockedAt.V); unlockErr != nil {
+				scope.GetLogger().Error("atomic phase attempt to unlock", slog.Any("error", unlockErr))
 			}
 		}
 		return nil, err
 	}
 	return updatedKey, nil
 }
+
+// atomicPhaseOnce runs block in a single transaction. cause is the error recorded with
+// the transition, if any. key is what the request last read, so it is read again
+// before block runs: a retried attempt must not run a phase that another request has
+// taken over or moved past since.
+func atomicPhaseOnce(ctx context.Context, key *Key, store KeyStore, block BlockFunc, started time.Time, attempts int, cause error) (*Key, error) {
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rollback(tx)
+
+	current, err := store.FindKeyByID(ctx, tx, key.ID)
+	if err != nil {
+		return nil, fmt.Errorf("reloading key: %w", err)
+	}
+	if current.RecoveryPoint != key.RecoveryPoint || !sameLock(current.LockedAt, key.LockedAt) {
+		return nil, ErrLockLost
+	}
+
+	result, err := block(tx.SQL())
+	if err != nil {
+		return nil, err
+	}
+
+	var updatedKey *Key
+	switch result.(type) {
+	case *NoOpResult, *RecoveryPointResult, *ResponseResult:
+		updatedKey, err = result.UpdateKeyForNextPhase(ctx, store, tx, key)
+	default:
+		err = errors.New("invalid atomic result type")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	transition := newTransition(key, updatedKey.RecoveryPoint, started, attempts, cause)
+	if _, err = store.InsertTransition(ctx, tx, transition); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return updatedKey, nil
+}
+
+func sameLock(a, b sql.Null[time.Time]) bool {
+	return a.Valid == b.Valid && a.V.Equal(b.V)
+}
+
+// recordFailedTransition records a phase that failed in a transaction of its own,
+// since the phase's transaction was rolled back. Failing to record it only loses
+// debugging information, so errors are logged.
+func recordFailedTransition(ctx context.Context, store KeyStore, transition *Transition) {
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		scope.GetLogger().Error("failed to record transition", slog.Any("cause", err))
+		return
+	}
+	defer rollback(tx)
+
+	if _, err = store.InsertTransition(ctx, tx, transition); err != nil {
+		scope.GetLogger().Error("failed to record transition", slog.Any("cause", err))
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		scope.GetLogger().Error("failed to record transition", slog.Any("cause", err))
+	}
+}
+
+// rollback is deferred after Begin. It is a no-op once the transaction has committed.
+func rollback(tx Tx) {
+	err := tx.Rollback()
+	if err != nil && !errors.Is(err, sql.ErrTxDone) {
+		scope.GetLogger().Error("failed to rollback", slog.Any("cause", err))
+	}
+}
@@ -0,0 +1,179 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/anmho/idempotent-rides/scope"
+	"log/slog"
+	"time"
+)
+
+const (
+	// DefaultCompleterThreshold is how long a key must have gone without a run
+	// before the completer assumes its client gave up on it.
+	DefaultCompleterThreshold = 5 * time.Minute
+	// DefaultCompleterBatchSize bounds how many keys are completed per pass.
+	DefaultCompleterBatchSize = 100
+	// DefaultCompleterInterval is how often a long-running completer wakes up.
+	DefaultCompleterInterval = time.Minute
+)
+
+// CompleterConfig controls which abandoned keys are picked up by the completer.
+type CompleterConfig struct {
+	Threshold time.Duration
+	BatchSize int
+	Interval  time.Duration
+	// Lock is the locking config used by the API, so the completer respects the
+	// same lock timeout as client retries.
+	Lock Config
+}
+
+// Completer drives keys that were abandoned by their clients before reaching
+// FinishedRecoveryPoint. It runs the rest of their workflow from the stored request
+// params, exactly as a client retry with the same key would.
+type Completer struct {
+	store    KeyStore
+	cfg      CompleterConfig
+	registry *Registry
+}
+
+func MakeCompleter(store KeyStore, cfg CompleterConfig, registry *Registry) *Completer {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = DefaultCompleterThreshold
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultCompleterBatchSize
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultCompleterInterval
+	}
+	return &Completer{store: store, cfg: cfg, registry: registry}
+}
+
+// Run completes abandoned keys every interval until ctx is cancelled.
+func (c *Completer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := c.CompleteOnce(ctx); err != nil {
+			scope.GetLogger().Error("completing idempotency keys", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// CompleteOnce runs one batch of abandoned keys to completion and returns how many
+// were finished. A key that fails or is locked is left for the next pass. Keys that
+// have used up their attempts are left for an operator, including keys whose
+// workflow can't be built from their stored request.
+func (c *Completer) CompleteOnce(ctx context.Context) (int, error) {
+	now := time.Now()
+	keys, err := c.store.FindAbandonedKeys(ctx,
+		now.Add(-c.cfg.Threshold),
+		now.Add(-c.cfg.Lock.lockTimeout()),
+		c.cfg.Lock.maxAttempts(),
+		c.cfg.BatchSize,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("finding abandoned keys: %w", err)
+	}
+
+	var completed int
+	for _, key := range keys {
+		if err := c.complete(ctx, key); err != nil {
+			scope.GetLogger().Error("completing idempotency key",
+				slog.Int("keyID", key.ID),
+				slog.String("recoveryPoint", key.RecoveryPoint.String()),
+				slog.Any("error", err),
+			)
+			continue
+		}
+		completed++
+	}
+
+	scope.GetLogger().Info("completed idempotency keys",
+		slog.Int("count", completed),
+		slog.Int("candidates", len(keys)),
+	)
+	return completed, nil
+}
+
+func (c *Completer) complete(ctx context.Context, key *Key) error {
+	started := time.Now()
+	workflow, err := c.workflow(key)
+	if err != nil {
+		// Without a workflow the key can't make progress, so it has to use up an
+		// attempt. Otherwise it would be found again on every pass and crowd out
+		// the keys that can be completed.
+		if failErr := c.fail(ctx, key, started, err); failErr != nil {
+			return errors.Join(err, failErr)
+		}
+		return err
+	}
+
+	_, _, err = Handle(ctx, c.store, c.cfg.Lock, KeyParams{
+		Key:           key.Key,
+		RequestMethod: key.RequestMethod,
+		RequestParams: key.RequestParams,
+		RequestPath:   key.RequestPath,
+		UserID:        key.UserID,
+	}, workflow)
+	return err
+}
+
+func (c *Completer) workflow(key *Key) (*Workflow, error) {
+	workflowFunc, ok := c.registry.Lookup(key.RequestMethod, key.RequestPath)
+	if !ok {
+		return nil, fmt.Errorf("no workflow registered for %s %s", key.RequestMethod, key.RequestPath)
+	}
+
+	workflow, err := workflowFunc(key.RequestParams)
+	if err != nil {
+		return nil, fmt.Errorf("building workflow: %w", err)
+	}
+	return workflow, nil
+}
+
+// fail counts a run of key that failed before any phase could run and records it in
+// the key's timeline. The key is left unlocked at its recovery point, so an API that
+// knows the route can still resume it until it runs out of attempts.
+func (c *Completer) fail(ctx context.Context, key *Key, started time.Time, cause error) error {
+	tx, err := c.store.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer rollback(tx)
+
+	current, err := c.store.FindKeyByID(ctx, tx, key.ID)
+	if err != nil {
+		return fmt.Errorf("finding key: %w", err)
+	}
+	if current.RecoveryPoint == FinishedRecoveryPoint {
+		return nil
+	}
+	lockedKey, err := lockKey(ctx, c.store, tx, c.cfg.Lock, current)
+	if err != nil {
+		return err
+	}
+	lockedKey.LockedAt = sql.Null[time.Time]{}
+	if _, err = c.store.UpdateKey(ctx, tx, lockedKey); err != nil {
+		return err
+	}
+
+	transition := newTransition(current, current.RecoveryPoint, started, 1, cause)
+	if _, err = c.store.InsertTransition(ctx, tx, transition); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
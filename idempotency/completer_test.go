@@ -0,0 +1,95 @@
+package idempotency_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCompleter_CompleteOnce(t *testing.T) {
+	t.Parallel()
+
+	finish := func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+		return idempotency.NewResponseResult(http.StatusCreated, map[string]any{}), nil
+	}
+	ridesRegistry := idempotency.MakeRegistry()
+	ridesRegistry.Register(http.MethodPost, "/rides", func(params []byte) (*idempotency.Workflow, error) {
+		return idempotency.MakeWorkflow("finish_rides").
+			Phase(idempotency.StartedRecoveryPoint, finish, idempotency.FinishedRecoveryPoint).
+			Phase(idempotency.RideCreatedRecoveryPoint, finish, idempotency.FinishedRecoveryPoint).
+			Phase(idempotency.ChargeCreatedRecoveryPoint, finish, idempotency.FinishedRecoveryPoint), nil
+	})
+
+	unparseableRegistry := idempotency.MakeRegistry()
+	unparseableRegistry.Register(http.MethodPost, "/rides", func(params []byte) (*idempotency.Workflow, error) {
+		return nil, errors.New("invalid params")
+	})
+
+	tests := []struct {
+		desc     string
+		cfg      idempotency.CompleterConfig
+		registry *idempotency.Registry
+
+		expectedCompleted int
+	}{
+		{
+			desc: "happy path: every unfinished seeded key is completed",
+			cfg: idempotency.CompleterConfig{
+				Threshold: time.Nanosecond,
+				Lock:      idempotency.Config{LockTimeout: time.Nanosecond},
+			},
+			registry: ridesRegistry,
+
+			expectedCompleted: 3,
+		},
+		{
+			desc: "happy path: keys that ran recently are left to their clients",
+			cfg: idempotency.CompleterConfig{
+				Threshold: time.Hour,
+				Lock:      idempotency.Config{LockTimeout: time.Nanosecond},
+			},
+			registry: ridesRegistry,
+
+			expectedCompleted: 0,
+		},
+		{
+			desc: "error path: keys for unregistered routes are skipped",
+			cfg: idempotency.CompleterConfig{
+				Threshold: time.Nanosecond,
+				Lock:      idempotency.Config{LockTimeout: time.Nanosecond},
+			},
+			registry: idempotency.MakeRegistry(),
+
+			expectedCompleted: 0,
+		},
+		{
+			desc: "error path: keys whose params can't be parsed are skipped",
+			cfg: idempotency.CompleterConfig{
+				Threshold: time.Nanosecond,
+				Lock:      idempotency.Config{LockTimeout: time.Nanosecond},
+			},
+			registry: unparseableRegistry,
+
+			expectedCompleted: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			db := test.MakePostgres(t)
+			ctx := context.Background()
+
+			completer := idempotency.MakeCompleter(idempotency.MakePostgresStore(db), tc.cfg, tc.registry)
+			n, err := completer.CompleteOnce(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedCompleted, n)
+		})
+	}
+}
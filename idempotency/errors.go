@@ -0,0 +1,49 @@
+package idempotency
+
+import "fmt"
+
+// TerminalError is returned by a phase when the request can never succeed, like a
+// declined card. Instead of unlocking the key, AtomicPhase rolls the phase back and
+// finishes the key with Status and Body as its stored response, so that retries
+// replay the failure rather than running the phase again.
+type TerminalError struct {
+	Status int
+	Body   any
+	Cause  error
+}
+
+var _ error = (*TerminalError)(nil)
+
+func NewTerminalError(status int, body any, cause error) *TerminalError {
+	return &TerminalError{Status: status, Body: body, Cause: cause}
+}
+
+func (e *TerminalError) Error() string {
+	return fmt.Sprintf("terminal error with status %d: %v", e.Status, e.Cause)
+}
+
+func (e *TerminalError) Unwrap() error {
+	return e.Cause
+}
+
+// RetryableError is returned by a phase that failed for a transient reason, like a
+// network timeout. The key is unlocked at its current recovery point so that a retry
+// with the same key resumes the phase. Any error that is not a TerminalError is
+// treated as retryable; wrapping it lets callers tell the client to try again.
+type RetryableError struct {
+	Cause error
+}
+
+var _ error = (*RetryableError)(nil)
+
+func Retryable(cause error) *RetryableError {
+	return &RetryableError{Cause: cause}
+}
+
+func (e *RetryableError) Error() string {
+	return fmt.Sprintf("retryable error: %v", e.Cause)
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Cause
+}
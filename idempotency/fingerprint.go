@@ -0,0 +1,56 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrKeyReused is returned when a client reuses an idempotency key for a request
+// that does not match the one the key was first used with.
+var ErrKeyReused = errors.New("idempotency key reused with different parameters")
+
+// Fingerprint returns a canonical hash of JSON encoded request params. Postgres
+// stores params as JSONB, which reorders keys and drops whitespace, so the raw
+// bytes can't be compared directly.
+func Fingerprint(params []byte) (string, error) {
+	var v any
+	if err := json.Unmarshal(params, &v); err != nil {
+		return "", fmt.Errorf("decoding params: %w", err)
+	}
+
+	// encoding/json sorts map keys, which gives us a canonical encoding.
+	canonical, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("encoding params: %w", err)
+	}
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checkKeyMatches verifies that the request described by params is the same one the
+// key was created for.
+func checkKeyMatches(key *Key, params KeyParams) error {
+	if key.RequestMethod != params.RequestMethod {
+		return fmt.Errorf("%w: method %s does not match %s", ErrKeyReused, params.RequestMethod, key.RequestMethod)
+	}
+	if key.RequestPath != params.RequestPath {
+		return fmt.Errorf("%w: path %s does not match %s", ErrKeyReused, params.RequestPath, key.RequestPath)
+	}
+
+	stored, err := Fingerprint(key.RequestParams)
+	if err != nil {
+		return err
+	}
+	incoming, err := Fingerprint(params.RequestParams)
+	if err != nil {
+		return err
+	}
+	if stored != incoming {
+		return fmt.Errorf("%w: request params do not match", ErrKeyReused)
+	}
+	return nil
+}
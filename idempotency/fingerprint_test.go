@@ -0,0 +1,56 @@
+package idempotency_test
+
+import (
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func Test_Fingerprint(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc  string
+		a     string
+		b     string
+		equal bool
+	}{
+		{
+			desc:  "happy path: identical params have the same fingerprint",
+			a:     `{"user_id": 123, "origin": {"Lat": 1, "Long": 2}}`,
+			b:     `{"user_id": 123, "origin": {"Lat": 1, "Long": 2}}`,
+			equal: true,
+		},
+		{
+			desc:  "happy path: key order and whitespace are ignored like in JSONB",
+			a:     `{"user_id":123,"origin":{"Lat":1,"Long":2}}`,
+			b:     `{"origin": {"Long": 2.0, "Lat": 1}, "user_id": 123}`,
+			equal: true,
+		},
+		{
+			desc:  "error path: different values have different fingerprints",
+			a:     `{"user_id": 123, "origin": {"Lat": 1, "Long": 2}}`,
+			b:     `{"user_id": 123, "origin": {"Lat": 1, "Long": 3}}`,
+			equal: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			a, err := idempotency.Fingerprint([]byte(tc.a))
+			require.NoError(t, err)
+			b, err := idempotency.Fingerprint([]byte(tc.b))
+			require.NoError(t, err)
+
+			if tc.equal {
+				assert.Equal(t, a, b)
+			} else {
+				assert.NotEqual(t, a, b)
+			}
+		})
+	}
+
+	_, err := idempotency.Fingerprint([]byte("not json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,71 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Handle upserts the idempotency key described by params and runs the remaining
+// phases of workflow until the key reaches FinishedRecoveryPoint. A key that is
+// already finished is returned as is with replayed set, so the caller can replay the
+// stored response and tell clients it did. Reusing a key for a different request fails with ErrKeyReused, and a key that is
+// still locked by another request fails with a *LockedError.
+func Handle(ctx context.Context, store KeyStore, cfg Config, params KeyParams, workflow *Workflow) (key *Key, replayed bool, err error) {
+	key, err = upsertKey(ctx, store, cfg, params)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to upsert idempotency key: %w", err)
+	}
+	if key.RecoveryPoint == FinishedRecoveryPoint {
+		return key, true, nil
+	}
+
+	key, err = workflow.Run(ctx, store, cfg, key)
+	return key, false, err
+}
+
+// upsertKey finds the key for the user or inserts a new one at StartedRecoveryPoint.
+// Either way the returned key is locked by this request unless it is already finished.
+// Duplicate requests racing to insert the same key are retried like any other
+// serialization failure.
+func upsertKey(ctx context.Context, store KeyStore, cfg Config, params KeyParams) (*Key, error) {
+	return withRetries(ctx, cfg, "upsert_key", func() (*Key, error) {
+		return upsertKeyOnce(ctx, store, cfg, params)
+	})
+}
+
+func upsertKeyOnce(ctx context.Context, store KeyStore, cfg Config, params KeyParams) (*Key, error) {
+	tx, err := store.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rollback(tx)
+
+	key, err := store.FindKey(ctx, tx, params.UserID, params.Key)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("error finding key: %w", err)
+		}
+
+		key, err = store.InsertKey(ctx, tx, params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add new key: %w", err)
+		}
+	} else {
+		if err = checkKeyMatches(key, params); err != nil {
+			return nil, err
+		}
+		if key.RecoveryPoint != FinishedRecoveryPoint {
+			key, err = lockKey(ctx, store, tx, cfg, key)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
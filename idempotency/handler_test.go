@@ -0,0 +1,112 @@
+package idempotency_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_HandleLocking(t *testing.T) {
+	t.Parallel()
+
+	finish := idempotency.MakeWorkflow("finish").
+		Phase(idempotency.StartedRecoveryPoint, func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+			return idempotency.NewResponseResult(http.StatusCreated, map[string]any{}), nil
+		}, idempotency.FinishedRecoveryPoint)
+
+	tests := []struct {
+		desc string
+		cfg  idempotency.Config
+
+		expectedLocked bool
+	}{
+		{
+			desc: "error path: key was locked when it was seeded and the lock has not timed out",
+			cfg:  idempotency.Config{LockTimeout: time.Hour},
+
+			expectedLocked: true,
+		},
+		{
+			desc: "happy path: stale lock is taken over and the request finishes",
+			cfg:  idempotency.Config{LockTimeout: time.Nanosecond},
+
+			expectedLocked: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			db := test.MakePostgres(t)
+			ctx := context.Background()
+
+			key, _, err := idempotency.Handle(ctx, idempotency.MakePostgresStore(db), tc.cfg, idempotency.KeyParams{
+				Key:           TestKeyStarted.Key,
+				RequestMethod: http.MethodPost,
+				RequestParams: []byte("{}"),
+				RequestPath:   "/rides",
+				UserID:        TestUserID,
+			}, finish)
+
+			if tc.expectedLocked {
+				var lockedErr *idempotency.LockedError
+				require.ErrorAs(t, err, &lockedErr)
+				assert.ErrorIs(t, err, idempotency.ErrKeyLocked)
+				assert.Positive(t, lockedErr.RetryAfter)
+				assert.Nil(t, key)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, idempotency.FinishedRecoveryPoint, key.RecoveryPoint)
+				assert.False(t, key.LockedAt.Valid)
+			}
+		})
+	}
+}
+
+func Test_HandleTerminalError(t *testing.T) {
+	t.Parallel()
+
+	declined := idempotency.MakeWorkflow("declined").
+		Phase(idempotency.StartedRecoveryPoint, func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+			return nil, idempotency.NewTerminalError(http.StatusPaymentRequired, map[string]any{"code": "card_declined"}, errors.New("card declined"))
+		}, idempotency.FinishedRecoveryPoint)
+
+	db := test.MakePostgres(t)
+	ctx := context.Background()
+	params := idempotency.KeyParams{
+		Key:           "declinedKey",
+		RequestMethod: http.MethodPost,
+		RequestParams: []byte("{}"),
+		RequestPath:   "/rides",
+		UserID:        TestUserID,
+	}
+
+	// The first request finishes the key with the terminal error, and the retry
+	// replays it without running the phase again.
+	store := idempotency.MakePostgresStore(db)
+	var keyID int
+	for i := range 2 {
+		key, replayed, err := idempotency.Handle(ctx, store, idempotency.Config{}, params, declined)
+		require.NoError(t, err)
+		assert.Equal(t, i > 0, replayed)
+		assert.Equal(t, "application/json", http.Header(key.ResponseHeaders).Get("Content-Type"))
+		assert.Equal(t, idempotency.FinishedRecoveryPoint, key.RecoveryPoint)
+		assert.Equal(t, sql.Null[int]{V: http.StatusPaymentRequired, Valid: true}, key.ResponseCode)
+		assert.JSONEq(t, `{"code": "card_declined"}`, string(key.ResponseBody.V))
+		assert.False(t, key.LockedAt.Valid)
+		keyID = key.ID
+	}
+
+	transitions, err := store.FindTransitions(ctx, keyID)
+	require.NoError(t, err)
+	require.Len(t, transitions, 1)
+	assert.Equal(t, idempotency.StartedRecoveryPoint, transitions[0].From)
+	assert.Equal(t, idempotency.FinishedRecoveryPoint, transitions[0].To)
+	assert.Contains(t, transitions[0].Error.V, "card declined")
+}
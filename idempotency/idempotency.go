@@ -4,29 +4,36 @@ import "net/http"
 
 const (
 	HeaderKey = "Idempotency-Key"
+	// HeaderReplayed is set to "true" on responses replayed from a finished key.
+	HeaderReplayed = "Idempotent-Replayed"
 )
 
+// RecoveryPointEnum names a point in a Workflow that a key can resume from. Every
+// workflow starts at StartedRecoveryPoint and ends at FinishedRecoveryPoint; the
+// points in between are defined by the workflow itself.
 type RecoveryPointEnum string
 
 const (
-	StartedRecoveryPoint       RecoveryPointEnum = "started"
-	RideCreatedRecoveryPoint                     = "ride_created"
-	ChargeCreatedRecoveryPoint                   = "charge_created"
-	FinishedRecoveryPoint                        = "finished"
+	StartedRecoveryPoint  RecoveryPointEnum = "started"
+	FinishedRecoveryPoint RecoveryPointEnum = "finished"
+)
+
+// Recovery points of the ride reservation workflow.
+const (
+	RideCreatedRecoveryPoint   RecoveryPointEnum = "ride_created"
+	ChargeCreatedRecoveryPoint RecoveryPointEnum = "charge_created"
+)
+
+const (
+	// MaxRecoveryPointLength matches the limit on idempotency_keys.recovery_point.
+	MaxRecoveryPointLength = 50
 )
 
 func (rp RecoveryPointEnum) String() string {
 	return string(rp)
 }
 func (rp RecoveryPointEnum) IsValid() bool {
-	switch rp {
-	case StartedRecoveryPoint, RideCreatedRecoveryPoint,
-		ChargeCreatedRecoveryPoint,
-		FinishedRecoveryPoint:
-		return true
-	default:
-		return false
-	}
+	return len(rp) > 0 && len(rp) <= MaxRecoveryPointLength
 }
 
 type RequestMethod string
@@ -3,11 +3,16 @@ package idempotency
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"time"
 )
 
+// Key is an idempotency key supplied by a client. UserID is zero for keys
+// created by routes that are not scoped to a user, such as registration.
 type Key struct {
 	ID        int
 	CreatedAt time.Time
@@ -19,11 +24,26 @@ type Key struct {
 	RequestParams []byte
 	RequestPath   string
 	// Response metadata
-	ResponseCode sql.Null[int]
+	ResponseCode    sql.Null[int]
+	ResponseHeaders ResponseHeaders
+	// ResponseBody holds the exact bytes sent to the client so that replays are
+	// byte-for-byte identical.
 	ResponseBody sql.Null[[]byte]
 
 	RecoveryPoint RecoveryPointEnum
 	UserID        int
+	// Attempts counts the requests that ran the key: 1 for the request that
+	// inserted it, plus one for every request that resumed it.
+	Attempts int
+}
+
+// PhaseKey returns the idempotency key for requests that the phase of k starting at
+// phase makes to other services, like Stripe. It is the same every time the phase
+// runs, so re-running a phase that crashed after such a request can't make it twice.
+// The creation time of k is part of it so that keys don't repeat when IDs do, like
+// after the database was recreated.
+func (k *Key) PhaseKey(phase RecoveryPointEnum) string {
+	return fmt.Sprintf("rocket-rides-%d-%d-%s", k.ID, k.CreatedAt.UnixMicro(), phase)
 }
 
 type KeyParams struct {
@@ -34,15 +54,48 @@ type KeyParams struct {
 	UserID        int
 }
 
-func scanAllKeyFields(row *sql.Row, key *Key) error {
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAllKeyFields(row rowScanner, key *Key) error {
 	return row.Scan(
 		&key.ID, &key.CreatedAt, &key.Key, &key.LastRunAt, &key.LockedAt,
 		&key.RequestMethod, &key.RequestParams, &key.RequestPath,
-		&key.ResponseCode, &key.ResponseBody,
-		&key.RecoveryPoint, &key.UserID,
+		&key.ResponseCode, &key.ResponseHeaders, &key.ResponseBody,
+		&key.RecoveryPoint, &key.UserID, &key.Attempts,
 	)
 }
 
+// ResponseHeaders are the headers stored with the response of a finished key. They
+// are kept as JSON in idempotency_keys.response_headers.
+type ResponseHeaders http.Header
+
+var _ sql.Scanner = (*ResponseHeaders)(nil)
+var _ driver.Valuer = ResponseHeaders(nil)
+
+func (h *ResponseHeaders) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, h)
+	case string:
+		return json.Unmarshal([]byte(v), h)
+	default:
+		return fmt.Errorf("unsupported type %T for response headers", src)
+	}
+}
+
+func (h ResponseHeaders) Value() (driver.Value, error) {
+	if h == nil {
+		return nil, nil
+	}
+	return json.Marshal(h)
+}
+
 func FindKey(
 	ctx context.Context,
 	tx *sql.Tx,
@@ -54,11 +107,11 @@ func FindKey(
 		SELECT
 		    id, created_at, idempotency_key, last_run_at, locked_at,
 		    request_method, request_params, request_path,
-		    response_code, response_body,
-		    recovery_point, user_id
+		    response_code, response_headers, response_body,
+		    recovery_point, COALESCE(user_id, 0), attempts
 		FROM idempotency_keys
 		WHERE 
-			user_id = $1 AND idempotency_key = $2;`,
+			user_id IS NOT DISTINCT FROM NULLIF($1::BIGINT, 0) AND idempotency_key = $2;`,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("preparing context: %w", err)
@@ -76,6 +129,29 @@ func FindKey(
 	return &iKey, nil
 }
 
+// FindKeyByID finds a key by its ID and locks its row until tx ends.
+func FindKeyByID(ctx context.Context, tx *sql.Tx, id int) (*Key, error) {
+	row := tx.QueryRowContext(ctx,
+		`
+		SELECT
+		    id, created_at, idempotency_key, last_run_at, locked_at,
+		    request_method, request_params, request_path,
+		    response_code, response_headers, response_body,
+		    recovery_point, COALESCE(user_id, 0), attempts
+		FROM idempotency_keys
+		WHERE id = $1
+		FOR UPDATE
+		;`,
+		id,
+	)
+
+	var key Key
+	if err := scanAllKeyFields(row, &key); err != nil {
+		return nil, fmt.Errorf("querying row: %w", err)
+	}
+	return &key, nil
+}
+
 func InsertKey(
 	ctx context.Context,
 	tx *sql.Tx,
@@ -90,12 +166,12 @@ func InsertKey(
 		recovery_point,
 		user_id
 		) VALUES (
-		  $1, $2, $3, $4, $5, $6
+		  $1, $2, $3, $4, $5, NULLIF($6::BIGINT, 0)
 		) RETURNING 
 		    id, created_at, idempotency_key, last_run_at, locked_at, 
 		 	request_method, request_params, request_path,
-			response_body, response_code,
-			recovery_point, user_id
+			response_code, response_headers, response_body,
+			recovery_point, COALESCE(user_id, 0), attempts
 		;`,
 	)
 	defer stmt.Close()
@@ -137,15 +213,17 @@ func UpdateKey(ctx context.Context, tx *sql.Tx, key *Key) (*Key, error) {
 			request_params = $7,
 			request_path = $8,
 			response_code = $9,
-			response_body = $10,
-			recovery_point = $11,
-			user_id = $12
+			response_headers = $10,
+			response_body = $11,
+			recovery_point = $12,
+			user_id = NULLIF($13::BIGINT, 0),
+			attempts = $14
 		WHERE id = $1
 		RETURNING 
 			id, created_at, idempotency_key, last_run_at, locked_at, 
 			request_method, request_params, request_path,
-			response_code, response_body, 
-			recovery_point, user_id
+			response_code, response_headers, response_body, 
+			recovery_point, COALESCE(user_id, 0), attempts
 		;
 	`)
 
@@ -155,16 +233,12 @@ func UpdateKey(ctx context.Context, tx *sql.Tx, key *Key) (*Key, error) {
 	defer stmt.Close()
 
 	var updatedKey Key
-	err = stmt.QueryRowContext(ctx,
+	row := stmt.QueryRowContext(ctx,
 		key.ID, key.CreatedAt, key.Key, key.LastRunAt, key.LockedAt,
 		key.RequestMethod, key.RequestParams, key.RequestPath,
-		key.ResponseCode, key.ResponseBody,
-		key.RecoveryPoint, key.UserID).Scan(
-		&updatedKey.ID, &updatedKey.CreatedAt, &updatedKey.Key, &updatedKey.LastRunAt, &updatedKey.LockedAt,
-		&updatedKey.RequestMethod, &updatedKey.RequestParams, &updatedKey.RequestPath,
-		&updatedKey.ResponseCode, &updatedKey.ResponseBody,
-		&updatedKey.RecoveryPoint, &updatedKey.UserID,
-	)
+		key.ResponseCode, key.ResponseHeaders, key.ResponseBody,
+		key.RecoveryPoint, key.UserID, key.Attempts)
+	err = scanAllKeyFields(row, &updatedKey)
 
 	if err != nil {
 		return nil, err
@@ -172,6 +246,29 @@ func UpdateKey(ctx context.Context, tx *sql.Tx, key *Key) (*Key, error) {
 	return &updatedKey, nil
 }
 
-func DeleteIdempotencyKey(tx *sql.Tx, key *Key) error {
+// DeleteIdempotencyKey deletes the key. Rides created with it are kept and lose
+// their reference to it.
+func DeleteIdempotencyKey(ctx context.Context, tx *sql.Tx, key *Key) error {
+	if key == nil {
+		return errors.New("key must not be nil")
+	}
+	result, err := tx.ExecContext(ctx,
+		`
+		DELETE FROM idempotency_keys
+		WHERE id = $1
+		;`,
+		key.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
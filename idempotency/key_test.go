@@ -279,3 +279,16 @@ func must[T any](v T, err error) T {
 	return v
 
 }
+
+func TestKey_PhaseKey(t *testing.T) {
+	t.Parallel()
+
+	createdAt := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	key := &idempotency.Key{ID: 736, CreatedAt: createdAt}
+
+	phaseKey := key.PhaseKey(idempotency.RideCreatedRecoveryPoint)
+	assert.Equal(t, phaseKey, key.PhaseKey(idempotency.RideCreatedRecoveryPoint), "same phase of the same key")
+	assert.NotEqual(t, phaseKey, key.PhaseKey(idempotency.StartedRecoveryPoint), "other phase of the same key")
+	assert.NotEqual(t, phaseKey, (&idempotency.Key{ID: 737, CreatedAt: createdAt}).PhaseKey(idempotency.RideCreatedRecoveryPoint), "same phase of another key")
+	assert.NotEqual(t, phaseKey, (&idempotency.Key{ID: 736, CreatedAt: createdAt.Add(time.Hour)}).PhaseKey(idempotency.RideCreatedRecoveryPoint), "key that reused the ID")
+}
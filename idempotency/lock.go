@@ -0,0 +1,140 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/anmho/idempotent-rides/metrics"
+	database "github.com/anmho/idempotent-rides/sql"
+	"time"
+)
+
+const (
+	// DefaultLockTimeout is how long a request may hold a key before another request
+	// is allowed to take the lock over and resume it.
+	DefaultLockTimeout = 90 * time.Second
+	// DefaultMaxAttempts is how many requests may run a key before it is left for an
+	// operator to repair.
+	DefaultMaxAttempts = 10
+)
+
+// ErrKeyLocked is returned when another request currently holds the lock on a key.
+var ErrKeyLocked = errors.New("idempotency key is locked by another request")
+
+// LockedError reports a locked key and how long the caller should wait before
+// retrying. It matches ErrKeyLocked with errors.Is.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+var _ error = (*LockedError)(nil)
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrKeyLocked, e.RetryAfter)
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrKeyLocked
+}
+
+// ErrLockLost is returned when a key was changed by another request while this one
+// was running it, usually because its lock went stale and was taken over. The phase
+// is abandoned to the request that holds the key now.
+var ErrLockLost = errors.New("idempotency key was taken over by another request")
+
+// ErrMaxAttempts is returned when a key has been run by as many requests as allowed.
+var ErrMaxAttempts = errors.New("idempotency key has reached the maximum number of attempts")
+
+// MaxAttemptsError reports a key that won't be resumed again. It matches ErrMaxAttempts
+// with errors.Is.
+type MaxAttemptsError struct {
+	Attempts      int
+	RecoveryPoint RecoveryPointEnum
+}
+
+var _ error = (*MaxAttemptsError)(nil)
+
+func (e *MaxAttemptsError) Error() string {
+	return fmt.Sprintf("%s: %d attempts, stuck at %s", ErrMaxAttempts, e.Attempts, e.RecoveryPoint)
+}
+
+func (e *MaxAttemptsError) Unwrap() error {
+	return ErrMaxAttempts
+}
+
+// Config controls how keys are locked while a request is running and how its
+// transactions are retried.
+type Config struct {
+	// LockTimeout is how long a lock is held before it is considered stale. Zero
+	// uses DefaultLockTimeout.
+	LockTimeout time.Duration
+	// MaxRetries is how many times a transaction is retried after a serialization
+	// failure or deadlock. Zero uses DefaultMaxRetries and a negative value turns
+	// retries off.
+	MaxRetries int
+	// RetryBaseDelay is the backoff before the first retry. Zero uses
+	// DefaultRetryBaseDelay.
+	RetryBaseDelay time.Duration
+	// MaxAttempts is how many requests, including the first, may run a key that
+	// hasn't finished. Zero uses DefaultMaxAttempts.
+	MaxAttempts int
+}
+
+func (c Config) lockTimeout() time.Duration {
+	if c.LockTimeout <= 0 {
+		return DefaultLockTimeout
+	}
+	return c.LockTimeout
+}
+
+func (c Config) maxAttempts() int {
+	if c.MaxAttempts <= 0 {
+		return DefaultMaxAttempts
+	}
+	return c.MaxAttempts
+}
+
+// lockKey acquires the lock on an existing key for the current request, which resumes
+// it: last_run_at and the attempts counter are bumped in the same update. A lock held
+// for longer than the timeout belonged to a request that crashed or hung, so it is
+// taken over. Keys that have used up their attempts fail with a *MaxAttemptsError.
+func lockKey(ctx context.Context, store KeyStore, tx Tx, cfg Config, key *Key) (*Key, error) {
+	now := time.Now()
+	if key.LockedAt.Valid {
+		age := now.Sub(key.LockedAt.V)
+		if age < cfg.lockTimeout() {
+			return nil, &LockedError{RetryAfter: cfg.lockTimeout() - age}
+		}
+	}
+	if key.Attempts >= cfg.maxAttempts() {
+		metrics.KeysMaxAttemptsReached.Add(1)
+		return nil, &MaxAttemptsError{Attempts: key.Attempts, RecoveryPoint: key.RecoveryPoint}
+	}
+
+	lockedKey := new(Key)
+	*lockedKey = *key
+	lockedKey.LockedAt = sql.Null[time.Time]{
+		V:     now,
+		Valid: true,
+	}
+	lockedKey.LastRunAt = now
+	lockedKey.Attempts++
+	metrics.KeysResumed.Add(1)
+	return store.UpdateKey(ctx, tx, lockedKey)
+}
+
+// UnlockKey releases the lock on a key so that another request can resume it. The lock
+// is only released if it is still the one taken at lockedAt: a request whose lock went
+// stale and was taken over must not release the lock of the request that resumed it.
+func UnlockKey(ctx context.Context, db database.DB, keyID int, lockedAt time.Time) error {
+	_, err := db.ExecContext(ctx,
+		`
+		UPDATE idempotency_keys
+		SET locked_at = NULL
+		WHERE id = $1 AND locked_at = $2
+		;`,
+		keyID, lockedAt,
+	)
+	return err
+}
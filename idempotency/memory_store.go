@@ -0,0 +1,323 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+var _ KeyStore = (*memoryStore)(nil)
+
+// memoryStore keeps keys in a map. A transaction holds the store's mutex from Begin
+// until Commit or Rollback, which makes every transaction trivially serializable.
+// Rollback restores the snapshot taken at Begin.
+type memoryStore struct {
+	mu               sync.Mutex
+	nextID           int
+	keys             map[int]*Key
+	nextTransitionID int
+	transitions      []*Transition
+}
+
+// MakeMemoryStore returns a KeyStore that keeps keys in memory. It has the same
+// locking semantics as the Postgres store and is safe for concurrent use, which makes
+// it useful for tests and local tools that can't run Postgres. Phases run by it get a
+// nil *sql.Tx.
+func MakeMemoryStore() KeyStore {
+	return &memoryStore{
+		nextID:           1,
+		keys:             make(map[int]*Key),
+		nextTransitionID: 1,
+	}
+}
+
+var _ Tx = (*memoryTx)(nil)
+
+type memoryTx struct {
+	store    *memoryStore
+	snapshot map[int]*Key
+	nextID   int
+	// transitions snapshots the transitions at Begin. The slice is only appended to
+	// within a transaction, so it can be shared with the store.
+	transitions      []*Transition
+	nextTransitionID int
+	done             bool
+}
+
+func (t *memoryTx) SQL() *sql.Tx {
+	return nil
+}
+
+func (t *memoryTx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	t.store.mu.Unlock()
+	return nil
+}
+
+func (t *memoryTx) Rollback() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	t.store.keys = t.snapshot
+	t.store.nextID = t.nextID
+	t.store.transitions = t.transitions
+	t.store.nextTransitionID = t.nextTransitionID
+	t.store.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) Begin(ctx context.Context) (Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	s.mu.Lock()
+	return &memoryTx{
+		store:            s,
+		snapshot:         maps.Clone(s.keys),
+		nextID:           s.nextID,
+		transitions:      slices.Clip(s.transitions),
+		nextTransitionID: s.nextTransitionID,
+	}, nil
+}
+
+func (s *memoryStore) checkTx(tx Tx) error {
+	memTx, ok := tx.(*memoryTx)
+	if !ok || memTx.store != s {
+		return errors.New("transaction does not belong to this store")
+	}
+	if memTx.done {
+		return sql.ErrTxDone
+	}
+	return nil
+}
+
+func (s *memoryStore) FindKey(ctx context.Context, tx Tx, userID int, key string) (*Key, error) {
+	if err := s.checkTx(tx); err != nil {
+		return nil, err
+	}
+	for _, k := range s.keys {
+		if k.UserID == userID && k.Key == key {
+			return cloneKey(k), nil
+		}
+	}
+	return nil, fmt.Errorf("querying row: %w", sql.ErrNoRows)
+}
+
+func (s *memoryStore) FindKeyByID(ctx context.Context, tx Tx, id int) (*Key, error) {
+	if err := s.checkTx(tx); err != nil {
+		return nil, err
+	}
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("querying row: %w", sql.ErrNoRows)
+	}
+	return cloneKey(key), nil
+}
+
+func (s *memoryStore) InsertKey(ctx context.Context, tx Tx, params KeyParams) (*Key, error) {
+	if err := s.checkTx(tx); err != nil {
+		return nil, err
+	}
+	for _, k := range s.keys {
+		if k.UserID == params.UserID && k.Key == params.Key {
+			return nil, fmt.Errorf("duplicate idempotency key %q for user %d", params.Key, params.UserID)
+		}
+	}
+
+	now := time.Now()
+	key := &Key{
+		ID:            s.nextID,
+		CreatedAt:     now,
+		Key:           params.Key,
+		LastRunAt:     now,
+		LockedAt:      sql.Null[time.Time]{V: now, Valid: true},
+		RequestMethod: params.RequestMethod,
+		RequestParams: slices.Clone(params.RequestParams),
+		RequestPath:   params.RequestPath,
+		RecoveryPoint: StartedRecoveryPoint,
+		UserID:        params.UserID,
+		Attempts:      1,
+	}
+	s.nextID++
+	s.keys[key.ID] = key
+	return cloneKey(key), nil
+}
+
+func (s *memoryStore) UpdateKey(ctx context.Context, tx Tx, key *Key) (*Key, error) {
+	if key == nil {
+		return nil, errors.New("key must not be nil")
+	}
+	if err := s.checkTx(tx); err != nil {
+		return nil, err
+	}
+	if _, ok := s.keys[key.ID]; !ok {
+		return nil, sql.ErrNoRows
+	}
+	s.keys[key.ID] = cloneKey(key)
+	return cloneKey(key), nil
+}
+
+func (s *memoryStore) DeleteKey(ctx context.Context, tx Tx, key *Key) error {
+	if key == nil {
+		return errors.New("key must not be nil")
+	}
+	if err := s.checkTx(tx); err != nil {
+		return err
+	}
+	if _, ok := s.keys[key.ID]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(s.keys, key.ID)
+	s.deleteOrphanedTransitions()
+	return nil
+}
+
+func (s *memoryStore) UnlockKey(ctx context.Context, keyID int, lockedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.keys[keyID]; ok && key.LockedAt.Valid && key.LockedAt.V.Equal(lockedAt) {
+		unlocked := cloneKey(key)
+		unlocked.LockedAt = sql.Null[time.Time]{}
+		s.keys[keyID] = unlocked
+	}
+	return nil
+}
+
+func (s *memoryStore) FindAbandonedKeys(ctx context.Context, lastRunBefore, lockedBefore time.Time, maxAttempts, limit int) ([]*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []*Key
+	for _, k := range s.keys {
+		if k.RecoveryPoint == FinishedRecoveryPoint || !k.LastRunAt.Before(lastRunBefore) {
+			continue
+		}
+		if k.LockedAt.Valid && !k.LockedAt.V.Before(lockedBefore) {
+			continue
+		}
+		if k.Attempts >= maxAttempts {
+			continue
+		}
+		keys = append(keys, cloneKey(k))
+	}
+
+	slices.SortFunc(keys, func(a, b *Key) int {
+		return a.LastRunAt.Compare(b.LastRunAt)
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+func (s *memoryStore) FindStuckKeys(ctx context.Context, recoveryPoint RecoveryPointEnum, lastRunBefore time.Time, limit int) ([]*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []*Key
+	for _, k := range s.keys {
+		if k.RecoveryPoint == FinishedRecoveryPoint || !k.LastRunAt.Before(lastRunBefore) {
+			continue
+		}
+		if recoveryPoint != "" && k.RecoveryPoint != recoveryPoint {
+			continue
+		}
+		keys = append(keys, cloneKey(k))
+	}
+
+	slices.SortFunc(keys, func(a, b *Key) int {
+		return a.LastRunAt.Compare(b.LastRunAt)
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+func (s *memoryStore) DeleteKeysCreatedBefore(ctx context.Context, cutoff time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int, 0, len(s.keys))
+	for id := range s.keys {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	var n int
+	for _, id := range ids {
+		if n >= limit {
+			break
+		}
+		if s.keys[id].CreatedAt.Before(cutoff) {
+			delete(s.keys, id)
+			n++
+		}
+	}
+	s.deleteOrphanedTransitions()
+	return n, nil
+}
+
+// deleteOrphanedTransitions deletes the transitions of deleted keys, like the foreign
+// key in Postgres does.
+func (s *memoryStore) deleteOrphanedTransitions() {
+	s.transitions = slices.DeleteFunc(slices.Clone(s.transitions), func(t *Transition) bool {
+		_, ok := s.keys[t.KeyID]
+		return !ok
+	})
+}
+
+func (s *memoryStore) InsertTransition(ctx context.Context, tx Tx, transition *Transition) (*Transition, error) {
+	if transition == nil {
+		return nil, errors.New("transition must not be nil")
+	}
+	if err := s.checkTx(tx); err != nil {
+		return nil, err
+	}
+	if _, ok := s.keys[transition.KeyID]; !ok {
+		return nil, fmt.Errorf("inserting transition: unknown key %d", transition.KeyID)
+	}
+
+	inserted := *transition
+	inserted.ID = s.nextTransitionID
+	s.nextTransitionID++
+	inserted.CreatedAt = time.Now()
+	inserted.Duration = inserted.Duration.Truncate(time.Millisecond)
+	s.transitions = append(s.transitions, &inserted)
+	clone := inserted
+	return &clone, nil
+}
+
+func (s *memoryStore) FindTransitions(ctx context.Context, keyID int) ([]*Transition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var transitions []*Transition
+	for _, t := range s.transitions {
+		if t.KeyID == keyID {
+			clone := *t
+			transitions = append(transitions, &clone)
+		}
+	}
+	return transitions, nil
+}
+
+// cloneKey copies key so that callers can't modify the stored key through shared slices.
+func cloneKey(key *Key) *Key {
+	clone := *key
+	clone.RequestParams = slices.Clone(key.RequestParams)
+	clone.ResponseHeaders = ResponseHeaders(http.Header(key.ResponseHeaders).Clone())
+	clone.ResponseBody.V = slices.Clone(key.ResponseBody.V)
+	return &clone
+}
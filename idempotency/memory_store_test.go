@@ -0,0 +1,483 @@
+package idempotency_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const stepRecoveryPoint idempotency.RecoveryPointEnum = "step"
+
+// countingWorkflow moves through started -> step -> finished and counts how many
+// times each phase ran. failStep makes the step phase fail once with a retryable error.
+type countingWorkflow struct {
+	started  atomic.Int32
+	step     atomic.Int32
+	failStep atomic.Bool
+	delay    time.Duration
+}
+
+func (c *countingWorkflow) workflow() *idempotency.Workflow {
+	return idempotency.MakeWorkflow("counting").
+		Phase(idempotency.StartedRecoveryPoint, func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+			c.started.Add(1)
+			time.Sleep(c.delay)
+			return idempotency.NewRecoveryPointResult(stepRecoveryPoint), nil
+		}, stepRecoveryPoint).
+		Phase(stepRecoveryPoint, func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+			c.step.Add(1)
+			if c.failStep.CompareAndSwap(true, false) {
+				return nil, idempotency.Retryable(errors.New("timeout"))
+			}
+			return idempotency.NewResponseResult(http.StatusCreated, map[string]any{"ok": true}), nil
+		}, idempotency.FinishedRecoveryPoint)
+}
+
+func memoryKeyParams(params string) idempotency.KeyParams {
+	return idempotency.KeyParams{
+		Key:           "memoryKey",
+		RequestMethod: http.MethodPost,
+		RequestParams: []byte(params),
+		RequestPath:   "/rides",
+		UserID:        TestUserID,
+	}
+}
+
+func TestMemoryStore_Handle(t *testing.T) {
+	t.Parallel()
+
+	t.Run("happy path: finished key is replayed without running phases again", func(t *testing.T) {
+		store := idempotency.MakeMemoryStore()
+		c := &countingWorkflow{}
+		ctx := context.Background()
+
+		var body []byte
+		for i := range 3 {
+			key, replayed, err := idempotency.Handle(ctx, store, idempotency.Config{}, memoryKeyParams(`{"a": 1}`), c.workflow())
+			require.NoError(t, err)
+			assert.Equal(t, i > 0, replayed)
+			if i > 0 {
+				assert.Equal(t, body, key.ResponseBody.V)
+			}
+			body = key.ResponseBody.V
+			assert.Equal(t, "application/json", http.Header(key.ResponseHeaders).Get("Content-Type"))
+			assert.Equal(t, idempotency.FinishedRecoveryPoint, key.RecoveryPoint)
+			assert.Equal(t, http.StatusCreated, key.ResponseCode.V)
+			assert.JSONEq(t, `{"ok": true}`, string(key.ResponseBody.V))
+			assert.False(t, key.LockedAt.Valid)
+		}
+		assert.EqualValues(t, 1, c.started.Load())
+		assert.EqualValues(t, 1, c.step.Load())
+	})
+
+	t.Run("error path: key reused with different params", func(t *testing.T) {
+		store := idempotency.MakeMemoryStore()
+		c := &countingWorkflow{}
+		ctx := context.Background()
+
+		_, _, err := idempotency.Handle(ctx, store, idempotency.Config{}, memoryKeyParams(`{"a": 1}`), c.workflow())
+		require.NoError(t, err)
+
+		_, _, err = idempotency.Handle(ctx, store, idempotency.Config{}, memoryKeyParams(`{"a": 2}`), c.workflow())
+		assert.ErrorIs(t, err, idempotency.ErrKeyReused)
+	})
+
+	t.Run("happy path: retry resumes from the recovery point of the failed phase", func(t *testing.T) {
+		store := idempotency.MakeMemoryStore()
+		c := &countingWorkflow{}
+		c.failStep.Store(true)
+		ctx := context.Background()
+
+		_, _, err := idempotency.Handle(ctx, store, idempotency.Config{}, memoryKeyParams(`{}`), c.workflow())
+		var retryableErr *idempotency.RetryableError
+		require.ErrorAs(t, err, &retryableErr)
+
+		// The failed phase unlocked the key, so the retry doesn't have to wait.
+		key, replayed, err := idempotency.Handle(ctx, store, idempotency.Config{}, memoryKeyParams(`{}`), c.workflow())
+		require.NoError(t, err)
+		assert.False(t, replayed)
+		assert.Equal(t, idempotency.FinishedRecoveryPoint, key.RecoveryPoint)
+		assert.EqualValues(t, 1, c.started.Load())
+		assert.EqualValues(t, 2, c.step.Load())
+	})
+
+	t.Run("happy path: concurrent duplicates run each phase once", func(t *testing.T) {
+		store := idempotency.MakeMemoryStore()
+		c := &countingWorkflow{delay: 10 * time.Millisecond}
+		ctx := context.Background()
+
+		var wg sync.WaitGroup
+		var finished, locked atomic.Int32
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				key, _, err := idempotency.Handle(ctx, store, idempotency.Config{LockTimeout: time.Hour}, memoryKeyParams(`{}`), c.workflow())
+				switch {
+				case errors.Is(err, idempotency.ErrKeyLocked):
+					locked.Add(1)
+				case err == nil && key.RecoveryPoint == idempotency.FinishedRecoveryPoint:
+					finished.Add(1)
+				default:
+					t.Errorf("unexpected result: key %v, err %v", key, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		assert.EqualValues(t, 10, finished.Load()+locked.Load())
+		assert.EqualValues(t, 1, c.started.Load())
+		assert.EqualValues(t, 1, c.step.Load())
+	})
+}
+
+func TestMemoryStore_RequireSQL(t *testing.T) {
+	t.Parallel()
+
+	store := idempotency.MakeMemoryStore()
+	ctx := context.Background()
+
+	var ran bool
+	workflow := idempotency.MakeWorkflow("writes_rows").RequireSQL().
+		Phase(idempotency.StartedRecoveryPoint, func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+			ran = true
+			return idempotency.NewResponseResult(http.StatusCreated, nil), nil
+		}, idempotency.FinishedRecoveryPoint)
+
+	_, _, err := idempotency.Handle(ctx, store, idempotency.Config{}, memoryKeyParams(`{}`), workflow)
+	assert.ErrorIs(t, err, idempotency.ErrNoSQL)
+	assert.False(t, ran)
+	assert.Equal(t, idempotency.StartedRecoveryPoint, findMemoryKey(t, store).RecoveryPoint)
+}
+
+func TestMemoryStore_Rollback(t *testing.T) {
+	t.Parallel()
+
+	store := idempotency.MakeMemoryStore()
+	ctx := context.Background()
+
+	tx, err := store.Begin(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, tx.SQL())
+	_, err = store.InsertKey(ctx, tx, memoryKeyParams(`{}`))
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+	assert.ErrorIs(t, tx.Rollback(), sql.ErrTxDone)
+
+	tx, err = store.Begin(ctx)
+	require.NoError(t, err)
+	defer tx.Rollback()
+	_, err = store.FindKey(ctx, tx, TestUserID, "memoryKey")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestMemoryStore_CompleteAndReap(t *testing.T) {
+	t.Parallel()
+
+	store := idempotency.MakeMemoryStore()
+	c := &countingWorkflow{}
+	c.failStep.Store(true)
+	ctx := context.Background()
+
+	_, _, err := idempotency.Handle(ctx, store, idempotency.Config{}, memoryKeyParams(`{}`), c.workflow())
+	require.Error(t, err)
+
+	registry := idempotency.MakeRegistry()
+	registry.Register(http.MethodPost, "/rides", func(params []byte) (*idempotency.Workflow, error) {
+		return c.workflow(), nil
+	})
+	completer := idempotency.MakeCompleter(store, idempotency.CompleterConfig{Threshold: time.Nanosecond}, registry)
+	n, err := completer.CompleteOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.EqualValues(t, 2, c.step.Load())
+
+	reaper := idempotency.MakeReaper(store, idempotency.ReaperConfig{Retention: time.Nanosecond})
+	n, err = reaper.ReapOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestMemoryStore_CompleteUnparseableParams(t *testing.T) {
+	t.Parallel()
+
+	store := idempotency.MakeMemoryStore()
+	c := &countingWorkflow{}
+	ctx := context.Background()
+
+	tx, err := store.Begin(ctx)
+	require.NoError(t, err)
+	key, err := store.InsertKey(ctx, tx, memoryKeyParams(`not json`))
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	registry := idempotency.MakeRegistry()
+	registry.Register(http.MethodPost, "/rides", func(params []byte) (*idempotency.Workflow, error) {
+		var decoded map[string]any
+		if err := json.Unmarshal(params, &decoded); err != nil {
+			return nil, err
+		}
+		return c.workflow(), nil
+	})
+	cfg := idempotency.Config{LockTimeout: time.Nanosecond, MaxAttempts: 2}
+	completer := idempotency.MakeCompleter(store, idempotency.CompleterConfig{Threshold: time.Nanosecond, Lock: cfg}, registry)
+
+	n, err := completer.CompleteOnce(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	// The failed run used up an attempt and was recorded, and the key is left
+	// unlocked at its recovery point.
+	failed := findMemoryKey(t, store)
+	assert.Equal(t, 2, failed.Attempts)
+	assert.False(t, failed.LockedAt.Valid)
+	assert.Equal(t, idempotency.StartedRecoveryPoint, failed.RecoveryPoint)
+	transitions, err := store.FindTransitions(ctx, key.ID)
+	require.NoError(t, err)
+	require.Len(t, transitions, 1)
+	assert.True(t, transitions[0].Failed())
+	assert.Contains(t, transitions[0].Error.V, "building workflow")
+
+	// Out of attempts, the key is no longer picked up.
+	n, err = completer.CompleteOnce(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.Equal(t, 2, findMemoryKey(t, store).Attempts)
+	transitions, err = store.FindTransitions(ctx, key.ID)
+	require.NoError(t, err)
+	assert.Len(t, transitions, 1)
+	assert.Zero(t, c.started.Load())
+}
+
+func TestMemoryStore_Transitions(t *testing.T) {
+	t.Parallel()
+
+	store := idempotency.MakeMemoryStore()
+	c := &countingWorkflow{}
+	c.failStep.Store(true)
+	ctx := context.Background()
+
+	_, _, err := idempotency.Handle(ctx, store, idempotency.Config{}, memoryKeyParams(`{}`), c.workflow())
+	require.Error(t, err)
+	key, _, err := idempotency.Handle(ctx, store, idempotency.Config{}, memoryKeyParams(`{}`), c.workflow())
+	require.NoError(t, err)
+
+	transitions, err := store.FindTransitions(ctx, key.ID)
+	require.NoError(t, err)
+	require.Len(t, transitions, 3)
+
+	expected := []struct {
+		from, to idempotency.RecoveryPointEnum
+		failed   bool
+	}{
+		{from: idempotency.StartedRecoveryPoint, to: stepRecoveryPoint},
+		{from: stepRecoveryPoint, to: stepRecoveryPoint, failed: true},
+		{from: stepRecoveryPoint, to: idempotency.FinishedRecoveryPoint},
+	}
+	for i, e := range expected {
+		assert.Equal(t, key.ID, transitions[i].KeyID)
+		assert.Equal(t, e.from, transitions[i].From)
+		assert.Equal(t, e.to, transitions[i].To)
+		assert.Equal(t, e.failed, transitions[i].Failed())
+		assert.Equal(t, 1, transitions[i].Attempts)
+	}
+	assert.Contains(t, transitions[1].Error.V, "timeout")
+
+	reaper := idempotency.MakeReaper(store, idempotency.ReaperConfig{Retention: time.Nanosecond})
+	_, err = reaper.ReapOnce(ctx)
+	require.NoError(t, err)
+	transitions, err = store.FindTransitions(ctx, key.ID)
+	require.NoError(t, err)
+	assert.Empty(t, transitions)
+}
+
+func TestMemoryStore_ForceFinish(t *testing.T) {
+	t.Parallel()
+
+	store := idempotency.MakeMemoryStore()
+	ctx := context.Background()
+
+	tx, err := store.Begin(ctx)
+	require.NoError(t, err)
+	key, err := store.InsertKey(ctx, tx, memoryKeyParams(`{}`))
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	tx, err = store.Begin(ctx)
+	require.NoError(t, err)
+	unlocked, err := idempotency.ForceUnlock(ctx, store, tx, key.ID)
+	require.NoError(t, err)
+	assert.False(t, unlocked.LockedAt.Valid)
+	assert.Equal(t, idempotency.StartedRecoveryPoint, unlocked.RecoveryPoint)
+
+	finished, err := idempotency.ForceFinish(ctx, store, tx, key.ID, idempotency.NewResponseResult(http.StatusOK, map[string]any{"manual": true}))
+	require.NoError(t, err)
+	assert.Equal(t, idempotency.FinishedRecoveryPoint, finished.RecoveryPoint)
+	assert.JSONEq(t, `{"manual": true}`, string(finished.ResponseBody.V))
+
+	_, err = idempotency.ForceFinish(ctx, store, tx, key.ID, idempotency.NewResponseResult(http.StatusOK, nil))
+	assert.ErrorIs(t, err, idempotency.ErrKeyFinished)
+	_, err = idempotency.ForceUnlock(ctx, store, tx, key.ID+1)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	require.NoError(t, tx.Commit())
+
+	transitions, err := store.FindTransitions(ctx, key.ID)
+	require.NoError(t, err)
+	require.Len(t, transitions, 1)
+	assert.Equal(t, idempotency.FinishedRecoveryPoint, transitions[0].To)
+}
+
+func TestMemoryStore_UnlockKey(t *testing.T) {
+	t.Parallel()
+
+	store := idempotency.MakeMemoryStore()
+	ctx := context.Background()
+
+	tx, err := store.Begin(ctx)
+	require.NoError(t, err)
+	key, err := store.InsertKey(ctx, tx, memoryKeyParams(`{}`))
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+	staleLockedAt := key.LockedAt.V
+
+	// Another request takes the stale lock over.
+	tx, err = store.Begin(ctx)
+	require.NoError(t, err)
+	key.LockedAt.V = staleLockedAt.Add(time.Minute)
+	_, err = store.UpdateKey(ctx, tx, key)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	require.NoError(t, store.UnlockKey(ctx, key.ID, staleLockedAt))
+	assert.True(t, findMemoryKey(t, store).LockedAt.Valid)
+
+	require.NoError(t, store.UnlockKey(ctx, key.ID, key.LockedAt.V))
+	assert.False(t, findMemoryKey(t, store).LockedAt.Valid)
+}
+
+func TestMemoryStore_AtomicPhaseStaleKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc string
+		// change is made by another request after key was read.
+		change func(key *idempotency.Key)
+	}{
+		{
+			desc: "error path: lock was taken over",
+			change: func(key *idempotency.Key) {
+				key.LockedAt.V = key.LockedAt.V.Add(time.Minute)
+			},
+		},
+		{
+			desc: "error path: key was unlocked by an operator",
+			change: func(key *idempotency.Key) {
+				key.LockedAt = sql.Null[time.Time]{}
+			},
+		},
+		{
+			desc: "error path: key moved to another recovery point",
+			change: func(key *idempotency.Key) {
+				key.RecoveryPoint = stepRecoveryPoint
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			store := idempotency.MakeMemoryStore()
+			ctx := context.Background()
+
+			tx, err := store.Begin(ctx)
+			require.NoError(t, err)
+			stale, err := store.InsertKey(ctx, tx, memoryKeyParams(`{}`))
+			require.NoError(t, err)
+			require.NoError(t, tx.Commit())
+
+			tx, err = store.Begin(ctx)
+			require.NoError(t, err)
+			changed := *stale
+			tc.change(&changed)
+			_, err = store.UpdateKey(ctx, tx, &changed)
+			require.NoError(t, err)
+			require.NoError(t, tx.Commit())
+
+			var ran bool
+			_, err = idempotency.AtomicPhase(ctx, stale, store, idempotency.Config{}, func(tx *sql.Tx) (idempotency.AtomicPhaseResult, error) {
+				ran = true
+				return idempotency.NewRecoveryPointResult(stepRecoveryPoint), nil
+			})
+			assert.ErrorIs(t, err, idempotency.ErrLockLost)
+			assert.False(t, ran)
+
+			key := findMemoryKey(t, store)
+			assert.Equal(t, changed.RecoveryPoint, key.RecoveryPoint)
+			if changed.LockedAt != stale.LockedAt {
+				// The lock of the other request is left alone.
+				assert.Equal(t, changed.LockedAt, key.LockedAt)
+			}
+		})
+	}
+}
+
+func TestMemoryStore_MaxAttempts(t *testing.T) {
+	t.Parallel()
+
+	store := idempotency.MakeMemoryStore()
+	c := &countingWorkflow{}
+	cfg := idempotency.Config{MaxAttempts: 2}
+	ctx := context.Background()
+
+	c.failStep.Store(true)
+	_, _, err := idempotency.Handle(ctx, store, cfg, memoryKeyParams(`{}`), c.workflow())
+	require.Error(t, err)
+	first := findMemoryKey(t, store)
+	assert.Equal(t, 1, first.Attempts)
+
+	// Resuming the key bumps both its attempts and last_run_at.
+	c.failStep.Store(true)
+	_, _, err = idempotency.Handle(ctx, store, cfg, memoryKeyParams(`{}`), c.workflow())
+	require.Error(t, err)
+	second := findMemoryKey(t, store)
+	assert.Equal(t, 2, second.Attempts)
+	assert.True(t, second.LastRunAt.After(first.LastRunAt))
+
+	_, _, err = idempotency.Handle(ctx, store, cfg, memoryKeyParams(`{}`), c.workflow())
+	var maxAttemptsErr *idempotency.MaxAttemptsError
+	require.ErrorAs(t, err, &maxAttemptsErr)
+	assert.ErrorIs(t, err, idempotency.ErrMaxAttempts)
+	assert.Equal(t, 2, maxAttemptsErr.Attempts)
+	assert.Equal(t, stepRecoveryPoint, maxAttemptsErr.RecoveryPoint)
+	assert.EqualValues(t, 2, c.step.Load())
+
+	// The completer leaves the key for an operator too.
+	registry := idempotency.MakeRegistry()
+	registry.Register(http.MethodPost, "/rides", func(params []byte) (*idempotency.Workflow, error) {
+		return c.workflow(), nil
+	})
+	completer := idempotency.MakeCompleter(store, idempotency.CompleterConfig{Threshold: time.Nanosecond, Lock: cfg}, registry)
+	n, err := completer.CompleteOnce(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.EqualValues(t, 2, c.step.Load())
+}
+
+func findMemoryKey(t *testing.T, store idempotency.KeyStore) *idempotency.Key {
+	t.Helper()
+
+	tx, err := store.Begin(context.Background())
+	require.NoError(t, err)
+	defer tx.Rollback()
+	key, err := store.FindKey(context.Background(), tx, TestUserID, "memoryKey")
+	require.NoError(t, err)
+	return key
+}
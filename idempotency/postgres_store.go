@@ -0,0 +1,200 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+var _ KeyStore = (*postgresStore)(nil)
+
+type postgresStore struct {
+	db *sql.DB
+}
+
+// MakePostgresStore returns a KeyStore backed by the idempotency_keys table.
+func MakePostgresStore(db *sql.DB) KeyStore {
+	return &postgresStore{db: db}
+}
+
+var _ Tx = (*postgresTx)(nil)
+
+type postgresTx struct {
+	tx *sql.Tx
+}
+
+func (t *postgresTx) SQL() *sql.Tx {
+	return t.tx
+}
+
+func (t *postgresTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *postgresTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+func (s *postgresStore) Begin(ctx context.Context) (Tx, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelSerializable,
+		ReadOnly:  false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	return &postgresTx{tx: tx}, nil
+}
+
+func (s *postgresStore) FindKey(ctx context.Context, tx Tx, userID int, key string) (*Key, error) {
+	return FindKey(ctx, tx.SQL(), userID, key)
+}
+
+func (s *postgresStore) FindKeyByID(ctx context.Context, tx Tx, id int) (*Key, error) {
+	return FindKeyByID(ctx, tx.SQL(), id)
+}
+
+func (s *postgresStore) InsertKey(ctx context.Context, tx Tx, params KeyParams) (*Key, error) {
+	return InsertKey(ctx, tx.SQL(), params)
+}
+
+func (s *postgresStore) UpdateKey(ctx context.Context, tx Tx, key *Key) (*Key, error) {
+	return UpdateKey(ctx, tx.SQL(), key)
+}
+
+func (s *postgresStore) DeleteKey(ctx context.Context, tx Tx, key *Key) error {
+	return DeleteIdempotencyKey(ctx, tx.SQL(), key)
+}
+
+func (s *postgresStore) UnlockKey(ctx context.Context, keyID int, lockedAt time.Time) error {
+	return UnlockKey(ctx, s.db, keyID, lockedAt)
+}
+
+func (s *postgresStore) FindAbandonedKeys(ctx context.Context, lastRunBefore, lockedBefore time.Time, maxAttempts, limit int) ([]*Key, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`
+		SELECT
+		    id, created_at, idempotency_key, last_run_at, locked_at,
+		    request_method, request_params, request_path,
+		    response_code, response_headers, response_body,
+		    recovery_point, COALESCE(user_id, 0), attempts
+		FROM idempotency_keys
+		WHERE 
+			recovery_point <> $1
+			AND last_run_at < $2
+			AND (locked_at IS NULL OR locked_at < $3)
+			AND attempts < $4
+		ORDER BY last_run_at
+		LIMIT $5
+		;`,
+		FinishedRecoveryPoint, lastRunBefore, lockedBefore, maxAttempts, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*Key
+	for rows.Next() {
+		var key Key
+		if err = scanAllKeyFields(rows, &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *postgresStore) FindStuckKeys(ctx context.Context, recoveryPoint RecoveryPointEnum, lastRunBefore time.Time, limit int) ([]*Key, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`
+		SELECT
+		    id, created_at, idempotency_key, last_run_at, locked_at,
+		    request_method, request_params, request_path,
+		    response_code, response_headers, response_body,
+		    recovery_point, COALESCE(user_id, 0), attempts
+		FROM idempotency_keys
+		WHERE 
+			recovery_point <> $1
+			AND ($2 = '' OR recovery_point = $2)
+			AND last_run_at < $3
+		ORDER BY last_run_at
+		LIMIT $4
+		;`,
+		FinishedRecoveryPoint, recoveryPoint, lastRunBefore, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*Key
+	for rows.Next() {
+		var key Key
+		if err = scanAllKeyFields(rows, &key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, &key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *postgresStore) DeleteKeysCreatedBefore(ctx context.Context, cutoff time.Time, limit int) (int, error) {
+	result, err := s.db.ExecContext(ctx,
+		`
+		DELETE FROM idempotency_keys
+		WHERE id IN (
+			SELECT id
+			FROM idempotency_keys
+			WHERE created_at < $1
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		;`,
+		cutoff, limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+func (s *postgresStore) InsertTransition(ctx context.Context, tx Tx, transition *Transition) (*Transition, error) {
+	return InsertTransition(ctx, tx.SQL(), transition)
+}
+
+func (s *postgresStore) FindTransitions(ctx context.Context, keyID int) ([]*Transition, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`
+		SELECT
+			id, idempotency_key_id, created_at,
+			from_recovery_point, to_recovery_point,
+			duration_ms, attempts, error
+		FROM idempotency_key_transitions
+		WHERE idempotency_key_id = $1
+		ORDER BY id
+		;`,
+		keyID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transitions []*Transition
+	for rows.Next() {
+		var transition Transition
+		if err = scanTransition(rows, &transition); err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, &transition)
+	}
+	return transitions, rows.Err()
+}
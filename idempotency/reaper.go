@@ -0,0 +1,92 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"github.com/anmho/idempotent-rides/scope"
+	"log/slog"
+	"time"
+)
+
+const (
+	// DefaultRetention is how long keys are kept before they are reaped. Clients
+	// are expected to have stopped retrying well before then.
+	DefaultRetention = 72 * time.Hour
+	// DefaultReapBatchSize bounds how many keys are deleted in one transaction so
+	// the reaper never holds locks on a large part of the table.
+	DefaultReapBatchSize = 1000
+	// DefaultReapInterval is how often a long-running reaper wakes up.
+	DefaultReapInterval = time.Minute
+)
+
+// ReaperConfig controls which keys are reaped and how.
+type ReaperConfig struct {
+	Retention time.Duration
+	BatchSize int
+	Interval  time.Duration
+}
+
+// Reaper deletes idempotency keys that are older than the retention window. Rides
+// that reference a reaped key keep existing; their idempotency_key_id is set to NULL.
+type Reaper struct {
+	store KeyStore
+	cfg   ReaperConfig
+}
+
+func MakeReaper(store KeyStore, cfg ReaperConfig) *Reaper {
+	if cfg.Retention <= 0 {
+		cfg.Retention = DefaultRetention
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultReapBatchSize
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultReapInterval
+	}
+	return &Reaper{store: store, cfg: cfg}
+}
+
+// Run reaps keys every interval until ctx is cancelled.
+func (r *Reaper) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.ReapOnce(ctx); err != nil {
+			scope.GetLogger().Error("reaping idempotency keys", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReapOnce deletes every key older than the retention window in batches and returns
+// the number of keys deleted.
+func (r *Reaper) ReapOnce(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-r.cfg.Retention)
+
+	var total int
+	for {
+		n, err := r.store.DeleteKeysCreatedBefore(ctx, cutoff, r.cfg.BatchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < r.cfg.BatchSize {
+			break
+		}
+	}
+
+	scope.GetLogger().Info("reaped idempotency keys",
+		slog.Int("count", total),
+		slog.Time("cutoff", cutoff),
+	)
+	return total, nil
+}
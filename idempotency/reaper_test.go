@@ -0,0 +1,84 @@
+package idempotency_test
+
+import (
+	"context"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/anmho/idempotent-rides/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestReaper_ReapOnce(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc string
+		cfg  idempotency.ReaperConfig
+
+		expectedReaped int
+	}{
+		{
+			desc: "happy path: keys inside the retention window are kept",
+			cfg:  idempotency.ReaperConfig{Retention: time.Hour},
+
+			expectedReaped: 0,
+		},
+		{
+			desc: "happy path: every seeded key is reaped across several batches",
+			cfg:  idempotency.ReaperConfig{Retention: time.Nanosecond, BatchSize: 3},
+
+			expectedReaped: 4,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			db := test.MakePostgres(t)
+			ctx := context.Background()
+
+			reaper := idempotency.MakeReaper(idempotency.MakePostgresStore(db), tc.cfg)
+			n, err := reaper.ReapOnce(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedReaped, n)
+		})
+	}
+}
+
+func Test_DeleteIdempotencyKey(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc string
+		key  *idempotency.Key
+
+		expectedErr bool
+	}{
+		{
+			desc: "happy path: delete key that is referenced by rides",
+			// seeded rides 123 and 1442 were created with key 738
+			key: &idempotency.Key{ID: 738},
+		},
+		{
+			desc:        "error path: delete key that does not exist",
+			key:         &idempotency.Key{ID: 9999},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			db := test.MakePostgres(t)
+			ctx := context.Background()
+			tx := test.MakeTx(t, ctx, db)
+
+			err := idempotency.DeleteIdempotencyKey(ctx, tx, tc.key)
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
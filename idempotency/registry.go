@@ -0,0 +1,83 @@
+package idempotency
+
+import "strings"
+
+// WorkflowFunc builds the workflow of a request from the params stored on its key.
+type WorkflowFunc func(params []byte) (*Workflow, error)
+
+// Registry maps idempotent routes to the workflows that complete them, so that a key
+// can be driven to completion without the request that created it.
+type Registry struct {
+	routes map[string]WorkflowFunc
+	// patterns holds the routes with wildcards, in the order they were registered.
+	patterns []patternRoute
+}
+
+type patternRoute struct {
+	method   RequestMethod
+	segments []string
+	workflow WorkflowFunc
+}
+
+func MakeRegistry() *Registry {
+	return &Registry{
+		routes: make(map[string]WorkflowFunc),
+	}
+}
+
+// Register adds the workflow for requests to method and path. Like in the patterns of
+// http.ServeMux, a segment of path like {id} matches any segment, so /rides/{id}/refunds
+// matches the path /rides/123/refunds stored on a key.
+func (r *Registry) Register(method RequestMethod, path string, workflow WorkflowFunc) {
+	if !strings.Contains(path, "{") {
+		r.routes[routeName(method, path)] = workflow
+		return
+	}
+	r.patterns = append(r.patterns, patternRoute{
+		method:   method,
+		segments: strings.Split(path, "/"),
+		workflow: workflow,
+	})
+}
+
+// Lookup returns the workflow registered for method and path. Routes without
+// wildcards take precedence, then routes registered first.
+func (r *Registry) Lookup(method RequestMethod, path string) (WorkflowFunc, bool) {
+	if workflow, ok := r.routes[routeName(method, path)]; ok {
+		return workflow, true
+	}
+
+	segments := strings.Split(path, "/")
+	for _, pattern := range r.patterns {
+		if pattern.method == method && matchSegments(pattern.segments, segments) {
+			return pattern.workflow, true
+		}
+	}
+	return nil, false
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) != len(segments) {
+		return false
+	}
+	for i, segment := range pattern {
+		if isWildcard(segment) {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isWildcard(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+func routeName(method RequestMethod, path string) string {
+	return method.String() + " " + path
+}
@@ -0,0 +1,94 @@
+package idempotency_test
+
+import (
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestRegistry_Lookup(t *testing.T) {
+	// called is the name of the last workflow that was built.
+	var called string
+	workflowNamed := func(name string) idempotency.WorkflowFunc {
+		return func(params []byte) (*idempotency.Workflow, error) {
+			called = name
+			return idempotency.MakeWorkflow(name), nil
+		}
+	}
+	registry := idempotency.MakeRegistry()
+	registry.Register(http.MethodPost, "/rides", workflowNamed("ride_reservation"))
+	registry.Register(http.MethodPost, "/rides/{id}/refunds", workflowNamed("ride_refund"))
+	registry.Register(http.MethodPost, "/rides/{id}/{action}", workflowNamed("ride_action"))
+	registry.Register(http.MethodPost, "/rides/latest/refunds", workflowNamed("latest_ride_refund"))
+
+	tests := []struct {
+		desc   string
+		method idempotency.RequestMethod
+		path   string
+
+		expectedWorkflow string
+		expectedOK       bool
+	}{
+		{
+			desc:   "happy path: path without wildcards",
+			method: http.MethodPost,
+			path:   "/rides",
+
+			expectedWorkflow: "ride_reservation",
+			expectedOK:       true,
+		},
+		{
+			desc:   "happy path: wildcard matches a segment",
+			method: http.MethodPost,
+			path:   "/rides/123/refunds",
+
+			expectedWorkflow: "ride_refund",
+			expectedOK:       true,
+		},
+		{
+			desc:   "happy path: route without wildcards takes precedence",
+			method: http.MethodPost,
+			path:   "/rides/latest/refunds",
+
+			expectedWorkflow: "latest_ride_refund",
+			expectedOK:       true,
+		},
+		{
+			desc:   "happy path: pattern registered later matches what earlier ones don't",
+			method: http.MethodPost,
+			path:   "/rides/123/cancel",
+
+			expectedWorkflow: "ride_action",
+			expectedOK:       true,
+		},
+		{
+			desc:   "error path: wildcard doesn't match an empty segment",
+			method: http.MethodPost,
+			path:   "/rides//refunds",
+		},
+		{
+			desc:   "error path: wildcard doesn't match several segments",
+			method: http.MethodPost,
+			path:   "/rides/123/456/refunds",
+		},
+		{
+			desc:   "error path: other method",
+			method: http.MethodPut,
+			path:   "/rides/123/refunds",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			workflowFunc, ok := registry.Lookup(tc.method, tc.path)
+			assert.Equal(t, tc.expectedOK, ok)
+			if !tc.expectedOK {
+				return
+			}
+			_, err := workflowFunc(nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedWorkflow, called)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"github.com/anmho/idempotent-rides/metrics"
+	"github.com/anmho/idempotent-rides/scope"
+	"github.com/jackc/pgx/v5/pgconn"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	// DefaultMaxRetries is how many times a transaction that lost a serialization
+	// conflict or deadlock is retried before the error is returned.
+	DefaultMaxRetries = 5
+	// DefaultRetryBaseDelay is the upper bound of the first backoff. It doubles
+	// on every retry and the actual delay is picked at random below it.
+	DefaultRetryBaseDelay = 10 * time.Millisecond
+)
+
+// SQLSTATE codes of errors that are resolved by running the transaction again.
+const (
+	serializationFailureCode = "40001"
+	deadlockDetectedCode     = "40P01"
+)
+
+// IsRetryable reports whether err is a Postgres serialization failure or deadlock.
+func IsRetryable(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == serializationFailureCode || pgErr.Code == deadlockDetectedCode
+}
+
+func (c Config) maxRetries() int {
+	if c.MaxRetries == 0 {
+		return DefaultMaxRetries
+	}
+	return max(c.MaxRetries, 0)
+}
+
+func (c Config) retryBaseDelay() time.Duration {
+	if c.RetryBaseDelay <= 0 {
+		return DefaultRetryBaseDelay
+	}
+	return c.RetryBaseDelay
+}
+
+// withRetries runs attempt until it succeeds, fails with an error that is not
+// retryable, or runs out of retries. Retries back off exponentially with full jitter
+// so that the duplicate requests that caused the conflict don't collide again.
+func withRetries[T any](ctx context.Context, cfg Config, name string, attempt func() (T, error)) (T, error) {
+	var retries int
+	for {
+		v, err := attempt()
+		if err == nil || !IsRetryable(err) {
+			if retries > 0 {
+				scope.GetLogger().Info("transaction retried",
+					slog.String("name", name),
+					slog.Int("retries", retries),
+					slog.Bool("succeeded", err == nil),
+				)
+			}
+			return v, err
+		}
+
+		if retries >= cfg.maxRetries() {
+			metrics.TransactionRetriesExhausted.Add(name, 1)
+			scope.GetLogger().Error("transaction retries exhausted",
+				slog.String("name", name),
+				slog.Int("retries", retries),
+				slog.Any("cause", err),
+			)
+			return v, err
+		}
+
+		delay := time.Duration(rand.Int64N(int64(cfg.retryBaseDelay() << retries)))
+		retries++
+		metrics.TransactionRetries.Add(name, 1)
+
+		select {
+		case <-ctx.Done():
+			return v, errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_withRetries(t *testing.T) {
+	t.Parallel()
+
+	serializationFailure := fmt.Errorf("committing: %w", &pgconn.PgError{Code: serializationFailureCode})
+	deadlock := &pgconn.PgError{Code: deadlockDetectedCode}
+	uniqueViolation := &pgconn.PgError{Code: "23505"}
+
+	tests := []struct {
+		desc   string
+		cfg    Config
+		errors []error
+
+		expectedErr      error
+		expectedAttempts int
+	}{
+		{
+			desc:   "happy path: serialization failures and deadlocks are retried until the attempt succeeds",
+			cfg:    Config{RetryBaseDelay: time.Microsecond},
+			errors: []error{serializationFailure, deadlock, nil},
+
+			expectedAttempts: 3,
+		},
+		{
+			desc:   "error path: other errors are returned without retrying",
+			cfg:    Config{RetryBaseDelay: time.Microsecond},
+			errors: []error{uniqueViolation},
+
+			expectedErr:      uniqueViolation,
+			expectedAttempts: 1,
+		},
+		{
+			desc:   "error path: retries are bounded by MaxRetries",
+			cfg:    Config{MaxRetries: 2, RetryBaseDelay: time.Microsecond},
+			errors: []error{deadlock, deadlock, deadlock, nil},
+
+			expectedErr:      deadlock,
+			expectedAttempts: 3,
+		},
+		{
+			desc:   "error path: negative MaxRetries turns retries off",
+			cfg:    Config{MaxRetries: -1},
+			errors: []error{deadlock, nil},
+
+			expectedErr:      deadlock,
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var attempts int
+			v, err := withRetries(context.Background(), tc.cfg, "test", func() (int, error) {
+				err := tc.errors[attempts]
+				attempts++
+				return attempts, err
+			})
+
+			assert.Equal(t, tc.expectedAttempts, attempts)
+			if tc.expectedErr != nil {
+				assert.True(t, errors.Is(err, tc.expectedErr))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedAttempts, v)
+			}
+		})
+	}
+}
+
+func Test_IsRetryable(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, IsRetryable(&pgconn.PgError{Code: serializationFailureCode}))
+	assert.True(t, IsRetryable(fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: deadlockDetectedCode})))
+	assert.False(t, IsRetryable(&pgconn.PgError{Code: "23505"}))
+	assert.False(t, IsRetryable(errors.New("connection refused")))
+	assert.False(t, IsRetryable(nil))
+}
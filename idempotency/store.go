@@ -0,0 +1,57 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ErrNoSQL is returned when a workflow or handler that writes rows of its own runs on
+// a store that is not backed by Postgres.
+var ErrNoSQL = errors.New("idempotency store has no SQL transaction")
+
+// Tx is a transaction opened by a KeyStore.
+type Tx interface {
+	// SQL returns the Postgres transaction that phases write their own rows in, so
+	// that they commit atomically with the key. It is nil for stores that are not
+	// backed by Postgres, like the memory store, which can only run workflows whose
+	// phases keep to the key. Workflows that need it call Workflow.RequireSQL so
+	// that they fail with ErrNoSQL there instead of using a nil transaction.
+	SQL() *sql.Tx
+	Commit() error
+	Rollback() error
+}
+
+// KeyStore persists idempotency keys. Transactions opened by Begin are serializable:
+// a key read in a transaction can't be changed by anyone else before it commits.
+// Lookups of keys that don't exist fail with an error matching sql.ErrNoRows.
+type KeyStore interface {
+	Begin(ctx context.Context) (Tx, error)
+
+	FindKey(ctx context.Context, tx Tx, userID int, key string) (*Key, error)
+	FindKeyByID(ctx context.Context, tx Tx, id int) (*Key, error)
+	InsertKey(ctx context.Context, tx Tx, params KeyParams) (*Key, error)
+	UpdateKey(ctx context.Context, tx Tx, key *Key) (*Key, error)
+	DeleteKey(ctx context.Context, tx Tx, key *Key) error
+
+	// UnlockKey releases the lock on a key outside of any transaction, unless the key
+	// was locked again since lockedAt.
+	UnlockKey(ctx context.Context, keyID int, lockedAt time.Time) error
+	// FindAbandonedKeys returns up to limit unfinished keys that last ran before
+	// lastRunBefore, are unlocked or were locked before lockedBefore, and have run
+	// fewer than maxAttempts times, least recently run first.
+	FindAbandonedKeys(ctx context.Context, lastRunBefore, lockedBefore time.Time, maxAttempts, limit int) ([]*Key, error)
+	// FindStuckKeys returns up to limit unfinished keys that last ran before
+	// lastRunBefore, least recently run first. An empty recoveryPoint matches keys at
+	// any recovery point.
+	FindStuckKeys(ctx context.Context, recoveryPoint RecoveryPointEnum, lastRunBefore time.Time, limit int) ([]*Key, error)
+	// DeleteKeysCreatedBefore deletes up to limit keys created before cutoff and
+	// returns how many were deleted.
+	DeleteKeysCreatedBefore(ctx context.Context, cutoff time.Time, limit int) (int, error)
+
+	// InsertTransition records a run of an atomic phase of a key.
+	InsertTransition(ctx context.Context, tx Tx, transition *Transition) (*Transition, error)
+	// FindTransitions returns the timeline of a key, oldest transition first.
+	FindTransitions(ctx context.Context, keyID int) ([]*Transition, error)
+}
@@ -0,0 +1,89 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Transition records one run of an atomic phase of a key. Successful phases are
+// written in the same transaction that moves the key to To. Failed phases are written
+// afterwards with the error and To equal to From, since their transaction rolled back.
+// Terminal errors are recorded with the transition that finishes the key.
+type Transition struct {
+	ID        int
+	KeyID     int
+	CreatedAt time.Time
+	From      RecoveryPointEnum
+	To        RecoveryPointEnum
+	// Duration is the time spent in the phase, including retries.
+	Duration time.Duration
+	// Attempts counts the transactions the phase took, at least 1.
+	Attempts int
+	Error    sql.Null[string]
+}
+
+// Failed reports whether the phase ended with an error. A phase that failed with a
+// *TerminalError still moved the key to FinishedRecoveryPoint.
+func (t *Transition) Failed() bool {
+	return t.Error.Valid
+}
+
+func InsertTransition(ctx context.Context, tx *sql.Tx, transition *Transition) (*Transition, error) {
+	stmt, err := tx.PrepareContext(ctx,
+		`
+		INSERT INTO idempotency_key_transitions (
+			idempotency_key_id,
+			from_recovery_point, to_recovery_point,
+			duration_ms, attempts, error
+		) VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING
+			id, idempotency_key_id, created_at,
+			from_recovery_point, to_recovery_point,
+			duration_ms, attempts, error
+		;`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("preparing statement: %w", err)
+	}
+	defer stmt.Close()
+
+	var inserted Transition
+	row := stmt.QueryRowContext(ctx,
+		transition.KeyID,
+		transition.From, transition.To,
+		transition.Duration.Milliseconds(), transition.Attempts, transition.Error,
+	)
+	if err = scanTransition(row, &inserted); err != nil {
+		return nil, fmt.Errorf("inserting transition: %w", err)
+	}
+	return &inserted, nil
+}
+
+func scanTransition(row rowScanner, transition *Transition) error {
+	var durationMs int64
+	err := row.Scan(
+		&transition.ID, &transition.KeyID, &transition.CreatedAt,
+		&transition.From, &transition.To,
+		&durationMs, &transition.Attempts, &transition.Error,
+	)
+	transition.Duration = time.Duration(durationMs) * time.Millisecond
+	return err
+}
+
+// newTransition describes a run of the phase that continues from key's recovery point
+// that started at started. cause is nil for phases that succeeded.
+func newTransition(key *Key, to RecoveryPointEnum, started time.Time, attempts int, cause error) *Transition {
+	transition := &Transition{
+		KeyID:    key.ID,
+		From:     key.RecoveryPoint,
+		To:       to,
+		Duration: time.Since(started),
+		Attempts: max(attempts, 1),
+	}
+	if cause != nil {
+		transition.Error = sql.Null[string]{V: cause.Error(), Valid: true}
+	}
+	return transition
+}
@@ -0,0 +1,153 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/anmho/idempotent-rides/scope"
+	"log/slog"
+	"slices"
+)
+
+// PhaseFunc is a single atomic phase of an idempotent request. It runs inside the
+// transaction opened by AtomicPhase and returns the result that moves the key to
+// its next recovery point.
+type PhaseFunc func(ctx context.Context, tx *sql.Tx, key *Key) (AtomicPhaseResult, error)
+
+type phase struct {
+	run PhaseFunc
+	// next holds the recovery points the phase is allowed to move the key to.
+	next []RecoveryPointEnum
+}
+
+// Workflow is a named, multi-phase request. Every recovery point a key can be at,
+// except FinishedRecoveryPoint, has exactly one phase that continues from it and a
+// list of recovery points that phase may move the key to.
+//
+//	MakeWorkflow("charge").
+//		Phase(StartedRecoveryPoint, createCharge, "charge_created").
+//		Phase("charge_created", respond, FinishedRecoveryPoint)
+type Workflow struct {
+	Name   string
+	phases map[RecoveryPointEnum]phase
+	// requireSQL is set by RequireSQL.
+	requireSQL bool
+}
+
+func MakeWorkflow(name string) *Workflow {
+	return &Workflow{
+		Name:   name,
+		phases: make(map[RecoveryPointEnum]phase),
+	}
+}
+
+// Phase registers run as the phase that continues from recovery point from. run may
+// only move the key to one of the recovery points in next.
+func (w *Workflow) Phase(from RecoveryPointEnum, run PhaseFunc, next ...RecoveryPointEnum) *Workflow {
+	w.phases[from] = phase{run: run, next: next}
+	return w
+}
+
+// RequireSQL makes the phases of the workflow fail with ErrNoSQL, before they run,
+// on a store whose transactions have no SQL transaction for them to write in.
+func (w *Workflow) RequireSQL() *Workflow {
+	w.requireSQL = true
+	return w
+}
+
+// Validate checks that the workflow starts at StartedRecoveryPoint and that every
+// transition leads to a registered phase or to FinishedRecoveryPoint.
+func (w *Workflow) Validate() error {
+	if _, ok := w.phases[StartedRecoveryPoint]; !ok {
+		return fmt.Errorf("workflow %s: no phase for %s", w.Name, StartedRecoveryPoint)
+	}
+	if _, ok := w.phases[FinishedRecoveryPoint]; ok {
+		return fmt.Errorf("workflow %s: %s can not have a phase", w.Name, FinishedRecoveryPoint)
+	}
+
+	for from, p := range w.phases {
+		if !from.IsValid() {
+			return fmt.Errorf("workflow %s: invalid recovery point %q", w.Name, from)
+		}
+		if p.run == nil {
+			return fmt.Errorf("workflow %s: nil phase for %s", w.Name, from)
+		}
+		if len(p.next) == 0 {
+			return fmt.Errorf("workflow %s: phase %s has no transitions", w.Name, from)
+		}
+		for _, to := range p.next {
+			if to == from {
+				return fmt.Errorf("workflow %s: phase %s can not transition to itself", w.Name, from)
+			}
+			if _, ok := w.phases[to]; !ok && to != FinishedRecoveryPoint {
+				return fmt.Errorf("workflow %s: phase %s transitions to unknown recovery point %s", w.Name, from, to)
+			}
+		}
+	}
+	return nil
+}
+
+// Run executes the phases of the workflow from the key's recovery point, one
+// AtomicPhase each, until the key reaches FinishedRecoveryPoint.
+func (w *Workflow) Run(ctx context.Context, store KeyStore, cfg Config, key *Key) (*Key, error) {
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+
+	for key.RecoveryPoint != FinishedRecoveryPoint {
+		p, ok := w.phases[key.RecoveryPoint]
+		if !ok {
+			return nil, fmt.Errorf("workflow %s: unknown recovery point %s", w.Name, key.RecoveryPoint)
+		}
+		scope.GetLogger().Info("running atomic phase",
+			slog.String("workflow", w.Name),
+			slog.String("recoveryPoint", key.RecoveryPoint.String()),
+		)
+
+		current := key
+		updatedKey, err := AtomicPhase(ctx, current, store, cfg,
+			func(tx *sql.Tx) (AtomicPhaseResult, error) {
+				if w.requireSQL && tx == nil {
+					return nil, fmt.Errorf("workflow %s: %w", w.Name, ErrNoSQL)
+				}
+				result, err := p.run(ctx, tx, current)
+				if err != nil {
+					return nil, err
+				}
+
+				to, err := nextRecoveryPoint(result, current.RecoveryPoint)
+				if err != nil {
+					return nil, err
+				}
+				if !slices.Contains(p.next, to) {
+					return nil, fmt.Errorf("workflow %s: transition from %s to %s is not allowed", w.Name, current.RecoveryPoint, to)
+				}
+				return result, nil
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if updatedKey == nil {
+			return nil, errors.New("nil key when executing phases")
+		}
+		key = updatedKey
+	}
+
+	return key, nil
+}
+
+// nextRecoveryPoint returns the recovery point the key will be at once result is applied.
+func nextRecoveryPoint(result AtomicPhaseResult, current RecoveryPointEnum) (RecoveryPointEnum, error) {
+	switch r := result.(type) {
+	case *NoOpResult:
+		return current, nil
+	case *RecoveryPointResult:
+		return r.RecoveryPoint, nil
+	case *ResponseResult:
+		return FinishedRecoveryPoint, nil
+	default:
+		return "", errors.New("invalid atomic result type")
+	}
+}
@@ -0,0 +1,79 @@
+package idempotency_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/anmho/idempotent-rides/idempotency"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWorkflow_Validate(t *testing.T) {
+	t.Parallel()
+
+	noop := func(ctx context.Context, tx *sql.Tx, key *idempotency.Key) (idempotency.AtomicPhaseResult, error) {
+		return &idempotency.NoOpResult{}, nil
+	}
+
+	tests := []struct {
+		desc     string
+		workflow *idempotency.Workflow
+
+		expectedErr bool
+	}{
+		{
+			desc: "happy path: linear workflow with a custom recovery point",
+			workflow: idempotency.MakeWorkflow("refund").
+				Phase(idempotency.StartedRecoveryPoint, noop, "refund_created").
+				Phase("refund_created", noop, idempotency.FinishedRecoveryPoint),
+		},
+		{
+			desc: "happy path: phase with more than one allowed transition",
+			workflow: idempotency.MakeWorkflow("cancel").
+				Phase(idempotency.StartedRecoveryPoint, noop, "cancelled", idempotency.FinishedRecoveryPoint).
+				Phase("cancelled", noop, idempotency.FinishedRecoveryPoint),
+		},
+		{
+			desc: "error path: workflow without a started phase",
+			workflow: idempotency.MakeWorkflow("headless").
+				Phase("refund_created", noop, idempotency.FinishedRecoveryPoint),
+			expectedErr: true,
+		},
+		{
+			desc: "error path: transition to a recovery point without a phase",
+			workflow: idempotency.MakeWorkflow("dangling").
+				Phase(idempotency.StartedRecoveryPoint, noop, "refund_created"),
+			expectedErr: true,
+		},
+		{
+			desc: "error path: phase without transitions",
+			workflow: idempotency.MakeWorkflow("stuck").
+				Phase(idempotency.StartedRecoveryPoint, noop),
+			expectedErr: true,
+		},
+		{
+			desc: "error path: phase that transitions to itself",
+			workflow: idempotency.MakeWorkflow("loop").
+				Phase(idempotency.StartedRecoveryPoint, noop, idempotency.StartedRecoveryPoint, idempotency.FinishedRecoveryPoint),
+			expectedErr: true,
+		},
+		{
+			desc: "error path: phase registered for the finished recovery point",
+			workflow: idempotency.MakeWorkflow("after_finish").
+				Phase(idempotency.StartedRecoveryPoint, noop, idempotency.FinishedRecoveryPoint).
+				Phase(idempotency.FinishedRecoveryPoint, noop, idempotency.StartedRecoveryPoint),
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := tc.workflow.Validate()
+			if tc.expectedErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
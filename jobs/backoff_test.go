@@ -0,0 +1,45 @@
+package jobs
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func Test_backoff(t *testing.T) {
+	tests := []struct {
+		desc     string
+		attempts int
+
+		expectedLimit time.Duration
+	}{
+		{
+			desc:     "happy path: first attempt is bounded by the base",
+			attempts: 1,
+
+			expectedLimit: time.Second,
+		},
+		{
+			desc:     "happy path: bound doubles with every attempt",
+			attempts: 4,
+
+			expectedLimit: 8 * time.Second,
+		},
+		{
+			desc:     "happy path: bound is capped",
+			attempts: 1000,
+
+			expectedLimit: time.Minute,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			for range 100 {
+				delay := backoff(time.Second, time.Minute, tc.attempts)
+				assert.GreaterOrEqual(t, delay, time.Duration(0))
+				assert.Less(t, delay, tc.expectedLimit)
+			}
+		})
+	}
+}
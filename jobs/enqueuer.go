@@ -0,0 +1,117 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/anmho/idempotent-rides/scope"
+	"log/slog"
+	"time"
+)
+
+const (
+	// DefaultEnqueueBatchSize bounds how many staged jobs are moved in one
+	// transaction.
+	DefaultEnqueueBatchSize = 1000
+	// DefaultEnqueueInterval is how often a long-running enqueuer wakes up. Staged
+	// jobs wait at most this long before they can be worked.
+	DefaultEnqueueInterval = time.Second
+)
+
+// EnqueuerConfig controls how staged jobs are moved to the work queue.
+type EnqueuerConfig struct {
+	BatchSize int
+	Interval  time.Duration
+}
+
+// Enqueuer moves committed staged jobs into the work queue. Each batch is deleted
+// from staged_jobs and inserted into jobs in a single statement, so a job is
+// enqueued exactly once even with several enqueuers running.
+type Enqueuer struct {
+	db  *sql.DB
+	cfg EnqueuerConfig
+}
+
+func MakeEnqueuer(db *sql.DB, cfg EnqueuerConfig) *Enqueuer {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultEnqueueBatchSize
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultEnqueueInterval
+	}
+	return &Enqueuer{db: db, cfg: cfg}
+}
+
+// Run enqueues staged jobs every interval until ctx is cancelled.
+func (e *Enqueuer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := e.EnqueueOnce(ctx); err != nil {
+			scope.GetLogger().Error("enqueuing staged jobs", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// EnqueueOnce moves every staged job to the work queue in batches, oldest first, and
+// returns how many were moved.
+func (e *Enqueuer) EnqueueOnce(ctx context.Context) (int, error) {
+	var total int
+	for {
+		n, err := e.enqueueBatch(ctx)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < e.cfg.BatchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		scope.GetLogger().Info("enqueued staged jobs", slog.Int("count", total))
+	}
+	return total, nil
+}
+
+func (e *Enqueuer) enqueueBatch(ctx context.Context) (int, error) {
+	result, err := e.db.ExecContext(ctx,
+		`
+		WITH staged AS (
+			DELETE FROM staged_jobs
+			WHERE id IN (
+				SELECT id
+				FROM staged_jobs
+				ORDER BY id
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id, job_name, job_args
+		)
+		INSERT INTO jobs (job_name, job_args)
+		SELECT job_name, job_args
+		FROM staged
+		ORDER BY id
+		;`,
+		e.cfg.BatchSize,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
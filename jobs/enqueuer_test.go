@@ -0,0 +1,103 @@
+package jobs_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/anmho/idempotent-rides/jobs"
+	"github.com/anmho/idempotent-rides/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type testJobArgs struct {
+	RideID int `json:"ride_id"`
+}
+
+func countRows(t *testing.T, db *sql.DB, table string) int {
+	var n int
+	err := db.QueryRow("SELECT count(*) FROM " + table).Scan(&n)
+	require.NoError(t, err)
+	return n
+}
+
+func TestEnqueuer_EnqueueOnce(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc       string
+		committed  int
+		rolledBack int
+		cfg        jobs.EnqueuerConfig
+
+		expectedEnqueued int
+	}{
+		{
+			desc:      "happy path: no staged jobs",
+			committed: 0,
+
+			expectedEnqueued: 0,
+		},
+		{
+			desc:      "happy path: committed jobs are enqueued across several batches",
+			committed: 5,
+			cfg:       jobs.EnqueuerConfig{BatchSize: 2},
+
+			expectedEnqueued: 5,
+		},
+		{
+			desc:       "happy path: jobs staged in a rolled back transaction are never enqueued",
+			committed:  1,
+			rolledBack: 2,
+
+			expectedEnqueued: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			db := test.MakePostgres(t)
+			ctx := context.Background()
+			jobService := jobs.MakeService()
+
+			tx := test.MakeTx(t, ctx, db)
+			for i := range tc.committed {
+				_, err := jobService.StageJob(ctx, tx, "test_job", testJobArgs{RideID: i})
+				require.NoError(t, err)
+			}
+			require.NoError(t, tx.Commit())
+
+			tx = test.MakeTx(t, ctx, db)
+			for i := range tc.rolledBack {
+				_, err := jobService.StageJob(ctx, tx, "test_job", testJobArgs{RideID: i})
+				require.NoError(t, err)
+			}
+			require.NoError(t, tx.Rollback())
+
+			enqueuer := jobs.MakeEnqueuer(db, tc.cfg)
+			n, err := enqueuer.EnqueueOnce(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedEnqueued, n)
+			assert.Equal(t, tc.expectedEnqueued, countRows(t, db, "jobs"))
+			assert.Zero(t, countRows(t, db, "staged_jobs"))
+		})
+	}
+}
+
+func TestService_StageJob(t *testing.T) {
+	t.Parallel()
+
+	db := test.MakePostgres(t)
+	ctx := context.Background()
+	tx := test.MakeTx(t, ctx, db)
+	defer tx.Rollback()
+
+	job, err := jobs.MakeService().StageJob(ctx, tx, "test_job", testJobArgs{RideID: 123})
+	require.NoError(t, err)
+	assert.Positive(t, job.ID)
+	assert.Equal(t, "test_job", job.Name)
+	assert.JSONEq(t, `{"ride_id": 123}`, string(job.Args))
+
+	_, err = jobs.MakeService().StageJob(ctx, tx, "", testJobArgs{})
+	assert.Error(t, err)
+}
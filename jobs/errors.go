@@ -0,0 +1,25 @@
+package jobs
+
+import "fmt"
+
+// PermanentError is returned by a handler when running the job again can't help, like
+// an email address that doesn't exist. The job is dead-lettered right away instead of
+// being retried.
+type PermanentError struct {
+	Cause error
+}
+
+var _ error = (*PermanentError)(nil)
+
+// Permanent marks cause as a failure that must not be retried.
+func Permanent(cause error) *PermanentError {
+	return &PermanentError{Cause: cause}
+}
+
+func (e *PermanentError) Error() string {
+	return fmt.Sprintf("permanent error: %v", e.Cause)
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Cause
+}
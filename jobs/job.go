@@ -1,4 +1,31 @@
 package jobs
 
+import (
+	"database/sql"
+	"time"
+)
+
+// StagedJob is a job staged in the transaction of an atomic phase. The enqueuer only
+// sees it once that transaction commits, so a job is never worked for a phase that
+// rolled back.
 type StagedJob struct {
+	ID   int
+	Name string
+	// Args is the JSON encoded arguments of the job.
+	Args []byte
+}
+
+// Job is a job in the work queue.
+type Job struct {
+	ID        int
+	CreatedAt time.Time
+	Name      string
+	// Args is the JSON encoded arguments of the job.
+	Args []byte
+
+	RunAt       time.Time
+	LockedUntil sql.Null[time.Time]
+	// Attempts counts the times the job was leased, including the current one.
+	Attempts  int
+	LastError sql.Null[string]
 }
@@ -0,0 +1,53 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+type Service interface {
+	// StageJob stages a job named name with args encoded as JSON in tx. Phases call
+	// it with their transaction so the job is only emitted if the phase commits.
+	StageJob(ctx context.Context, tx *sql.Tx, name string, args any) (*StagedJob, error)
+}
+
+func MakeService() Service {
+	return &service{}
+}
+
+var _ Service = (*service)(nil)
+
+type service struct {
+}
+
+func (s *service) StageJob(ctx context.Context, tx *sql.Tx, name string, args any) (*StagedJob, error) {
+	if name == "" {
+		return nil, errors.New("job name must not be empty")
+	}
+	b, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("encoding job args: %w", err)
+	}
+
+	row := tx.QueryRowContext(ctx,
+		`
+	INSERT INTO rocket_rides.public.staged_jobs (
+		job_name, job_args
+	) VALUES (
+		$1, $2
+	) RETURNING
+		id, job_name, job_args
+	;
+	`,
+		name, b,
+	)
+
+	var job StagedJob
+	if err = row.Scan(&job.ID, &job.Name, &job.Args); err != nil {
+		return nil, fmt.Errorf("staging job: %w", err)
+	}
+	return &job, nil
+}
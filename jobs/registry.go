@@ -0,0 +1,38 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// HandlerFunc works a job. Jobs are retried when it fails, unless the error is a
+// *PermanentError.
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+// Registry maps job names to the handlers that work them.
+type Registry struct {
+	handlers map[string]HandlerFunc
+}
+
+func MakeRegistry() *Registry {
+	return &Registry{handlers: make(map[string]HandlerFunc)}
+}
+
+// Register registers handle as the handler of jobs named name. The args of the job are
+// decoded into T; jobs whose args can't be decoded fail permanently.
+func Register[T any](r *Registry, name string, handle func(ctx context.Context, args T) error) {
+	r.handlers[name] = func(ctx context.Context, job *Job) error {
+		var args T
+		if err := json.Unmarshal(job.Args, &args); err != nil {
+			return Permanent(fmt.Errorf("decoding args of job %s: %w", name, err))
+		}
+		return handle(ctx, args)
+	}
+}
+
+// Lookup returns the handler of jobs named name.
+func (r *Registry) Lookup(name string) (HandlerFunc, bool) {
+	h, ok := r.handlers[name]
+	return h, ok
+}
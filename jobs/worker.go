@@ -0,0 +1,276 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/anmho/idempotent-rides/metrics"
+	"github.com/anmho/idempotent-rides/scope"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	// DefaultWorkBatchSize is how many jobs WorkOnce works at most.
+	DefaultWorkBatchSize = 10
+	// DefaultWorkInterval is how often an idle worker polls for jobs.
+	DefaultWorkInterval = time.Second
+	// DefaultLeaseDuration is how long a worker holds a job. A job whose lease runs
+	// out belonged to a worker that crashed or hung and is leased again.
+	DefaultLeaseDuration = 5 * time.Minute
+	// DefaultMaxAttempts is how many times a job is tried before it is dead-lettered.
+	DefaultMaxAttempts = 10
+	// DefaultBackoffBase is the upper bound of the backoff after the first failure.
+	// It doubles with every attempt and the actual delay is picked at random below it.
+	DefaultBackoffBase = 10 * time.Second
+	// DefaultMaxBackoff caps the backoff between attempts.
+	DefaultMaxBackoff = time.Hour
+)
+
+// WorkerConfig controls how jobs are leased and retried.
+type WorkerConfig struct {
+	BatchSize     int
+	Interval      time.Duration
+	LeaseDuration time.Duration
+	MaxAttempts   int
+	BackoffBase   time.Duration
+	MaxBackoff    time.Duration
+}
+
+// Worker leases jobs from the work queue and dispatches them to the handler
+// registered for their name. Jobs are leased with FOR UPDATE SKIP LOCKED, so any
+// number of workers can run side by side.
+type Worker struct {
+	db       *sql.DB
+	registry *Registry
+	cfg      WorkerConfig
+}
+
+func MakeWorker(db *sql.DB, registry *Registry, cfg WorkerConfig) *Worker {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultWorkBatchSize
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultWorkInterval
+	}
+	if cfg.LeaseDuration <= 0 {
+		cfg.LeaseDuration = DefaultLeaseDuration
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.BackoffBase <= 0 {
+		cfg.BackoffBase = DefaultBackoffBase
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	return &Worker{db: db, registry: registry, cfg: cfg}
+}
+
+// Run works jobs until ctx is cancelled. It only waits for the next interval when
+// the queue has run dry.
+func (w *Worker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := w.WorkOnce(ctx)
+		if err != nil {
+			scope.GetLogger().Error("working jobs", slog.Any("error", err))
+		}
+		if err == nil && n == w.cfg.BatchSize && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// WorkOnce works up to a batch of jobs that are due, one after the other, and returns
+// how many were leased. Each job is leased right before it runs rather than with the
+// batch, so that its lease can't run out while the jobs before it are worked and let
+// another worker run it at the same time.
+func (w *Worker) WorkOnce(ctx context.Context) (int, error) {
+	var n int
+	for n < w.cfg.BatchSize {
+		job, err := w.lease(ctx)
+		if err != nil {
+			return n, fmt.Errorf("leasing job: %w", err)
+		}
+		if job == nil {
+			break
+		}
+		n++
+
+		if err = w.work(ctx, job); err != nil {
+			// The job keeps its lease and is picked up again once it runs out.
+			scope.GetLogger().Error("finishing job",
+				slog.Int("jobID", job.ID),
+				slog.String("jobName", job.Name),
+				slog.Any("error", err),
+			)
+		}
+	}
+	return n, nil
+}
+
+// lease leases the next job that is due, or returns nil when there is none.
+func (w *Worker) lease(ctx context.Context) (*Job, error) {
+	now := time.Now()
+	row := w.db.QueryRowContext(ctx,
+		`
+		UPDATE jobs
+		SET
+			locked_until = $1,
+			attempts = attempts + 1
+		WHERE id = (
+			SELECT id
+			FROM jobs
+			WHERE
+				run_at <= $2
+				AND (locked_until IS NULL OR locked_until < $2)
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING
+			id, created_at, job_name, job_args,
+			run_at, locked_until, attempts, last_error
+		;`,
+		now.Add(w.cfg.LeaseDuration), now,
+	)
+
+	var job Job
+	err := row.Scan(
+		&job.ID, &job.CreatedAt, &job.Name, &job.Args,
+		&job.RunAt, &job.LockedUntil, &job.Attempts, &job.LastError,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// work runs the handler of job within its lease and records the outcome. Outcomes are
+// only recorded while the job is still at the attempt it was leased at, so a worker
+// whose lease ran out can't overwrite the outcome of the worker that took over.
+func (w *Worker) work(ctx context.Context, job *Job) error {
+	err := w.run(ctx, job)
+	if err == nil {
+		metrics.JobsSucceeded.Add(job.Name, 1)
+		return w.complete(ctx, job)
+	}
+
+	var permanentErr *PermanentError
+	if errors.As(err, &permanentErr) || job.Attempts >= w.cfg.MaxAttempts {
+		metrics.JobsDeadLettered.Add(job.Name, 1)
+		scope.GetLogger().Error("dead-lettering job",
+			slog.Int("jobID", job.ID),
+			slog.String("jobName", job.Name),
+			slog.Int("attempts", job.Attempts),
+			slog.Any("error", err),
+		)
+		return w.deadLetter(ctx, job, err)
+	}
+
+	metrics.JobsRetried.Add(job.Name, 1)
+	delay := backoff(w.cfg.BackoffBase, w.cfg.MaxBackoff, job.Attempts)
+	scope.GetLogger().Warn("retrying job",
+		slog.Int("jobID", job.ID),
+		slog.String("jobName", job.Name),
+		slog.Int("attempts", job.Attempts),
+		slog.Duration("delay", delay),
+		slog.Any("error", err),
+	)
+	return w.retry(ctx, job, time.Now().Add(delay), err)
+}
+
+func (w *Worker) run(ctx context.Context, job *Job) (err error) {
+	handler, ok := w.registry.Lookup(job.Name)
+	if !ok {
+		// Retried rather than dead-lettered right away, since a worker that knows
+		// the job may not have been deployed yet.
+		return fmt.Errorf("no handler for job %s", job.Name)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job %s panicked: %v", job.Name, r)
+		}
+	}()
+
+	// The handler has to give up when the lease runs out, since the job may be
+	// leased by another worker from then on.
+	ctx, cancel := context.WithDeadline(ctx, job.LockedUntil.V)
+	defer cancel()
+	return handler(ctx, job)
+}
+
+func (w *Worker) complete(ctx context.Context, job *Job) error {
+	_, err := w.db.ExecContext(ctx,
+		`
+		DELETE FROM jobs
+		WHERE id = $1 AND attempts = $2
+		;`,
+		job.ID, job.Attempts,
+	)
+	return err
+}
+
+func (w *Worker) retry(ctx context.Context, job *Job, runAt time.Time, cause error) error {
+	_, err := w.db.ExecContext(ctx,
+		`
+		UPDATE jobs
+		SET
+			run_at = $2,
+			locked_until = NULL,
+			last_error = $3
+		WHERE id = $1 AND attempts = $4
+		;`,
+		job.ID, runAt, cause.Error(), job.Attempts,
+	)
+	return err
+}
+
+// deadLetter moves job to dead_jobs in a single statement.
+func (w *Worker) deadLetter(ctx context.Context, job *Job, cause error) error {
+	_, err := w.db.ExecContext(ctx,
+		`
+		WITH dead AS (
+			DELETE FROM jobs
+			WHERE id = $1 AND attempts = $2
+			RETURNING id, created_at, job_name, job_args, attempts
+		)
+		INSERT INTO dead_jobs (
+			id, created_at, job_name, job_args, attempts, last_error
+		)
+		SELECT id, created_at, job_name, job_args, attempts, $3
+		FROM dead
+		;`,
+		job.ID, job.Attempts, cause.Error(),
+	)
+	return err
+}
+
+// backoff returns the delay before the next attempt of a job that failed attempts
+// times. It grows exponentially with full jitter, capped at maxDelay.
+func backoff(base, maxDelay time.Duration, attempts int) time.Duration {
+	limit := base
+	for i := 1; i < attempts && limit < maxDelay; i++ {
+		limit *= 2
+	}
+	return time.Duration(rand.Int64N(int64(min(limit, maxDelay))))
+}
@@ -0,0 +1,173 @@
+package jobs_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/anmho/idempotent-rides/jobs"
+	"github.com/anmho/idempotent-rides/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func enqueueJob(t *testing.T, db *sql.DB, name string, args string) int {
+	var id int
+	err := db.QueryRow(`INSERT INTO jobs (job_name, job_args) VALUES ($1, $2) RETURNING id`, name, args).Scan(&id)
+	require.NoError(t, err)
+	return id
+}
+
+func TestWorker_WorkOnce(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		desc    string
+		args    string
+		handler func(ctx context.Context, args testJobArgs) error
+		cfg     jobs.WorkerConfig
+
+		expectedJobs     int
+		expectedDeadJobs int
+		expectedCalls    int
+	}{
+		{
+			desc: "happy path: successful job is deleted",
+			args: `{"ride_id": 123}`,
+			handler: func(ctx context.Context, args testJobArgs) error {
+				if args.RideID != 123 {
+					return errors.New("unexpected args")
+				}
+				return nil
+			},
+
+			expectedJobs:     0,
+			expectedDeadJobs: 0,
+			expectedCalls:    1,
+		},
+		{
+			desc: "error path: failed job is retried later",
+			args: `{"ride_id": 123}`,
+			handler: func(ctx context.Context, args testJobArgs) error {
+				return errors.New("smtp timeout")
+			},
+			cfg: jobs.WorkerConfig{BackoffBase: time.Hour},
+
+			expectedJobs:     1,
+			expectedDeadJobs: 0,
+			expectedCalls:    1,
+		},
+		{
+			desc: "error path: failed job is dead-lettered after max attempts",
+			args: `{"ride_id": 123}`,
+			handler: func(ctx context.Context, args testJobArgs) error {
+				return errors.New("smtp timeout")
+			},
+			cfg: jobs.WorkerConfig{MaxAttempts: 1},
+
+			expectedJobs:     0,
+			expectedDeadJobs: 1,
+			expectedCalls:    1,
+		},
+		{
+			desc: "error path: permanently failed job is dead-lettered right away",
+			args: `{"ride_id": 123}`,
+			handler: func(ctx context.Context, args testJobArgs) error {
+				return jobs.Permanent(errors.New("mailbox does not exist"))
+			},
+
+			expectedJobs:     0,
+			expectedDeadJobs: 1,
+			expectedCalls:    1,
+		},
+		{
+			desc: "error path: job with args that can't be decoded is dead-lettered",
+			args: `{"ride_id": "not a number"}`,
+			handler: func(ctx context.Context, args testJobArgs) error {
+				return nil
+			},
+
+			expectedJobs:     0,
+			expectedDeadJobs: 1,
+			expectedCalls:    0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			db := test.MakePostgres(t)
+			ctx := context.Background()
+
+			var calls int
+			registry := jobs.MakeRegistry()
+			jobs.Register(registry, "test_job", func(ctx context.Context, args testJobArgs) error {
+				calls++
+				return tc.handler(ctx, args)
+			})
+			enqueueJob(t, db, "test_job", tc.args)
+
+			worker := jobs.MakeWorker(db, registry, tc.cfg)
+			n, err := worker.WorkOnce(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+			assert.Equal(t, tc.expectedCalls, calls)
+			assert.Equal(t, tc.expectedJobs, countRows(t, db, "jobs"))
+			assert.Equal(t, tc.expectedDeadJobs, countRows(t, db, "dead_jobs"))
+
+			// A job that is waiting for its retry is not leased again.
+			n, err = worker.WorkOnce(ctx)
+			require.NoError(t, err)
+			assert.Zero(t, n)
+		})
+	}
+}
+
+func TestWorker_WorkOnce_leasePerJob(t *testing.T) {
+	t.Parallel()
+	db := test.MakePostgres(t)
+	ctx := context.Background()
+
+	// Working both jobs takes longer than a lease, but each job is leased right
+	// before it runs, so neither runs out of time.
+	registry := jobs.MakeRegistry()
+	var calls int
+	jobs.Register(registry, "test_job", func(ctx context.Context, args testJobArgs) error {
+		calls++
+		time.Sleep(150 * time.Millisecond)
+		return ctx.Err()
+	})
+	enqueueJob(t, db, "test_job", `{"ride_id": 1}`)
+	enqueueJob(t, db, "test_job", `{"ride_id": 2}`)
+
+	worker := jobs.MakeWorker(db, registry, jobs.WorkerConfig{LeaseDuration: 200 * time.Millisecond})
+	n, err := worker.WorkOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 2, calls)
+	assert.Zero(t, countRows(t, db, "jobs"))
+	assert.Zero(t, countRows(t, db, "dead_jobs"))
+}
+
+func TestWorker_WorkOnce_leaseRunsOut(t *testing.T) {
+	t.Parallel()
+	db := test.MakePostgres(t)
+	ctx := context.Background()
+
+	// The handler is stopped when its lease runs out, since another worker may lease
+	// the job from then on.
+	registry := jobs.MakeRegistry()
+	jobs.Register(registry, "test_job", func(ctx context.Context, args testJobArgs) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	enqueueJob(t, db, "test_job", `{"ride_id": 1}`)
+
+	worker := jobs.MakeWorker(db, registry, jobs.WorkerConfig{LeaseDuration: 100 * time.Millisecond, BackoffBase: time.Hour})
+	started := time.Now()
+	n, err := worker.WorkOnce(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Less(t, time.Since(started), time.Second)
+	assert.Equal(t, 1, countRows(t, db, "jobs"))
+}
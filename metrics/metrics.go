@@ -1 +1,24 @@
 package metrics
+
+import "expvar"
+
+// Counters are published through expvar, so they show up on /debug/vars, which the
+// API only serves to admins.
+var (
+	// TransactionRetries counts serialization failures and deadlocks that were
+	// retried, keyed by the name of the transaction.
+	TransactionRetries = expvar.NewMap("transaction_retries")
+	// TransactionRetriesExhausted counts transactions that still failed after the
+	// last retry, keyed by the name of the transaction.
+	TransactionRetriesExhausted = expvar.NewMap("transaction_retries_exhausted")
+	// KeysResumed counts requests that resumed an unfinished idempotency key.
+	KeysResumed = expvar.NewInt("idempotency_keys_resumed")
+	// KeysMaxAttemptsReached counts requests refused because their idempotency key
+	// had used up its attempts.
+	KeysMaxAttemptsReached = expvar.NewInt("idempotency_keys_max_attempts_reached")
+	// JobsSucceeded, JobsRetried and JobsDeadLettered count the outcomes of jobs
+	// worked by a worker, keyed by the name of the job.
+	JobsSucceeded    = expvar.NewMap("jobs_succeeded")
+	JobsRetried      = expvar.NewMap("jobs_retried")
+	JobsDeadLettered = expvar.NewMap("jobs_dead_lettered")
+)
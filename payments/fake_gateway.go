@@ -0,0 +1,250 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned by FakeGateway for requests scripted to time out.
+var ErrTimeout = errors.New("payment gateway request timed out")
+
+// Operation names a method of Gateway so failures can be scripted for it.
+type Operation string
+
+const (
+	OpCreateCustomer      Operation = "create_customer"
+	OpCreatePaymentIntent Operation = "create_payment_intent"
+	OpCreateRefund        Operation = "create_refund"
+)
+
+var _ Gateway = (*FakeGateway)(nil)
+
+// FakeGateway is an in-process Gateway for tests and local development. It succeeds
+// unless told otherwise, hands out IDs like pi_fake_1 in order and keeps everything it
+// created, so tests can check what was charged.
+//
+// Failures are scripted per operation and used up in the order they were added: each
+// call of an operation takes the next scripted failure, if any.
+//
+// Like Stripe, calls made with an idempotency key that was used before return the
+// result of the first successful call with it, and fail with ErrKeyReused when their
+// params differ.
+type FakeGateway struct {
+	mu      sync.Mutex
+	latency time.Duration
+	script  map[Operation][]fakeOutcome
+	nextID  int
+	// results holds the result of each call made with an idempotency key.
+	results map[string]fakeResult
+
+	customers []*Customer
+	intents   []*PaymentIntent
+	refunds   []*Refund
+}
+
+type fakeOutcome struct {
+	err error
+	// apply is set when the request reaches the processor before failing.
+	apply bool
+}
+
+type fakeResult struct {
+	op     Operation
+	params any
+	result any
+}
+
+func MakeFakeGateway() *FakeGateway {
+	return &FakeGateway{
+		script:  make(map[Operation][]fakeOutcome),
+		results: make(map[string]fakeResult),
+	}
+}
+
+// SetLatency makes every call take d, or until its ctx is done.
+func (g *FakeGateway) SetLatency(d time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.latency = d
+}
+
+// DeclineNext makes the next CreatePaymentIntent fail with a *CardError with code,
+// like card_declined or insufficient_funds.
+func (g *FakeGateway) DeclineNext(code string) {
+	g.push(OpCreatePaymentIntent, fakeOutcome{err: &CardError{
+		Code:    code,
+		Message: "Your card was declined.",
+	}})
+}
+
+// TimeoutNext makes the next call of op fail with ErrTimeout after it took effect, like
+// a request whose response was lost on the way back.
+func (g *FakeGateway) TimeoutNext(op Operation) {
+	g.push(op, fakeOutcome{err: ErrTimeout, apply: true})
+}
+
+// FailNext makes the next call of op fail with err without taking effect.
+func (g *FakeGateway) FailNext(op Operation, err error) {
+	g.push(op, fakeOutcome{err: err})
+}
+
+func (g *FakeGateway) push(op Operation, outcome fakeOutcome) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.script[op] = append(g.script[op], outcome)
+}
+
+// Customers returns the customers created so far, oldest first.
+func (g *FakeGateway) Customers() []Customer {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return values(g.customers)
+}
+
+// PaymentIntents returns the payment intents created so far, oldest first.
+func (g *FakeGateway) PaymentIntents() []PaymentIntent {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return values(g.intents)
+}
+
+// Refunds returns the refunds created so far, oldest first.
+func (g *FakeGateway) Refunds() []Refund {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return values(g.refunds)
+}
+
+func values[T any](ptrs []*T) []T {
+	vs := make([]T, 0, len(ptrs))
+	for _, p := range ptrs {
+		vs = append(vs, *p)
+	}
+	return vs
+}
+
+func (g *FakeGateway) CreateCustomer(ctx context.Context, params CustomerParams) (*Customer, error) {
+	return call(ctx, g, OpCreateCustomer, params.IdempotencyKey, params, func() (*Customer, error) {
+		c := &Customer{ID: g.newID("cus"), Email: params.Email}
+		g.customers = append(g.customers, c)
+		return c, nil
+	})
+}
+
+func (g *FakeGateway) CreatePaymentIntent(ctx context.Context, params PaymentIntentParams) (*PaymentIntent, error) {
+	return call(ctx, g, OpCreatePaymentIntent, params.IdempotencyKey, params, func() (*PaymentIntent, error) {
+		if params.Amount <= 0 {
+			return nil, &InvalidRequestError{Code: "amount_too_small", Message: fmt.Sprintf("invalid amount %d", params.Amount)}
+		}
+		pi := &PaymentIntent{
+			ID:         g.newID("pi"),
+			Amount:     params.Amount,
+			Currency:   params.Currency,
+			CustomerID: params.CustomerID,
+			Status:     "succeeded",
+		}
+		g.intents = append(g.intents, pi)
+		return pi, nil
+	})
+}
+
+func (g *FakeGateway) CreateRefund(ctx context.Context, params RefundParams) (*Refund, error) {
+	return call(ctx, g, OpCreateRefund, params.IdempotencyKey, params, func() (*Refund, error) {
+		var intent *PaymentIntent
+		for _, pi := range g.intents {
+			if pi.ID == params.PaymentIntentID {
+				intent = pi
+			}
+		}
+		if intent == nil {
+			return nil, &InvalidRequestError{Code: "resource_missing", Message: fmt.Sprintf("no payment intent %s", params.PaymentIntentID)}
+		}
+
+		remaining := intent.Amount
+		for _, r := range g.refunds {
+			if r.PaymentIntentID == intent.ID {
+				remaining -= r.Amount
+			}
+		}
+		amount := params.Amount
+		if amount == 0 {
+			amount = remaining
+		}
+		if amount <= 0 || amount > remaining {
+			return nil, &InvalidRequestError{
+				Code:    "amount_too_large",
+				Message: fmt.Sprintf("can't refund %d of payment intent %s with %d left", amount, intent.ID, remaining),
+			}
+		}
+
+		r := &Refund{
+			ID:              g.newID("re"),
+			PaymentIntentID: intent.ID,
+			Amount:          amount,
+			Status:          "succeeded",
+		}
+		g.refunds = append(g.refunds, r)
+		return r, nil
+	})
+}
+
+// call waits out the latency of the gateway and runs apply under its lock, unless a
+// failure is scripted for op or the call is a replay of an earlier one with key.
+// Results are copied so callers can't change the records of the gateway.
+func call[T any](ctx context.Context, g *FakeGateway, op Operation, key string, params any, apply func() (*T, error)) (*T, error) {
+	g.mu.Lock()
+	latency := g.latency
+	g.mu.Unlock()
+	if latency > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(latency):
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var outcome fakeOutcome
+	if scripted := g.script[op]; len(scripted) > 0 {
+		outcome = scripted[0]
+		g.script[op] = scripted[1:]
+	}
+	if outcome.err != nil && !outcome.apply {
+		return nil, outcome.err
+	}
+
+	var v *T
+	if previous, ok := g.results[key]; ok && key != "" {
+		if previous.op != op || !reflect.DeepEqual(previous.params, params) {
+			return nil, fmt.Errorf("%w: %s", ErrKeyReused, key)
+		}
+		v = previous.result.(*T)
+	} else {
+		var err error
+		if v, err = apply(); err != nil {
+			return nil, err
+		}
+		if key != "" {
+			g.results[key] = fakeResult{op: op, params: params, result: v}
+		}
+	}
+	if outcome.err != nil {
+		return nil, outcome.err
+	}
+	result := *v
+	return &result, nil
+}
+
+func (g *FakeGateway) newID(prefix string) string {
+	g.nextID++
+	return fmt.Sprintf("%s_fake_%d", prefix, g.nextID)
+}
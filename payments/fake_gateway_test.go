@@ -0,0 +1,158 @@
+package payments_test
+
+import (
+	"context"
+	"errors"
+	"github.com/anmho/idempotent-rides/payments"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var errTestGateway = errors.New("gateway unavailable")
+
+var testIntentParams = payments.PaymentIntentParams{
+	Amount:     500,
+	Currency:   "usd",
+	CustomerID: "cus_123",
+}
+
+func TestFakeGateway_CreatePaymentIntent(t *testing.T) {
+	tests := []struct {
+		desc   string
+		script func(g *payments.FakeGateway)
+
+		expectedErr     error
+		expectedCardErr bool
+		expectedIntents int
+	}{
+		{
+			desc: "happy path: payment intent is created",
+
+			expectedIntents: 1,
+		},
+		{
+			desc: "error path: declined card",
+			script: func(g *payments.FakeGateway) {
+				g.DeclineNext("insufficient_funds")
+			},
+
+			expectedCardErr: true,
+			expectedIntents: 0,
+		},
+		{
+			desc: "error path: timeout after the payment intent was created",
+			script: func(g *payments.FakeGateway) {
+				g.TimeoutNext(payments.OpCreatePaymentIntent)
+			},
+
+			expectedErr:     payments.ErrTimeout,
+			expectedIntents: 1,
+		},
+		{
+			desc: "error path: failure before the payment intent was created",
+			script: func(g *payments.FakeGateway) {
+				g.FailNext(payments.OpCreatePaymentIntent, errTestGateway)
+			},
+
+			expectedErr:     errTestGateway,
+			expectedIntents: 0,
+		},
+		{
+			desc: "happy path: failures scripted for other operations don't apply",
+			script: func(g *payments.FakeGateway) {
+				g.FailNext(payments.OpCreateCustomer, errTestGateway)
+			},
+
+			expectedIntents: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			g := payments.MakeFakeGateway()
+			if tc.script != nil {
+				tc.script(g)
+			}
+
+			intent, err := g.CreatePaymentIntent(context.Background(), testIntentParams)
+			switch {
+			case tc.expectedCardErr:
+				var cardErr *payments.CardError
+				require.ErrorAs(t, err, &cardErr)
+				assert.Equal(t, "insufficient_funds", cardErr.Code)
+			case tc.expectedErr != nil:
+				assert.ErrorIs(t, err, tc.expectedErr)
+			default:
+				require.NoError(t, err)
+				assert.Equal(t, "pi_fake_1", intent.ID)
+				assert.Equal(t, int64(500), intent.Amount)
+			}
+			assert.Len(t, g.PaymentIntents(), tc.expectedIntents)
+
+			// Scripted failures are used up by the call they apply to.
+			_, err = g.CreatePaymentIntent(context.Background(), testIntentParams)
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestFakeGateway_CreateRefund(t *testing.T) {
+	ctx := context.Background()
+	g := payments.MakeFakeGateway()
+	intent, err := g.CreatePaymentIntent(ctx, testIntentParams)
+	require.NoError(t, err)
+
+	refund, err := g.CreateRefund(ctx, payments.RefundParams{PaymentIntentID: intent.ID, Amount: 200})
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), refund.Amount)
+
+	// Without an amount, whatever is left is refunded.
+	refund, err = g.CreateRefund(ctx, payments.RefundParams{PaymentIntentID: intent.ID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(300), refund.Amount)
+
+	var invalidRequestErr *payments.InvalidRequestError
+	_, err = g.CreateRefund(ctx, payments.RefundParams{PaymentIntentID: intent.ID, Amount: 1})
+	assert.ErrorAs(t, err, &invalidRequestErr, "refunding more than was charged")
+	_, err = g.CreateRefund(ctx, payments.RefundParams{PaymentIntentID: "pi_unknown"})
+	assert.ErrorAs(t, err, &invalidRequestErr, "refunding a payment intent that doesn't exist")
+	assert.Len(t, g.Refunds(), 2)
+}
+
+func TestFakeGateway_SetLatency(t *testing.T) {
+	g := payments.MakeFakeGateway()
+	g.SetLatency(time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := g.CreateCustomer(ctx, payments.CustomerParams{Email: "andrew@example.com"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Empty(t, g.Customers())
+}
+
+func TestFakeGateway_idempotencyKey(t *testing.T) {
+	ctx := context.Background()
+	g := payments.MakeFakeGateway()
+	params := testIntentParams
+	params.IdempotencyKey = "rocket-rides-1-ride_created"
+
+	// The response of the first call is lost, so it is retried with the same key.
+	g.TimeoutNext(payments.OpCreatePaymentIntent)
+	_, err := g.CreatePaymentIntent(ctx, params)
+	require.ErrorIs(t, err, payments.ErrTimeout)
+
+	intent, err := g.CreatePaymentIntent(ctx, params)
+	require.NoError(t, err)
+	assert.Len(t, g.PaymentIntents(), 1)
+	assert.Equal(t, g.PaymentIntents()[0].ID, intent.ID)
+
+	params.Amount = 1000
+	_, err = g.CreatePaymentIntent(ctx, params)
+	assert.ErrorIs(t, err, payments.ErrKeyReused)
+
+	// Keys are not shared between operations.
+	_, err = g.CreateRefund(ctx, payments.RefundParams{IdempotencyKey: params.IdempotencyKey, PaymentIntentID: intent.ID})
+	assert.ErrorIs(t, err, payments.ErrKeyReused)
+}
@@ -0,0 +1,117 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrKeyReused is returned when an idempotency key is sent again with different
+// params than the first time.
+var ErrKeyReused = errors.New("payment gateway idempotency key reused with different params")
+
+// Gateway is a payment processor. Every call is a request to the processor, so any of
+// them can fail part way and leave the processor having done the work anyway. Calls
+// that pass an idempotency key are safe to retry: the processor returns the result of
+// the first call made with the key instead of doing the work again.
+type Gateway interface {
+	CreateCustomer(ctx context.Context, params CustomerParams) (*Customer, error)
+	// CreatePaymentIntent charges a customer. A declined card fails with a *CardError.
+	CreatePaymentIntent(ctx context.Context, params PaymentIntentParams) (*PaymentIntent, error)
+	// CreateRefund refunds part or all of a payment intent.
+	CreateRefund(ctx context.Context, params RefundParams) (*Refund, error)
+}
+
+type CustomerParams struct {
+	// IdempotencyKey makes retries of the call safe. Optional.
+	IdempotencyKey string
+
+	Name  string
+	Email string
+}
+
+type Customer struct {
+	// ID is the processor's ID of the customer, like cus_123.
+	ID    string
+	Email string
+}
+
+type PaymentIntentParams struct {
+	IdempotencyKey string
+
+	// Amount is in the smallest unit of Currency, like cents.
+	Amount     int64
+	Currency   string
+	CustomerID string
+}
+
+type PaymentIntent struct {
+	// ID is the processor's ID of the payment intent, like pi_123.
+	ID         string
+	Amount     int64
+	Currency   string
+	CustomerID string
+	Status     string
+}
+
+type RefundParams struct {
+	IdempotencyKey string
+
+	PaymentIntentID string
+	// Amount is the part of the payment intent to refund. Zero refunds all of it.
+	Amount int64
+	// Reason is duplicate, fraudulent or requested_by_customer. Optional.
+	Reason string
+}
+
+type Refund struct {
+	// ID is the processor's ID of the refund, like re_123.
+	ID              string
+	PaymentIntentID string
+	Amount          int64
+	Status          string
+}
+
+// CardError is returned when the processor declined the customer's card. Unlike other
+// errors, retrying the same request fails the same way.
+type CardError struct {
+	// Code is the reason for the decline, like card_declined or insufficient_funds.
+	Code string
+	// Message is safe to show to the customer.
+	Message string
+	Cause   error
+}
+
+var _ error = (*CardError)(nil)
+
+func (e *CardError) Error() string {
+	return fmt.Sprintf("card error %s: %s", e.Code, e.Message)
+}
+
+func (e *CardError) Unwrap() error {
+	return e.Cause
+}
+
+// InvalidRequestError is returned when the processor rejected the request itself, like
+// a refund of more than is left of a payment or of a payment that doesn't exist. Like a
+// CardError, retrying the same request fails the same way.
+type InvalidRequestError struct {
+	// Code is the reason for the rejection, like charge_already_refunded or
+	// resource_missing. It may be empty.
+	Code    string
+	Message string
+	Cause   error
+}
+
+var _ error = (*InvalidRequestError)(nil)
+
+func (e *InvalidRequestError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("invalid request: %s", e.Message)
+	}
+	return fmt.Sprintf("invalid request %s: %s", e.Code, e.Message)
+}
+
+func (e *InvalidRequestError) Unwrap() error {
+	return e.Cause
+}
@@ -0,0 +1,123 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stripe/stripe-go/v79"
+	"github.com/stripe/stripe-go/v79/client"
+)
+
+var _ Gateway = (*stripeGateway)(nil)
+
+// stripeGateway is a Gateway backed by the Stripe API.
+type stripeGateway struct {
+	api *client.API
+}
+
+// MakeStripeGateway returns a Gateway that makes requests to Stripe with key.
+func MakeStripeGateway(key string) Gateway {
+	return &stripeGateway{api: client.New(key, nil)}
+}
+
+func (g *stripeGateway) CreateCustomer(ctx context.Context, params CustomerParams) (*Customer, error) {
+	customerParams := &stripe.CustomerParams{
+		Name:  stripe.String(params.Name),
+		Email: stripe.String(params.Email),
+	}
+	customerParams.Context = ctx
+	setIdempotencyKey(&customerParams.Params, params.IdempotencyKey)
+
+	c, err := g.api.Customers.New(customerParams)
+	if err != nil {
+		return nil, convertStripeError(err)
+	}
+	return &Customer{ID: c.ID, Email: c.Email}, nil
+}
+
+func (g *stripeGateway) CreatePaymentIntent(ctx context.Context, params PaymentIntentParams) (*PaymentIntent, error) {
+	intentParams := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(params.Amount),
+		Currency: stripe.String(params.Currency),
+		Customer: stripe.String(params.CustomerID),
+	}
+	intentParams.Context = ctx
+	setIdempotencyKey(&intentParams.Params, params.IdempotencyKey)
+
+	pi, err := g.api.PaymentIntents.New(intentParams)
+	if err != nil {
+		return nil, convertStripeError(err)
+	}
+	intent := &PaymentIntent{
+		ID:       pi.ID,
+		Amount:   pi.Amount,
+		Currency: string(pi.Currency),
+		Status:   string(pi.Status),
+	}
+	if pi.Customer != nil {
+		intent.CustomerID = pi.Customer.ID
+	}
+	return intent, nil
+}
+
+func (g *stripeGateway) CreateRefund(ctx context.Context, params RefundParams) (*Refund, error) {
+	refundParams := &stripe.RefundParams{
+		PaymentIntent: stripe.String(params.PaymentIntentID),
+	}
+	if params.Amount > 0 {
+		refundParams.Amount = stripe.Int64(params.Amount)
+	}
+	if params.Reason != "" {
+		refundParams.Reason = stripe.String(params.Reason)
+	}
+	refundParams.Context = ctx
+	setIdempotencyKey(&refundParams.Params, params.IdempotencyKey)
+
+	r, err := g.api.Refunds.New(refundParams)
+	if err != nil {
+		return nil, convertStripeError(err)
+	}
+	refund := &Refund{
+		ID:     r.ID,
+		Amount: r.Amount,
+		Status: string(r.Status),
+	}
+	if r.PaymentIntent != nil {
+		refund.PaymentIntentID = r.PaymentIntent.ID
+	}
+	return refund, nil
+}
+
+func setIdempotencyKey(params *stripe.Params, key string) {
+	if key != "" {
+		params.SetIdempotencyKey(key)
+	}
+}
+
+// convertStripeError turns card errors into a *CardError, invalid request errors into
+// an *InvalidRequestError and idempotency errors into ErrKeyReused. Any other error,
+// like a network or api error, may succeed when retried and is left as is.
+func convertStripeError(err error) error {
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) {
+		return err
+	}
+	switch stripeErr.Type {
+	case stripe.ErrorTypeCard:
+		return &CardError{
+			Code:    string(stripeErr.Code),
+			Message: stripeErr.Msg,
+			Cause:   err,
+		}
+	case stripe.ErrorTypeInvalidRequest:
+		return &InvalidRequestError{
+			Code:    string(stripeErr.Code),
+			Message: stripeErr.Msg,
+			Cause:   err,
+		}
+	case stripe.ErrorTypeIdempotency:
+		return fmt.Errorf("%w: %w", ErrKeyReused, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,93 @@
+package payments
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v79"
+	"net/http"
+	"testing"
+)
+
+func Test_convertStripeError(t *testing.T) {
+	tests := []struct {
+		desc string
+		err  error
+
+		expectedCardErr           *CardError
+		expectedInvalidRequestErr *InvalidRequestError
+		expectedKeyReuse          bool
+	}{
+		{
+			desc: "happy path: card error becomes a CardError",
+			err: &stripe.Error{
+				Type: stripe.ErrorTypeCard,
+				Code: stripe.ErrorCodeCardDeclined,
+				Msg:  "Your card was declined.",
+			},
+
+			expectedCardErr: &CardError{
+				Code:    "card_declined",
+				Message: "Your card was declined.",
+			},
+		},
+		{
+			desc: "happy path: invalid request error becomes an InvalidRequestError",
+			err: &stripe.Error{
+				Type: stripe.ErrorTypeInvalidRequest,
+				Code: stripe.ErrorCodeChargeAlreadyRefunded,
+				Msg:  "Charge ch_123 has already been refunded.",
+			},
+
+			expectedInvalidRequestErr: &InvalidRequestError{
+				Code:    "charge_already_refunded",
+				Message: "Charge ch_123 has already been refunded.",
+			},
+		},
+		{
+			desc: "happy path: idempotency error is ErrKeyReused",
+			err: &stripe.Error{
+				Type: stripe.ErrorTypeIdempotency,
+				Msg:  "Keys for idempotent requests can only be used with the same parameters they were first used with.",
+			},
+
+			expectedKeyReuse: true,
+		},
+		{
+			desc: "happy path: api error is left as is",
+			err: &stripe.Error{
+				Type:           stripe.ErrorTypeAPI,
+				HTTPStatusCode: http.StatusInternalServerError,
+			},
+		},
+		{
+			desc: "happy path: network error is left as is",
+			err:  errors.New("net/http: request canceled (Client.Timeout exceeded while awaiting headers)"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := convertStripeError(tc.err)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.expectedKeyReuse, errors.Is(err, ErrKeyReused))
+
+			var invalidRequestErr *InvalidRequestError
+			if tc.expectedInvalidRequestErr == nil {
+				assert.False(t, errors.As(err, &invalidRequestErr))
+			} else if assert.ErrorAs(t, err, &invalidRequestErr) {
+				assert.Equal(t, tc.expectedInvalidRequestErr.Code, invalidRequestErr.Code)
+				assert.Equal(t, tc.expectedInvalidRequestErr.Message, invalidRequestErr.Message)
+			}
+
+			var cardErr *CardError
+			if tc.expectedCardErr == nil {
+				assert.False(t, errors.As(err, &cardErr))
+				return
+			}
+			if assert.ErrorAs(t, err, &cardErr) {
+				assert.Equal(t, tc.expectedCardErr.Code, cardErr.Code)
+				assert.Equal(t, tc.expectedCardErr.Message, cardErr.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package pricing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// zeroDecimalCurrencies and threeDecimalCurrencies are the ISO 4217 currencies whose
+// smallest unit isn't a hundredth, as listed by Stripe.
+var (
+	zeroDecimalCurrencies = map[string]bool{
+		"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true,
+		"krw": true, "mga": true, "pyg": true, "rwf": true, "ugx": true, "vnd": true,
+		"vuv": true, "xaf": true, "xof": true, "xpf": true,
+	}
+	threeDecimalCurrencies = map[string]bool{
+		"bhd": true, "jod": true, "kwd": true, "omr": true, "tnd": true,
+	}
+)
+
+// IsValidCurrency reports whether currency looks like an ISO 4217 code, like usd.
+// Case doesn't matter.
+func IsValidCurrency(currency string) bool {
+	if len(currency) != 3 {
+		return false
+	}
+	for _, r := range strings.ToLower(currency) {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// MinorUnits returns the number of decimals of currency, like 2 for usd and 0 for jpy.
+func MinorUnits(currency string) int {
+	currency = strings.ToLower(currency)
+	switch {
+	case zeroDecimalCurrencies[currency]:
+		return 0
+	case threeDecimalCurrencies[currency]:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// FormatAmount formats an amount in the smallest unit of currency, like 500 usd as
+// "5.00 USD" and 500 jpy as "500 JPY".
+func FormatAmount(amount int64, currency string) string {
+	code := strings.ToUpper(currency)
+	decimals := MinorUnits(currency)
+	if decimals == 0 {
+		return fmt.Sprintf("%d %s", amount, code)
+	}
+
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	unit := int64(1)
+	for range decimals {
+		unit *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d %s", sign, amount/unit, decimals, amount%unit, code)
+}
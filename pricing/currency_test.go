@@ -0,0 +1,59 @@
+package pricing_test
+
+import (
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		desc     string
+		amount   int64
+		currency string
+
+		expected string
+	}{
+		{
+			desc:     "happy path: two decimal currency",
+			amount:   500,
+			currency: "usd",
+
+			expected: "5.00 USD",
+		},
+		{
+			desc:     "happy path: cents are padded",
+			amount:   16905,
+			currency: "eur",
+
+			expected: "169.05 EUR",
+		},
+		{
+			desc:     "happy path: zero decimal currency",
+			amount:   500,
+			currency: "jpy",
+
+			expected: "500 JPY",
+		},
+		{
+			desc:     "happy path: three decimal currency",
+			amount:   1500,
+			currency: "KWD",
+
+			expected: "1.500 KWD",
+		},
+		{
+			desc:     "happy path: negative amount",
+			amount:   -5,
+			currency: "usd",
+
+			expected: "-0.05 USD",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, pricing.FormatAmount(tc.amount, tc.currency))
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package pricing
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// DefaultBaseFare is what every ride costs before distance, in cents.
+	DefaultBaseFare = 250
+	// DefaultPerKm is what every kilometer costs, in cents.
+	DefaultPerKm = 150
+	// DefaultMinimumFare is the least a ride costs, in cents.
+	DefaultMinimumFare = 500
+	DefaultCurrency    = "usd"
+
+	// earthRadiusKm is the mean radius of the earth.
+	earthRadiusKm = 6371.0
+)
+
+// Config sets the prices of rides. Amounts are in the smallest unit of Currency,
+// like cents.
+type Config struct {
+	BaseFare    int64  `env:"PRICING_BASE_FARE" envDefault:"250"`
+	PerKm       int64  `env:"PRICING_PER_KM" envDefault:"150"`
+	MinimumFare int64  `env:"PRICING_MINIMUM_FARE" envDefault:"500"`
+	Currency    string `env:"PRICING_CURRENCY" envDefault:"usd"`
+}
+
+// Point is a latitude and longitude in degrees.
+type Point struct {
+	Lat  float64
+	Long float64
+}
+
+// Fare is the price of a ride and the line items it adds up from.
+type Fare struct {
+	// Amount is in the smallest unit of Currency, like cents.
+	Amount    int64     `json:"amount"`
+	Currency  string    `json:"currency"`
+	LineItems LineItems `json:"line_items"`
+}
+
+type LineItem struct {
+	Description string `json:"description"`
+	Amount      int64  `json:"amount"`
+}
+
+// LineItems are stored as JSON in rides.fare_line_items.
+type LineItems []LineItem
+
+var _ sql.Scanner = (*LineItems)(nil)
+var _ driver.Valuer = LineItems(nil)
+
+func (items *LineItems) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*items = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, items)
+	case string:
+		return json.Unmarshal([]byte(v), items)
+	default:
+		return fmt.Errorf("unsupported type %T for line items", src)
+	}
+}
+
+func (items LineItems) Value() (driver.Value, error) {
+	if items == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(items)
+}
+
+// Calculator prices rides by the distance between their origin and target.
+type Calculator struct {
+	cfg Config
+}
+
+// MakeCalculator returns a calculator that prices rides with cfg. Zero amounts are
+// honored, so rides can be free of a base or minimum fare. Negative amounts and
+// currencies that aren't ISO codes are rejected here, at startup, rather than when
+// the first ride is stored. The empty Config prices rides with the defaults.
+func MakeCalculator(cfg Config) (*Calculator, error) {
+	if cfg == (Config{}) {
+		cfg = Config{
+			BaseFare:    DefaultBaseFare,
+			PerKm:       DefaultPerKm,
+			MinimumFare: DefaultMinimumFare,
+			Currency:    DefaultCurrency,
+		}
+	}
+	if cfg.BaseFare < 0 {
+		return nil, fmt.Errorf("base fare can't be negative: %d", cfg.BaseFare)
+	}
+	if cfg.PerKm < 0 {
+		return nil, fmt.Errorf("rate per km can't be negative: %d", cfg.PerKm)
+	}
+	if cfg.MinimumFare < 0 {
+		return nil, fmt.Errorf("minimum fare can't be negative: %d", cfg.MinimumFare)
+	}
+	if !IsValidCurrency(cfg.Currency) {
+		return nil, fmt.Errorf("currency must be a 3 letter ISO code: %q", cfg.Currency)
+	}
+	// Fares are stored and sent to the processor in lowercase.
+	cfg.Currency = strings.ToLower(cfg.Currency)
+	return &Calculator{cfg: cfg}, nil
+}
+
+// Fare returns the fare of a ride from origin to target: the base fare plus the rate
+// per kilometer of the distance between them, topped up to the minimum fare.
+func (c *Calculator) Fare(origin, target Point) Fare {
+	km := Distance(origin, target)
+	items := LineItems{
+		{Description: "Base fare", Amount: c.cfg.BaseFare},
+		{Description: fmt.Sprintf("Distance (%.1f km)", km), Amount: int64(math.Round(km * float64(c.cfg.PerKm)))},
+	}
+
+	var amount int64
+	for _, item := range items {
+		amount += item.Amount
+	}
+	if amount < c.cfg.MinimumFare {
+		items = append(items, LineItem{Description: "Minimum fare", Amount: c.cfg.MinimumFare - amount})
+		amount = c.cfg.MinimumFare
+	}
+
+	return Fare{
+		Amount:    amount,
+		Currency:  c.cfg.Currency,
+		LineItems: items,
+	}
+}
+
+// Distance returns the great-circle distance between a and b in kilometers, using the
+// haversine formula.
+func Distance(a, b Point) float64 {
+	lat1 := radians(a.Lat)
+	lat2 := radians(b.Lat)
+	dLat := radians(b.Lat - a.Lat)
+	dLong := radians(b.Long - a.Long)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
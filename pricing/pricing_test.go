@@ -0,0 +1,251 @@
+package pricing_test
+
+import (
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		desc   string
+		a      pricing.Point
+		b      pricing.Point
+		wantKm float64
+	}{
+		{
+			desc:   "happy path: same point",
+			a:      pricing.Point{Lat: 37.7749, Long: -122.4194},
+			b:      pricing.Point{Lat: 37.7749, Long: -122.4194},
+			wantKm: 0,
+		},
+		{
+			desc:   "happy path: one degree along the equator",
+			a:      pricing.Point{Lat: 0, Long: 0},
+			b:      pricing.Point{Lat: 0, Long: 1},
+			wantKm: 111.19,
+		},
+		{
+			desc:   "happy path: san francisco to oakland",
+			a:      pricing.Point{Lat: 37.7749, Long: -122.4194},
+			b:      pricing.Point{Lat: 37.8044, Long: -122.2712},
+			wantKm: 13.4,
+		},
+		{
+			desc:   "happy path: across the antimeridian",
+			a:      pricing.Point{Lat: 0, Long: 179.5},
+			b:      pricing.Point{Lat: 0, Long: -179.5},
+			wantKm: 111.19,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.InDelta(t, tc.wantKm, pricing.Distance(tc.a, tc.b), 0.1)
+			assert.InDelta(t, tc.wantKm, pricing.Distance(tc.b, tc.a), 0.1)
+		})
+	}
+}
+
+func TestCalculator_Fare(t *testing.T) {
+	tests := []struct {
+		desc   string
+		cfg    pricing.Config
+		origin pricing.Point
+		target pricing.Point
+
+		expectedFare pricing.Fare
+	}{
+		{
+			desc:   "happy path: base fare plus distance",
+			cfg:    pricing.Config{BaseFare: 250, PerKm: 150, MinimumFare: 500, Currency: "usd"},
+			origin: pricing.Point{Lat: 0, Long: 0},
+			target: pricing.Point{Lat: 0, Long: 1},
+
+			expectedFare: pricing.Fare{
+				Amount:   16929,
+				Currency: "usd",
+				LineItems: pricing.LineItems{
+					{Description: "Base fare", Amount: 250},
+					{Description: "Distance (111.2 km)", Amount: 16679},
+				},
+			},
+		},
+		{
+			desc:   "happy path: short ride is topped up to the minimum fare",
+			cfg:    pricing.Config{BaseFare: 250, PerKm: 150, MinimumFare: 500, Currency: "usd"},
+			origin: pricing.Point{Lat: 37.7749, Long: -122.4194},
+			target: pricing.Point{Lat: 37.7749, Long: -122.4194},
+
+			expectedFare: pricing.Fare{
+				Amount:   500,
+				Currency: "usd",
+				LineItems: pricing.LineItems{
+					{Description: "Base fare", Amount: 250},
+					{Description: "Distance (0.0 km)", Amount: 0},
+					{Description: "Minimum fare", Amount: 250},
+				},
+			},
+		},
+		{
+			desc:   "happy path: empty config uses the defaults",
+			cfg:    pricing.Config{},
+			origin: pricing.Point{Lat: 0, Long: 0},
+			target: pricing.Point{Lat: 0, Long: 1},
+
+			expectedFare: pricing.Fare{
+				Amount:   16929,
+				Currency: pricing.DefaultCurrency,
+				LineItems: pricing.LineItems{
+					{Description: "Base fare", Amount: pricing.DefaultBaseFare},
+					{Description: "Distance (111.2 km)", Amount: 16679},
+				},
+			},
+		},
+		{
+			desc:   "happy path: zero base fare",
+			cfg:    pricing.Config{BaseFare: 0, PerKm: 150, MinimumFare: 500, Currency: "usd"},
+			origin: pricing.Point{Lat: 0, Long: 0},
+			target: pricing.Point{Lat: 0, Long: 1},
+
+			expectedFare: pricing.Fare{
+				Amount:   16679,
+				Currency: "usd",
+				LineItems: pricing.LineItems{
+					{Description: "Base fare", Amount: 0},
+					{Description: "Distance (111.2 km)", Amount: 16679},
+				},
+			},
+		},
+		{
+			desc:   "happy path: zero minimum fare isn't topped up",
+			cfg:    pricing.Config{BaseFare: 0, PerKm: 150, MinimumFare: 0, Currency: "usd"},
+			origin: pricing.Point{Lat: 37.7749, Long: -122.4194},
+			target: pricing.Point{Lat: 37.7749, Long: -122.4194},
+
+			expectedFare: pricing.Fare{
+				Amount:   0,
+				Currency: "usd",
+				LineItems: pricing.LineItems{
+					{Description: "Base fare", Amount: 0},
+					{Description: "Distance (0.0 km)", Amount: 0},
+				},
+			},
+		},
+		{
+			desc:   "happy path: other currency",
+			cfg:    pricing.Config{BaseFare: 100, PerKm: 1000, MinimumFare: 100, Currency: "eur"},
+			origin: pricing.Point{Lat: 0, Long: 0},
+			target: pricing.Point{Lat: 0, Long: 0.01},
+
+			expectedFare: pricing.Fare{
+				Amount:   1212,
+				Currency: "eur",
+				LineItems: pricing.LineItems{
+					{Description: "Base fare", Amount: 100},
+					{Description: "Distance (1.1 km)", Amount: 1112},
+				},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			calculator, err := pricing.MakeCalculator(tc.cfg)
+			require.NoError(t, err)
+			fare := calculator.Fare(tc.origin, tc.target)
+			assert.Equal(t, tc.expectedFare, fare)
+
+			var sum int64
+			for _, item := range fare.LineItems {
+				sum += item.Amount
+			}
+			assert.Equal(t, fare.Amount, sum)
+		})
+	}
+}
+
+func TestMakeCalculator(t *testing.T) {
+	tests := []struct {
+		desc string
+		cfg  pricing.Config
+
+		expectedErr bool
+	}{
+		{
+			desc: "happy path: zero amounts are allowed",
+			cfg:  pricing.Config{Currency: "usd"},
+		},
+		{
+			desc: "error path: negative base fare",
+			cfg:  pricing.Config{BaseFare: -1, PerKm: 150, MinimumFare: 500, Currency: "usd"},
+
+			expectedErr: true,
+		},
+		{
+			desc: "error path: negative rate per km",
+			cfg:  pricing.Config{BaseFare: 250, PerKm: -1, MinimumFare: 500, Currency: "usd"},
+
+			expectedErr: true,
+		},
+		{
+			desc: "error path: negative minimum fare",
+			cfg:  pricing.Config{BaseFare: 250, PerKm: 150, MinimumFare: -1, Currency: "usd"},
+
+			expectedErr: true,
+		},
+		{
+			desc: "happy path: currency in uppercase",
+			cfg:  pricing.Config{BaseFare: 250, PerKm: 150, MinimumFare: 500, Currency: "EUR"},
+		},
+		{
+			desc: "error path: currency isn't an ISO code",
+			cfg:  pricing.Config{BaseFare: 250, PerKm: 150, MinimumFare: 500, Currency: "dollars"},
+
+			expectedErr: true,
+		},
+		{
+			desc: "error path: currency with digits",
+			cfg:  pricing.Config{BaseFare: 250, PerKm: 150, MinimumFare: 500, Currency: "us1"},
+
+			expectedErr: true,
+		},
+		{
+			desc: "error path: no currency",
+			cfg:  pricing.Config{BaseFare: 250, PerKm: 150, MinimumFare: 500},
+
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			calculator, err := pricing.MakeCalculator(tc.cfg)
+			if tc.expectedErr {
+				assert.Error(t, err)
+				assert.Nil(t, calculator)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, calculator)
+		})
+	}
+}
+
+func TestLineItems_Value(t *testing.T) {
+	items := pricing.LineItems{
+		{Description: "Base fare", Amount: 250},
+		{Description: "Distance (1.0 km)", Amount: 150},
+	}
+	v, err := items.Value()
+	require.NoError(t, err)
+
+	var scanned pricing.LineItems
+	require.NoError(t, scanned.Scan(v))
+	assert.Equal(t, items, scanned)
+
+	v, err = pricing.LineItems(nil).Value()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("[]"), v)
+}
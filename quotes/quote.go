@@ -0,0 +1,89 @@
+package quotes
+
+import (
+	"fmt"
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/anmho/idempotent-rides/rides"
+	"log/slog"
+	"math"
+	"time"
+)
+
+const (
+	// DefaultTTL is how long a quote can be booked for.
+	DefaultTTL = 15 * time.Minute
+
+	// coordinateTolerance is how far, in degrees, the coordinates of a ride may be
+	// from those of its quote. Coordinates are stored with 10 decimals, so the ones
+	// sent by clients don't round-trip exactly.
+	coordinateTolerance = 1e-7
+)
+
+// Config holds the settings of quotes.
+type Config struct {
+	// SigningKey signs quote IDs so they can't be guessed. Quotes are disabled when it
+	// is empty.
+	SigningKey string        `env:"QUOTE_SIGNING_KEY"`
+	TTL        time.Duration `env:"QUOTE_TTL" envDefault:"15m"`
+}
+
+// String hides the signing key, which lets anyone who knows it forge quote IDs, so
+// that printing or logging the config doesn't leak it.
+func (c Config) String() string {
+	return fmt.Sprintf("{SigningKey:%s TTL:%s}", redact(c.SigningKey), c.TTL)
+}
+
+func (c Config) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("signingKey", redact(c.SigningKey)),
+		slog.Duration("ttl", c.TTL),
+	)
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "REDACTED"
+}
+
+// Quote is a fare offered for a ride between two coordinates. Booking the ride before
+// the quote expires charges exactly its fare.
+type Quote struct {
+	ID        int
+	CreatedAt time.Time
+	ExpiresAt time.Time
+
+	Origin rides.Coordinate
+	Target rides.Coordinate
+	Fare   pricing.Fare
+}
+
+func New(origin, target rides.Coordinate, fare pricing.Fare, ttl time.Duration) *Quote {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	now := time.Now()
+	return &Quote{
+		ID:        -1,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+		Origin:    origin,
+		Target:    target,
+		Fare:      fare,
+	}
+}
+
+// IsExpired reports whether q can no longer be booked at now.
+func (q *Quote) IsExpired(now time.Time) bool {
+	return !now.Before(q.ExpiresAt)
+}
+
+// Matches reports whether q was quoted for a ride from origin to target.
+func (q *Quote) Matches(origin, target rides.Coordinate) bool {
+	return closeTo(q.Origin, origin) && closeTo(q.Target, target)
+}
+
+func closeTo(a, b rides.Coordinate) bool {
+	return math.Abs(a.Lat-b.Lat) <= coordinateTolerance && math.Abs(a.Long-b.Long) <= coordinateTolerance
+}
@@ -0,0 +1,84 @@
+package quotes
+
+import (
+	"context"
+	"fmt"
+	database "github.com/anmho/idempotent-rides/sql"
+)
+
+type Service interface {
+	GetQuote(ctx context.Context, db database.DB, quoteID int) (*Quote, error)
+	CreateQuote(ctx context.Context, db database.DB, quote *Quote) (*Quote, error)
+}
+
+func MakeService() Service {
+	return &service{}
+}
+
+var _ Service = (*service)(nil)
+
+type service struct {
+}
+
+const quoteColumns = `
+	id, created_at, expires_at,
+	origin_lat, origin_lon,
+	target_lat, target_lon,
+	fare_amount, fare_currency, fare_line_items`
+
+func scanQuote(row interface{ Scan(dest ...any) error }) (*Quote, error) {
+	var quote Quote
+	err := row.Scan(
+		&quote.ID, &quote.CreatedAt, &quote.ExpiresAt,
+		&quote.Origin.Lat, &quote.Origin.Long,
+		&quote.Target.Lat, &quote.Target.Long,
+		&quote.Fare.Amount, &quote.Fare.Currency, &quote.Fare.LineItems,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
+
+func (s *service) GetQuote(ctx context.Context, db database.DB, quoteID int) (*Quote, error) {
+	row := db.QueryRowContext(ctx,
+		`
+	SELECT`+quoteColumns+`
+	FROM rocket_rides.public.quotes
+	WHERE id = $1
+	;
+	`,
+		quoteID,
+	)
+	return scanQuote(row)
+}
+
+func (s *service) CreateQuote(ctx context.Context, db database.DB, quote *Quote) (*Quote, error) {
+	row := db.QueryRowContext(ctx,
+		`
+	INSERT INTO rocket_rides.public.quotes (
+		expires_at,
+		origin_lat, origin_lon,
+		target_lat, target_lon,
+		fare_amount, fare_currency, fare_line_items
+	) VALUES (
+		$1,
+		$2, $3,
+		$4, $5,
+		$6, $7, $8
+	)
+	RETURNING`+quoteColumns+`
+	;
+	`,
+		quote.ExpiresAt,
+		quote.Origin.Lat, quote.Origin.Long,
+		quote.Target.Lat, quote.Target.Long,
+		quote.Fare.Amount, quote.Fare.Currency, quote.Fare.LineItems,
+	)
+
+	created, err := scanQuote(row)
+	if err != nil {
+		return nil, fmt.Errorf("creating quote: %w", err)
+	}
+	return created, nil
+}
@@ -0,0 +1,101 @@
+package quotes_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/anmho/idempotent-rides/quotes"
+	"github.com/anmho/idempotent-rides/rides"
+	"github.com/anmho/idempotent-rides/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+var (
+	// TestQuoteFare is the seeded fare of quotes 900 and 901.
+	TestQuoteFare = pricing.Fare{
+		Amount:   16929,
+		Currency: "usd",
+		LineItems: pricing.LineItems{
+			{Description: "Base fare", Amount: 250},
+			{Description: "Distance (111.2 km)", Amount: 16679},
+		},
+	}
+	TestBookableQuote = &quotes.Quote{
+		ID:        900,
+		ExpiresAt: time.Date(2999, 1, 1, 0, 0, 0, 0, time.UTC),
+		Origin:    rides.Coordinate{Lat: 0, Long: 0},
+		Target:    rides.Coordinate{Lat: 0, Long: 1},
+		Fare:      TestQuoteFare,
+	}
+)
+
+func AssertEqualQuote(t *testing.T, expected, quote *quotes.Quote) {
+	assert.Equal(t, expected.Origin, quote.Origin)
+	assert.Equal(t, expected.Target, quote.Target)
+	assert.Equal(t, expected.Fare, quote.Fare)
+	assert.WithinDuration(t, expected.ExpiresAt, quote.ExpiresAt, time.Millisecond)
+}
+
+func TestQuoteService_GetQuote(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		desc    string
+		quoteID int
+
+		expectedQuote *quotes.Quote
+		expectedErr   error
+	}{
+		{
+			desc:    "happy path: get a quote that exists in the database",
+			quoteID: TestBookableQuote.ID,
+
+			expectedQuote: TestBookableQuote,
+		},
+		{
+			desc:    "error path: get a quote that doesn't exist in the database",
+			quoteID: 7258,
+
+			expectedErr: sql.ErrNoRows,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx := context.Background()
+			db := test.MakePostgres(t)
+
+			quote, err := quotes.MakeService().GetQuote(ctx, db, tc.quoteID)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			AssertEqualQuote(t, tc.expectedQuote, quote)
+		})
+	}
+}
+
+func TestQuoteService_CreateQuote(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := test.MakePostgres(t)
+	quoteService := quotes.MakeService()
+
+	newQuote := quotes.New(
+		rides.Coordinate{Lat: 37.7749, Long: -122.4194},
+		rides.Coordinate{Lat: 37.8044, Long: -122.2712},
+		TestQuoteFare,
+		time.Minute,
+	)
+	created, err := quoteService.CreateQuote(ctx, db, newQuote)
+	require.NoError(t, err)
+	assert.Positive(t, created.ID)
+	AssertEqualQuote(t, newQuote, created)
+
+	quote, err := quoteService.GetQuote(ctx, db, created.ID)
+	require.NoError(t, err)
+	AssertEqualQuote(t, newQuote, quote)
+}
@@ -0,0 +1,85 @@
+package quotes_test
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/anmho/idempotent-rides/pricing"
+	"github.com/anmho/idempotent-rides/quotes"
+	"github.com/anmho/idempotent-rides/rides"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestQuote_IsExpired(t *testing.T) {
+	quote := quotes.New(rides.Coordinate{}, rides.Coordinate{}, pricing.Fare{}, time.Minute)
+
+	assert.False(t, quote.IsExpired(quote.CreatedAt))
+	assert.False(t, quote.IsExpired(quote.ExpiresAt.Add(-time.Second)))
+	assert.True(t, quote.IsExpired(quote.ExpiresAt))
+
+	defaultTTL := quotes.New(rides.Coordinate{}, rides.Coordinate{}, pricing.Fare{}, 0)
+	assert.Equal(t, quotes.DefaultTTL, defaultTTL.ExpiresAt.Sub(defaultTTL.CreatedAt))
+}
+
+func TestQuote_Matches(t *testing.T) {
+	origin := rides.Coordinate{Lat: 37.7749, Long: -122.4194}
+	target := rides.Coordinate{Lat: 37.8044, Long: -122.2712}
+	quote := quotes.New(origin, target, pricing.Fare{}, time.Minute)
+
+	tests := []struct {
+		desc   string
+		origin rides.Coordinate
+		target rides.Coordinate
+
+		expected bool
+	}{
+		{
+			desc:   "happy path: same coordinates",
+			origin: origin,
+			target: target,
+
+			expected: true,
+		},
+		{
+			desc:   "happy path: coordinates rounded by the database",
+			origin: rides.Coordinate{Lat: 37.77490000001, Long: -122.41940000004},
+			target: target,
+
+			expected: true,
+		},
+		{
+			desc:   "error path: other target",
+			origin: origin,
+			target: rides.Coordinate{Lat: 37.8044, Long: -122.2713},
+
+			expected: false,
+		},
+		{
+			desc:   "error path: origin and target swapped",
+			origin: target,
+			target: origin,
+
+			expected: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.Equal(t, tc.expected, quote.Matches(tc.origin, tc.target))
+		})
+	}
+}
+
+func TestConfig_String(t *testing.T) {
+	cfg := quotes.Config{SigningKey: "very-secret", TTL: time.Minute}
+
+	assert.NotContains(t, fmt.Sprintf("%+v", cfg), "very-secret")
+	assert.NotContains(t, fmt.Sprintf("%+v", struct{ Quotes quotes.Config }{cfg}), "very-secret")
+
+	var logs bytes.Buffer
+	slog.New(slog.NewTextHandler(&logs, nil)).Info("config", slog.Any("quotes", cfg))
+	assert.NotContains(t, logs.String(), "very-secret")
+	assert.Contains(t, logs.String(), "quotes.ttl=1m0s")
+}
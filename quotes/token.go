@@ -0,0 +1,58 @@
+package quotes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidID is returned for quote IDs that weren't issued by the Signer.
+var ErrInvalidID = errors.New("invalid quote id")
+
+// Signer turns the database IDs of quotes into the IDs handed to clients, like
+// 12.Gk9..., and back. The signature keeps clients from booking quotes by guessing
+// their IDs.
+type Signer struct {
+	key []byte
+}
+
+// MakeSigner returns a Signer that signs with key. A Signer with an empty key rejects
+// every ID.
+func MakeSigner(key string) *Signer {
+	return &Signer{key: []byte(key)}
+}
+
+// Sign returns the client ID of the quote with the database ID id.
+func (s *Signer) Sign(id int) string {
+	return fmt.Sprintf("%d.%s", id, s.signature(id))
+}
+
+// Verify returns the database ID of the quote with the client ID token.
+func (s *Signer) Verify(token string) (int, error) {
+	if len(s.key) == 0 {
+		return 0, fmt.Errorf("%w: quotes are disabled", ErrInvalidID)
+	}
+
+	rawID, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, ErrInvalidID
+	}
+	id, err := strconv.Atoi(rawID)
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidID
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(id))) {
+		return 0, ErrInvalidID
+	}
+	return id, nil
+}
+
+func (s *Signer) signature(id int) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "quote:%d", id)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
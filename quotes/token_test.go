@@ -0,0 +1,75 @@
+package quotes_test
+
+import (
+	"github.com/anmho/idempotent-rides/quotes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSigner_Verify(t *testing.T) {
+	signer := quotes.MakeSigner("test-key")
+
+	tests := []struct {
+		desc   string
+		signer *quotes.Signer
+		token  string
+
+		expectedID  int
+		expectedErr error
+	}{
+		{
+			desc:   "happy path: signed id",
+			signer: signer,
+			token:  signer.Sign(42),
+
+			expectedID: 42,
+		},
+		{
+			desc:   "error path: signed with another key",
+			signer: signer,
+			token:  quotes.MakeSigner("other-key").Sign(42),
+
+			expectedErr: quotes.ErrInvalidID,
+		},
+		{
+			desc:   "error path: signature of another id",
+			signer: signer,
+			token:  "43" + signer.Sign(42)[2:],
+
+			expectedErr: quotes.ErrInvalidID,
+		},
+		{
+			desc:   "error path: no signature",
+			signer: signer,
+			token:  "42",
+
+			expectedErr: quotes.ErrInvalidID,
+		},
+		{
+			desc:   "error path: id isn't a number",
+			signer: signer,
+			token:  "abc.def",
+
+			expectedErr: quotes.ErrInvalidID,
+		},
+		{
+			desc:   "error path: signer without a key",
+			signer: quotes.MakeSigner(""),
+			token:  quotes.MakeSigner("").Sign(42),
+
+			expectedErr: quotes.ErrInvalidID,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			id, err := tc.signer.Verify(tc.token)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedID, id)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package refunds
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type Status string
+
+const (
+	// StatusPending is a refund that wasn't issued yet. It counts against what can
+	// still be refunded so that concurrent refunds can't add up to more than the fare.
+	StatusPending Status = "pending"
+	// StatusSucceeded is a refund the processor issued.
+	StatusSucceeded Status = "succeeded"
+	// StatusFailed is a refund the processor refused.
+	StatusFailed Status = "failed"
+)
+
+// Reasons accepted by the processor.
+const (
+	ReasonDuplicate           = "duplicate"
+	ReasonFraudulent          = "fraudulent"
+	ReasonRequestedByCustomer = "requested_by_customer"
+)
+
+// IsValidReason reports whether reason can be given for a refund. Empty means no
+// reason.
+func IsValidReason(reason string) bool {
+	switch reason {
+	case "", ReasonDuplicate, ReasonFraudulent, ReasonRequestedByCustomer:
+		return true
+	default:
+		return false
+	}
+}
+
+type Refund struct {
+	ID        int
+	CreatedAt time.Time
+	// IdempotencyKeyID references the key of the request that created the refund, so
+	// that later phases can pick it up. NULL once the key was reaped.
+	IdempotencyKeyID sql.Null[int]
+	RideID           int
+	UserID           int
+	// Amount is in the smallest unit of Currency, like cents.
+	Amount   int64
+	Currency string
+	Reason   sql.Null[string]
+	Status   Status
+	// ID of the Stripe refund like re_123; NULL until we have one
+	StripeRefundID sql.Null[string]
+}
+
+func New(idempotencyKeyID, rideID, userID int, amount int64, currency, reason string) (*Refund, error) {
+	if amount <= 0 {
+		return nil, errors.New("invalid amount")
+	}
+
+	if !IsValidReason(reason) {
+		return nil, errors.New("invalid reason")
+	}
+
+	return &Refund{
+		ID:        -1,
+		CreatedAt: time.Now(),
+		IdempotencyKeyID: sql.Null[int]{
+			V:     idempotencyKeyID,
+			Valid: true,
+		},
+		RideID:   rideID,
+		UserID:   userID,
+		Amount:   amount,
+		Currency: currency,
+		Reason:   sql.Null[string]{V: reason, Valid: reason != ""},
+		Status:   StatusPending,
+	}, nil
+}
@@ -0,0 +1,129 @@
+package refunds
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type Service interface {
+	// GetRefundByIdempotencyKey returns the refund of a ride created with the
+	// idempotency key. Phases use it to pick up the refund created by an earlier phase.
+	GetRefundByIdempotencyKey(ctx context.Context, tx *sql.Tx, rideID, idempotencyKeyID int) (*Refund, error)
+	// GetRefundedAmount returns how much of a ride was refunded or is being refunded.
+	GetRefundedAmount(ctx context.Context, tx *sql.Tx, rideID int) (int64, error)
+	CreateRefund(ctx context.Context, tx *sql.Tx, refund *Refund) (*Refund, error)
+	UpdateRefund(ctx context.Context, tx *sql.Tx, refund *Refund) (*Refund, error)
+}
+
+func MakeService() Service {
+	return &service{}
+}
+
+var _ Service = (*service)(nil)
+
+type service struct {
+}
+
+const refundColumns = `
+	id, created_at, idempotency_key_id,
+	ride_id, user_id,
+	amount, currency, reason,
+	status, stripe_refund_id`
+
+func scanRefund(row interface{ Scan(dest ...any) error }) (*Refund, error) {
+	var refund Refund
+	err := row.Scan(
+		&refund.ID, &refund.CreatedAt, &refund.IdempotencyKeyID,
+		&refund.RideID, &refund.UserID,
+		&refund.Amount, &refund.Currency, &refund.Reason,
+		&refund.Status, &refund.StripeRefundID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+func (s *service) GetRefundByIdempotencyKey(ctx context.Context, tx *sql.Tx, rideID, idempotencyKeyID int) (*Refund, error) {
+	row := tx.QueryRowContext(ctx,
+		`
+	SELECT`+refundColumns+`
+	FROM rocket_rides.public.refunds
+	WHERE ride_id = $1 AND idempotency_key_id = $2
+	;
+	`,
+		rideID, idempotencyKeyID,
+	)
+	return scanRefund(row)
+}
+
+func (s *service) GetRefundedAmount(ctx context.Context, tx *sql.Tx, rideID int) (int64, error) {
+	var amount int64
+	err := tx.QueryRowContext(ctx,
+		`
+	SELECT COALESCE(SUM(amount), 0)
+	FROM rocket_rides.public.refunds
+	WHERE ride_id = $1 AND status IN ($2, $3)
+	;
+	`,
+		rideID, StatusPending, StatusSucceeded,
+	).Scan(&amount)
+	if err != nil {
+		return 0, fmt.Errorf("summing refunds: %w", err)
+	}
+	return amount, nil
+}
+
+func (s *service) CreateRefund(ctx context.Context, tx *sql.Tx, refund *Refund) (*Refund, error) {
+	row := tx.QueryRowContext(ctx,
+		`
+	INSERT INTO rocket_rides.public.refunds (
+		idempotency_key_id,
+		ride_id, user_id,
+		amount, currency, reason,
+		status, stripe_refund_id
+	) VALUES (
+		$1,
+		$2, $3,
+		$4, $5, $6,
+		$7, $8
+	)
+	RETURNING`+refundColumns+`
+	;
+	`,
+		refund.IdempotencyKeyID,
+		refund.RideID, refund.UserID,
+		refund.Amount, refund.Currency, refund.Reason,
+		refund.Status, refund.StripeRefundID,
+	)
+
+	created, err := scanRefund(row)
+	if err != nil {
+		return nil, fmt.Errorf("creating refund: %w", err)
+	}
+	return created, nil
+}
+
+func (s *service) UpdateRefund(ctx context.Context, tx *sql.Tx, refund *Refund) (*Refund, error) {
+	row := tx.QueryRowContext(ctx,
+		`
+	UPDATE rocket_rides.public.refunds
+	SET
+		status = $2,
+		stripe_refund_id = $3
+	WHERE id = $1
+	RETURNING`+refundColumns+`
+	;
+	`,
+		refund.ID,
+		refund.Status,
+		refund.StripeRefundID,
+	)
+
+	updated, err := scanRefund(row)
+	if err != nil {
+		return nil, fmt.Errorf("updating refund: %w", err)
+	}
+	return updated, nil
+}
@@ -0,0 +1,101 @@
+package refunds_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/anmho/idempotent-rides/refunds"
+	"github.com/anmho/idempotent-rides/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const (
+	// testRideID is the seeded ride with a charge of 500 and a refund of 200.
+	testRideID     = 1442
+	testRideUserID = 456
+	// testIdempotencyKeyID is a seeded key without a refund.
+	testIdempotencyKeyID = 736
+)
+
+func TestRefundService_GetRefundedAmount(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		desc    string
+		rideID  int
+		refunds []*refunds.Refund
+
+		expectedAmount int64
+	}{
+		{
+			desc:   "happy path: seeded refund",
+			rideID: testRideID,
+
+			expectedAmount: 200,
+		},
+		{
+			desc:   "happy path: pending refunds count, failed ones don't",
+			rideID: testRideID,
+			refunds: []*refunds.Refund{
+				{RideID: testRideID, UserID: testRideUserID, Amount: 100, Currency: "usd", Status: refunds.StatusPending},
+				{RideID: testRideID, UserID: testRideUserID, Amount: 150, Currency: "usd", Status: refunds.StatusFailed},
+			},
+
+			expectedAmount: 300,
+		},
+		{
+			desc:   "happy path: ride without refunds",
+			rideID: 123,
+
+			expectedAmount: 0,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx := context.Background()
+			db := test.MakePostgres(t)
+			tx := test.MakeTx(t, ctx, db)
+			defer tx.Rollback()
+
+			refundService := refunds.MakeService()
+			for _, refund := range tc.refunds {
+				_, err := refundService.CreateRefund(ctx, tx, refund)
+				require.NoError(t, err)
+			}
+
+			amount, err := refundService.GetRefundedAmount(ctx, tx, tc.rideID)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedAmount, amount)
+		})
+	}
+}
+
+func TestRefundService_CreateRefund(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := test.MakePostgres(t)
+	tx := test.MakeTx(t, ctx, db)
+	defer tx.Rollback()
+	refundService := refunds.MakeService()
+
+	refund, err := refunds.New(testIdempotencyKeyID, testRideID, testRideUserID, 300, "usd", refunds.ReasonDuplicate)
+	require.NoError(t, err)
+	created, err := refundService.CreateRefund(ctx, tx, refund)
+	require.NoError(t, err)
+	assert.Positive(t, created.ID)
+	assert.Equal(t, refunds.StatusPending, created.Status)
+
+	found, err := refundService.GetRefundByIdempotencyKey(ctx, tx, testRideID, testIdempotencyKeyID)
+	require.NoError(t, err)
+	assert.Equal(t, created, found)
+
+	found.Status = refunds.StatusSucceeded
+	found.StripeRefundID = sql.Null[string]{V: "re_789", Valid: true}
+	updated, err := refundService.UpdateRefund(ctx, tx, found)
+	require.NoError(t, err)
+	assert.Equal(t, found, updated)
+
+	_, err = refundService.GetRefundByIdempotencyKey(ctx, tx, 123, testIdempotencyKeyID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
@@ -3,6 +3,7 @@ package rides
 import (
 	"database/sql"
 	"errors"
+	"github.com/anmho/idempotent-rides/pricing"
 	"time"
 )
 
@@ -27,16 +28,26 @@ type Ride struct {
 	Target Coordinate
 	// ID of Stripe charge like ch_123; NULL until we have one
 	StripeChargeID sql.Null[string]
-	UserID         int
+	// Fare is what the ride is charged, worked out when it is created.
+	Fare pricing.Fare
+	// QuoteID is the quote the fare was taken from; NULL when the ride was priced
+	// when it was reserved.
+	QuoteID sql.Null[int]
+	UserID  int
 }
 
-func New(idempotencyKeyID int, target, origin Coordinate, userID int) (*Ride, error) {
+// Point returns c as a point to price rides by.
+func (c Coordinate) Point() pricing.Point {
+	return pricing.Point{Lat: c.Lat, Long: c.Long}
+}
+
+func New(idempotencyKeyID int, origin, target Coordinate, fare pricing.Fare, userID int) (*Ride, error) {
 	// do ride validation here
-	if !target.IsValid() {
-		return nil, errors.New("invalid target")
+	if !origin.IsValid() {
+		return nil, errors.New("invalid origin")
 	}
 
-	if !origin.IsValid() {
+	if !target.IsValid() {
 		return nil, errors.New("invalid target")
 	}
 
@@ -50,6 +61,7 @@ func New(idempotencyKeyID int, target, origin Coordinate, userID int) (*Ride, er
 		Origin:         origin,
 		Target:         target,
 		StripeChargeID: sql.Null[string]{},
+		Fare:           fare,
 		UserID:         userID,
 	}, nil
 }
@@ -9,8 +9,18 @@ import (
 	"log/slog"
 )
 
+// ErrQuoteUsed is returned by CreateRide for a ride with a quote that already booked
+// another ride.
+var ErrQuoteUsed = errors.New("quote was already used")
+
+const (
+	uniqueViolationCode = "23505"
+	quoteIDConstraint   = "rides_quote_id_key"
+)
+
 type Service interface {
 	GetRide(ctx context.Context, tx *sql.Tx, rideID int) (*Ride, error)
+	GetRideByIdempotencyKey(ctx context.Context, tx *sql.Tx, userID, idempotencyKeyID int) (*Ride, error)
 	CreateRide(ctx context.Context, tx *sql.Tx, ride *Ride) (*Ride, error)
 	UpdateRide(ctx context.Context, tx *sql.Tx, ride *Ride) (*Ride, error)
 	DeleteRide(ctx context.Context, tx *sql.Tx, rideID int) (bool, error)
@@ -30,7 +40,7 @@ func (rs *service) GetRide(ctx context.Context, tx *sql.Tx, rideID int) (*Ride,
 		id, created_at, idempotency_key_id, 
 		origin_lat, origin_lon, 
 		target_lat, target_lon, 
-		stripe_charge_id, user_id
+		stripe_charge_id, fare_amount, fare_currency, fare_line_items, quote_id, user_id
 	FROM rocket_rides.public.rides
 	WHERE id = $1
 	;
@@ -46,7 +56,41 @@ func (rs *service) GetRide(ctx context.Context, tx *sql.Tx, rideID int) (*Ride,
 		&ride.ID, &ride.CreatedAt, &ride.IdempotencyKeyID,
 		&ride.Origin.Lat, &ride.Origin.Long,
 		&ride.Target.Lat, &ride.Target.Long,
-		&ride.StripeChargeID, &ride.UserID,
+		&ride.StripeChargeID, &ride.Fare.Amount, &ride.Fare.Currency, &ride.Fare.LineItems, &ride.QuoteID, &ride.UserID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &ride, nil
+}
+
+// GetRideByIdempotencyKey returns the ride the user created with the idempotency key.
+// Phases use it to pick up the ride created by an earlier phase, possibly in another
+// request or process.
+func (rs *service) GetRideByIdempotencyKey(ctx context.Context, tx *sql.Tx, userID, idempotencyKeyID int) (*Ride, error) {
+	stmt, err := tx.PrepareContext(ctx,
+		`
+	SELECT 
+		id, created_at, idempotency_key_id, 
+		origin_lat, origin_lon, 
+		target_lat, target_lon, 
+		stripe_charge_id, fare_amount, fare_currency, fare_line_items, quote_id, user_id
+	FROM rocket_rides.public.rides
+	WHERE user_id = $1 AND idempotency_key_id = $2
+	;
+	`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	var ride Ride
+	err = stmt.QueryRowContext(ctx, userID, idempotencyKeyID).Scan(
+		&ride.ID, &ride.CreatedAt, &ride.IdempotencyKeyID,
+		&ride.Origin.Lat, &ride.Origin.Long,
+		&ride.Target.Lat, &ride.Target.Long,
+		&ride.StripeChargeID, &ride.Fare.Amount, &ride.Fare.Currency, &ride.Fare.LineItems, &ride.QuoteID, &ride.UserID,
 	)
 	if err != nil {
 		return nil, err
@@ -61,18 +105,18 @@ func (rs *service) CreateRide(ctx context.Context, tx *sql.Tx, ride *Ride) (*Rid
 		idempotency_key_id, 
 		origin_lat, origin_lon, 
 		target_lat, target_lon, 
-		stripe_charge_id, user_id
+		stripe_charge_id, fare_amount, fare_currency, fare_line_items, quote_id, user_id
 	) VALUES (
 		$1, 
 		$2, $3, 
 		$4, $5,
-		$6, $7
+		$6, $7, $8, $9, $10, $11
 	)
 	RETURNING 
 	    id, created_at, idempotency_key_id, 
 	    origin_lat, origin_lon, 
 	    target_lat, target_lon, 
-	    stripe_charge_id, user_id 
+	    stripe_charge_id, fare_amount, fare_currency, fare_line_items, quote_id, user_id 
 	; 
 	`,
 	)
@@ -86,17 +130,20 @@ func (rs *service) CreateRide(ctx context.Context, tx *sql.Tx, ride *Ride) (*Rid
 		&ride.IdempotencyKeyID,
 		&ride.Origin.Lat, &ride.Origin.Long,
 		&ride.Target.Lat, &ride.Target.Long,
-		&ride.StripeChargeID, &ride.UserID,
+		&ride.StripeChargeID, ride.Fare.Amount, ride.Fare.Currency, ride.Fare.LineItems, ride.QuoteID, &ride.UserID,
 	).Scan(
 		&newRide.ID, &newRide.CreatedAt, &newRide.IdempotencyKeyID,
 		&newRide.Origin.Lat, &newRide.Origin.Long,
 		&newRide.Target.Lat, &newRide.Target.Long,
-		&newRide.StripeChargeID, &newRide.UserID,
+		&newRide.StripeChargeID, &newRide.Fare.Amount, &newRide.Fare.Currency, &newRide.Fare.LineItems, &newRide.QuoteID, &newRide.UserID,
 	)
 	if err != nil {
 
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
+			if pgErr.Code == uniqueViolationCode && pgErr.ConstraintName == quoteIDConstraint {
+				return nil, ErrQuoteUsed
+			}
 			scope.GetLogger().Error("pgerror", slog.Any("error", pgErr))
 		}
 		return nil, err
@@ -115,13 +162,17 @@ func (rs *service) UpdateRide(ctx context.Context, tx *sql.Tx, ride *Ride) (*Rid
 		target_lat = $5,
 		target_lon = $6,
 		stripe_charge_id = $7,
-		user_id = $8
+		user_id = $8,
+		fare_amount = $9,
+		fare_currency = $10,
+		fare_line_items = $11,
+		quote_id = $12
 	WHERE id = $1
 	RETURNING 
 	    id, created_at, idempotency_key_id, 
 	    origin_lat, origin_lon, 
 	    target_lat, target_lon, 
-	    stripe_charge_id, user_id 
+	    stripe_charge_id, fare_amount, fare_currency, fare_line_items, quote_id, user_id 
 	`,
 	)
 
@@ -136,8 +187,11 @@ func (rs *service) UpdateRide(ctx context.Context, tx *sql.Tx, ride *Ride) (*Rid
 		ride.IdempotencyKeyID,             // $2
 		ride.Origin.Lat, ride.Origin.Long, // $3, $4
 		ride.Target.Lat, ride.Target.Long, // $5, $6
-		ride.StripeChargeID, // $7
-		ride.UserID,         // $8
+		ride.StripeChargeID,                  // $7
+		ride.UserID,                          // $8
+		ride.Fare.Amount, ride.Fare.Currency, // $9, $10
+		ride.Fare.LineItems, // $11
+		ride.QuoteID,        // $12
 	).Scan(
 		&updatedRide.ID,
 		&updatedRide.CreatedAt,
@@ -147,6 +201,10 @@ func (rs *service) UpdateRide(ctx context.Context, tx *sql.Tx, ride *Ride) (*Rid
 		&updatedRide.Target.Lat,
 		&updatedRide.Target.Long,
 		&updatedRide.StripeChargeID,
+		&updatedRide.Fare.Amount,
+		&updatedRide.Fare.Currency,
+		&updatedRide.Fare.LineItems,
+		&updatedRide.QuoteID,
 		&updatedRide.UserID,
 	)
 	if err != nil {
@@ -3,6 +3,7 @@ package rides_test
 import (
 	"context"
 	"database/sql"
+	"github.com/anmho/idempotent-rides/pricing"
 	"github.com/anmho/idempotent-rides/rides"
 	"github.com/anmho/idempotent-rides/test"
 	"github.com/anmho/idempotent-rides/users"
@@ -12,6 +13,16 @@ import (
 )
 
 var (
+	// TestFare is the seeded fare of ride 1442.
+	TestFare = pricing.Fare{
+		Amount:   500,
+		Currency: "usd",
+		LineItems: pricing.LineItems{
+			{Description: "Base fare", Amount: 250},
+			{Description: "Distance (0.0 km)", Amount: 0},
+			{Description: "Minimum fare", Amount: 250},
+		},
+	}
 	TestExistingRide = &rides.Ride{
 		ID: 1442,
 		IdempotencyKeyID: sql.Null[int]{
@@ -30,6 +41,7 @@ var (
 			V:     "ch_456",
 			Valid: true,
 		},
+		Fare:   TestFare,
 		UserID: *users.TestUser2ID,
 	}
 	TestNewRide = &rides.Ride{
@@ -44,6 +56,7 @@ var (
 			Long: 72,
 		},
 		StripeChargeID: sql.Null[string]{},
+		Fare:           TestFare,
 		UserID:         *users.TestUser2ID,
 	}
 )
@@ -53,6 +66,7 @@ func AssertEqualRide(t *testing.T, expected, ride *rides.Ride) {
 	assert.Equal(t, expected.StripeChargeID, ride.StripeChargeID)
 	assert.Equal(t, expected.Origin, ride.Origin)
 	assert.Equal(t, expected.Target, ride.Target)
+	assert.Equal(t, expected.Fare, ride.Fare)
 }
 
 func TestRideService_GetRide(t *testing.T) {
@@ -105,6 +119,53 @@ func TestRideService_GetRide(t *testing.T) {
 	}
 }
 
+func TestRideService_GetRideByIdempotencyKey(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		desc             string
+		userID           int
+		idempotencyKeyID int
+
+		expectedRide *rides.Ride
+		expectedErr  bool
+	}{
+		{
+			desc:             "happy path: get the ride a user created with an idempotency key",
+			userID:           TestExistingRide.UserID,
+			idempotencyKeyID: TestExistingRide.IdempotencyKeyID.V,
+
+			expectedRide: TestExistingRide,
+		},
+		{
+			desc:             "error path: key exists but the user did not create a ride with it",
+			userID:           *users.TestUser1ID,
+			idempotencyKeyID: 737,
+
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			ctx := context.Background()
+			db := test.MakePostgres(t)
+			tx := test.MakeTx(t, ctx, db)
+
+			rideService := rides.MakeService()
+			ride, err := rideService.GetRideByIdempotencyKey(ctx, tx, tc.userID, tc.idempotencyKeyID)
+
+			if tc.expectedErr {
+				assert.Error(t, err)
+				assert.Nil(t, ride)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, ride)
+				AssertEqualRide(t, tc.expectedRide, ride)
+			}
+		})
+	}
+}
+
 func TestRideService_CreateRide(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -165,6 +226,7 @@ func TestRideService_UpdateRide(t *testing.T) {
 			Lat:  100,
 			Long: 100,
 		},
+		Fare:   TestFare,
 		UserID: 456,
 	}
 	tests := []struct {
@@ -191,6 +253,7 @@ func TestRideService_UpdateRide(t *testing.T) {
 					Long: TestExistingRide.Target.Long + 10.0,
 				},
 				StripeChargeID: sql.Null[string]{},
+				Fare:           TestExistingRide.Fare,
 				UserID:         TestExistingRide.UserID,
 			},
 
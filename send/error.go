@@ -9,7 +9,10 @@ import (
 )
 
 type HTTPError struct {
-	Cause   error  `json:"error,omitempty"`
+	Cause error `json:"error,omitempty"`
+	// Code is a stable, machine-readable identifier for the error, for example
+	// "idempotency_key_reused".
+	Code    string `json:"code,omitempty"`
 	Message string `json:"message"`
 	Status  int    `json:"status"`
 }
@@ -17,6 +20,9 @@ type HTTPError struct {
 var _ error = (*HTTPError)(nil)
 
 func (e HTTPError) Error() string {
+	if e.Cause == nil {
+		return fmt.Sprintf("HTTPError status %d: %s", e.Status, e.Message)
+	}
 	return fmt.Sprintf("HTTPError status %d: %s caused by %s", e.Status, e.Message, e.Cause.Error())
 }
 
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 )
 
 func WriteJSON[T any](w http.ResponseWriter, status int, data T) error {
@@ -13,6 +14,17 @@ func WriteJSON[T any](w http.ResponseWriter, status int, data T) error {
 	return json.NewEncoder(w).Encode(data)
 }
 
+// WriteRaw writes body as is with the given status and headers. Headers already set
+// on w are overwritten by those with the same name in header.
+func WriteRaw(w http.ResponseWriter, status int, header http.Header, body []byte) error {
+	for name, values := range header {
+		w.Header()[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+	}
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+	return err
+}
+
 func Read[T any](data io.ReadCloser) (T, error) {
 	var v T
 	if err := json.NewDecoder(data).Decode(&v); err != nil {
@@ -22,6 +22,8 @@ CREATE TABLE idempotency_keys (
           CHECK (char_length(idempotency_key) <= 100),
       last_run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
       locked_at TIMESTAMPTZ DEFAULT now(),
+    -- number of requests that ran the key, bumped with last_run_at on every resumption
+      attempts INT NOT NULL DEFAULT 1 CHECK (attempts > 0),
 
     -- parameters of the incoming request
       request_method TEXT NOT NULL
@@ -30,18 +32,50 @@ CREATE TABLE idempotency_keys (
       request_path TEXT NOT NULL
           CHECK (char_length(request_path) <= 100),
 
-    -- for finished requests, stored status code and body
+    -- for finished requests, stored status code, headers and the exact body bytes
       response_code INT NULL,
-      response_body JSONB NULL,
+      response_headers JSONB NULL,
+      response_body BYTEA NULL,
 
       recovery_point TEXT NOT NULL
         CHECK (char_length(recovery_point) <= 50),
-      user_id BIGINT NOT NULL
+    -- NULL for requests that are not made on behalf of a user, like registration
+      user_id BIGINT NULL
 );
 
 CREATE UNIQUE INDEX idempotency_keys_user_id_idempotency_key
     ON idempotency_keys (user_id, idempotency_key);
 
+-- Keys without a user are unique on the key alone
+CREATE UNIQUE INDEX idempotency_keys_idempotency_key_without_user
+    ON idempotency_keys (idempotency_key)
+    WHERE user_id IS NULL;
+
+--
+-- A relation that records every recovery point an idempotency key moved through,
+-- written in the same transaction as the key. Failed phases are recorded with the
+-- error and a to_recovery_point equal to from_recovery_point.
+--
+CREATE TABLE idempotency_key_transitions (
+    id BIGSERIAL PRIMARY KEY,
+    idempotency_key_id BIGINT NOT NULL
+        REFERENCES idempotency_keys(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    from_recovery_point TEXT NOT NULL
+        CHECK (char_length(from_recovery_point) <= 50),
+    to_recovery_point TEXT NOT NULL
+        CHECK (char_length(to_recovery_point) <= 50),
+
+    -- time spent in the phase, including retries of serialization failures
+    duration_ms BIGINT NOT NULL CHECK (duration_ms >= 0),
+    attempts INT NOT NULL CHECK (attempts > 0),
+    error TEXT NULL
+);
+
+CREATE INDEX idempotency_key_transitions_idempotency_key_id
+    ON idempotency_key_transitions (idempotency_key_id, id);
+
 --
 -- Now that we have a users table, add a foreign key
 -- constraint to idempotency_keys which we created above.
@@ -74,10 +108,32 @@ CREATE TABLE audit_records (
     resource_type TEXT NOT NULL
         CHECK (char_length(resource_type) <= 50),
 
-    user_id BIGINT NOT NULL
+    -- NULL for actions taken by operators through the admin API
+    user_id BIGINT NULL
         REFERENCES users ON DELETE RESTRICT
 );
 
+--
+-- A relation that holds fares quoted to riders before they book. A quote locks
+-- in its fare for a ride between the same coordinates until it expires.
+--
+CREATE TABLE quotes (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+
+    origin_lat NUMERIC(13, 10) NOT NULL,
+    origin_lon NUMERIC(13, 10) NOT NULL,
+    target_lat NUMERIC(13, 10) NOT NULL,
+    target_lon NUMERIC(13, 10) NOT NULL,
+
+    fare_amount BIGINT NOT NULL
+       CHECK (fare_amount >= 0),
+    fare_currency TEXT NOT NULL
+       CHECK (char_length(fare_currency) = 3),
+    fare_line_items JSONB NOT NULL DEFAULT '[]'
+);
+
 --
 -- A relation representing a single ride by a user.
 -- Notably, it holds the ID of a successful charge to
@@ -103,6 +159,17 @@ CREATE TABLE rides (
     stripe_charge_id TEXT UNIQUE
        CHECK (char_length(stripe_charge_id) <= 50),
 
+    -- fare charged for the ride in the smallest unit of fare_currency, and the
+    -- line items it adds up from
+    fare_amount BIGINT NOT NULL
+       CHECK (fare_amount >= 0),
+    fare_currency TEXT NOT NULL
+       CHECK (char_length(fare_currency) = 3),
+    fare_line_items JSONB NOT NULL DEFAULT '[]',
+    -- quote the fare was taken from, which books at most one ride; NULL when the
+    -- ride was priced when it was reserved
+    quote_id BIGINT NULL UNIQUE
+       REFERENCES quotes(id) ON DELETE SET NULL,
 
     user_id BIGINT NOT NULL
        REFERENCES users(id) ON DELETE RESTRICT,
@@ -115,6 +182,43 @@ CREATE INDEX rides_idempotency_key_id
     ON rides (idempotency_key_id)
     WHERE idempotency_key_id IS NOT NULL;
 
+--
+-- A relation that holds every refund of a ride, issued against its
+-- stripe_charge_id. Pending refunds count against what can still be refunded.
+--
+CREATE TABLE refunds (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    -- key of the request that created the refund, so that later phases can
+    -- pick it up; SET NULL when the key is reaped
+    idempotency_key_id BIGINT
+        REFERENCES idempotency_keys(id) ON DELETE SET NULL,
+    ride_id BIGINT NOT NULL
+        REFERENCES rides(id) ON DELETE RESTRICT,
+    user_id BIGINT NOT NULL
+        REFERENCES users(id) ON DELETE RESTRICT,
+
+    -- refunded amount in the smallest unit of currency
+    amount BIGINT NOT NULL
+        CHECK (amount > 0),
+    currency TEXT NOT NULL
+        CHECK (char_length(currency) = 3),
+    reason TEXT NULL
+        CHECK (reason IN ('duplicate', 'fraudulent', 'requested_by_customer')),
+
+    status TEXT NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'succeeded', 'failed')),
+    -- ID of Stripe refund like re_123; NULL until we have one
+    stripe_refund_id TEXT UNIQUE
+        CHECK (char_length(stripe_refund_id) <= 50),
+
+    CONSTRAINT refunds_ride_id_idempotency_key_unique UNIQUE (ride_id, idempotency_key_id)
+);
+
+CREATE INDEX refunds_ride_id
+    ON refunds (ride_id);
+
 --
 -- A relation that holds our transactionally-staged jobs
 --
@@ -123,3 +227,76 @@ CREATE TABLE staged_jobs (
     job_name TEXT NOT NULL,
     job_args JSONB NOT NULL
 );
+
+--
+-- A relation that holds the work queue. The enqueuer moves jobs here from
+-- staged_jobs once the transaction that staged them has committed.
+--
+CREATE TABLE jobs (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    job_name TEXT NOT NULL,
+    job_args JSONB NOT NULL,
+
+    -- when the job can next be worked; pushed back after every failed attempt
+    run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- a worker holds the job until then; NULL when nobody does
+    locked_until TIMESTAMPTZ NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NULL
+);
+
+CREATE INDEX jobs_run_at
+    ON jobs (run_at, id);
+
+--
+-- A relation that holds jobs that failed permanently or ran out of attempts,
+-- for an operator to inspect and retry by hand.
+--
+CREATE TABLE dead_jobs (
+    id BIGINT PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL,
+    died_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    job_name TEXT NOT NULL,
+    job_args JSONB NOT NULL,
+    attempts INT NOT NULL,
+    last_error TEXT NULL
+);
+
+--
+-- A relation that holds every email we sent or tried to send, so support can
+-- tell whether a customer got it.
+--
+CREATE TABLE email_messages (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    -- NULL for emails that aren't sent to a user
+    user_id BIGINT NULL
+        REFERENCES users ON DELETE RESTRICT,
+    -- identifies an email that must be sent at most once, for example the
+    -- receipt of a ride, so retried jobs don't send it again
+    dedupe_key TEXT NULL UNIQUE
+        CHECK (char_length(dedupe_key) <= 255),
+
+    recipient TEXT NOT NULL
+        CHECK (char_length(recipient) <= 255),
+    template TEXT NOT NULL
+        CHECK (char_length(template) <= 100),
+    locale TEXT NOT NULL
+        CHECK (char_length(locale) <= 20),
+    subject TEXT NOT NULL,
+
+    status TEXT NOT NULL DEFAULT 'queued'
+        CHECK (status IN ('queued', 'sent', 'failed', 'bounced')),
+    -- ID the email provider assigned to the message, like its Message-ID
+    provider_message_id TEXT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NULL,
+    sent_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX email_messages_user_id
+    ON email_messages (user_id, created_at DESC)
+    WHERE user_id IS NOT NULL;
@@ -56,26 +56,54 @@ INSERT INTO idempotency_keys (
 INSERT INTO idempotency_keys (
     id, idempotency_key,
     request_method, request_params, request_path,
-    response_code, response_body,
+    response_code, response_headers, response_body,
     recovery_point, user_id
 ) VALUES (
     739, 'testKeyFinished',
     'POST', '{}', '/rides',
-    201, '{}',
+    201, '{"Content-Type": ["application/json"]}', '{}',
     'finished', 123
 );
 
 
+-- Quote that can still be booked
+INSERT INTO quotes (
+    id, expires_at,
+    origin_lat, origin_lon,
+    target_lat, target_lon,
+    fare_amount, fare_currency, fare_line_items
+) VALUES (
+    900, '2999-01-01 00:00:00+00',
+    0, 0,
+    0, 1,
+    16929, 'usd', '[{"description": "Base fare", "amount": 250}, {"description": "Distance (111.2 km)", "amount": 16679}]'
+);
+
+-- Quote that expired
+INSERT INTO quotes (
+    id, expires_at,
+    origin_lat, origin_lon,
+    target_lat, target_lon,
+    fare_amount, fare_currency, fare_line_items
+) VALUES (
+    901, '2000-01-01 00:00:00+00',
+    0, 0,
+    0, 1,
+    16929, 'usd', '[{"description": "Base fare", "amount": 250}, {"description": "Distance (111.2 km)", "amount": 16679}]'
+);
+
 -- Ride where the charge hasn't been created yet
 INSERT INTO rides (
     id, idempotency_key_id,
     origin_lat, origin_lon,
     target_lat, target_lon,
+    fare_amount, fare_currency, fare_line_items,
     user_id
 ) VALUES (
     123, 738,
     1, 2,
     3, 4,
+    47410, 'usd', '[{"description": "Base fare", "amount": 250}, {"description": "Distance (314.4 km)", "amount": 47160}]',
     123
  );
 
@@ -85,15 +113,28 @@ INSERT INTO rides (
     origin_lat, origin_lon,
     target_lat, target_lon,
     stripe_charge_id,
+    fare_amount, fare_currency, fare_line_items,
     user_id
 ) VALUES (
     1442, 738,
     72, 72,
     72, 72,
     'ch_456',
+    500, 'usd', '[{"description": "Base fare", "amount": 250}, {"description": "Distance (0.0 km)", "amount": 0}, {"description": "Minimum fare", "amount": 250}]',
     456
 );
 
+-- Partial refund of ride 1442
+INSERT INTO refunds (
+    id, ride_id, user_id,
+    amount, currency, reason,
+    status, stripe_refund_id
+) VALUES (
+    1600, 1442, 456,
+    200, 'usd', 'requested_by_customer',
+    'succeeded', 're_456'
+);
+
 -- Test Audit Record
 INSERT INTO audit_records (
     id, action, data, origin_ip,
@@ -104,4 +145,14 @@ INSERT INTO audit_records (
     1441, 'ride',
     123
 )
-;
\ No newline at end of file
+;
+-- Receipt that bounced
+INSERT INTO email_messages (
+    id, user_id, dedupe_key,
+    recipient, template, locale, subject,
+    status, attempts, last_error
+) VALUES (
+    5150, 123, 'ride_receipt:1441',
+    'awesome-user@email.com', 'ride_receipt', 'en', 'Your Rocket Rides receipt for ride #1441',
+    'bounced', 1, '550 mailbox unavailable'
+);
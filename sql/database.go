@@ -3,9 +3,30 @@ package database
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
 type DB interface {
 	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
+
+// Config holds the Postgres connection settings read from the environment by the
+// background processes.
+type Config struct {
+	DBUser string `env:"DB_USER"`
+	DBPass string `env:"DB_PASS"`
+	DBHost string `env:"DB_HOST"`
+	DBPort string `env:"DB_PORT"`
+	DBName string `env:"DB_NAME"`
+}
+
+func (c Config) ConnString() string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s", c.DBUser, c.DBPass, c.DBHost, c.DBPort, c.DBName)
+}
+
+// Open opens a connection pool to the database described by cfg.
+func Open(cfg Config) (*sql.DB, error) {
+	return sql.Open("pgx", cfg.ConnString())
+}
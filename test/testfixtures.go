@@ -4,15 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"github.com/anmho/idempotent-rides/api"
+	"github.com/anmho/idempotent-rides/payments"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/require"
-	"github.com/stripe/stripe-go/v79"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/modules/postgres"
 	"github.com/testcontainers/testcontainers-go/wait"
 	"net/http/httptest"
-	"os"
 	"testing"
 	"time"
 )
@@ -22,14 +21,25 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
-
-	stripeKey := os.Getenv("STRIPE_KEY")
-	stripe.Key = stripeKey
 }
 
 func MakeTestServer(t *testing.T) *httptest.Server {
+	return MakeTestServerWithConfig(t, api.Config{})
+}
+
+// MakeTestServerWithConfig is MakeTestServer for tests that need non-default settings,
+// like an admin token.
+func MakeTestServerWithConfig(t *testing.T, cfg api.Config) *httptest.Server {
+	return MakeTestServerWithGateway(t, cfg, payments.MakeFakeGateway())
+}
+
+// MakeTestServerWithGateway is MakeTestServerWithConfig for tests that script payment
+// failures or check what was charged through gateway.
+func MakeTestServerWithGateway(t *testing.T, cfg api.Config, gateway payments.Gateway) *httptest.Server {
 	db := MakePostgres(t)
-	rocketRides := api.MakeServer(db)
+	services, err := api.MakeServices(cfg, gateway)
+	require.NoError(t, err)
+	rocketRides := api.MakeServer(db, cfg, services)
 	srv := httptest.NewServer(rocketRides)
 	t.Cleanup(func() {
 		srv.Close()
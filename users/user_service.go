@@ -6,6 +6,15 @@ import (
 	"errors"
 	"fmt"
 	database "github.com/anmho/idempotent-rides/sql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrEmailTaken is returned when a user is created with the email of another user.
+var ErrEmailTaken = errors.New("email is already registered")
+
+const (
+	uniqueViolationCode = "23505"
+	emailConstraint     = "users_email_key"
 )
 
 type Service interface {
@@ -55,6 +64,10 @@ func (s *service) CreateUser(ctx context.Context, tx *sql.Tx, user *User) (*User
 	;
 	`
 	err := tx.QueryRowContext(ctx, query, user.Email, user.StripeCustomerID).Scan(&user.ID)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode && pgErr.ConstraintName == emailConstraint {
+		return nil, ErrEmailTaken
+	}
 	if err != nil {
 		return nil, err
 	}